@@ -0,0 +1,841 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"eddisonso.com/edd-compute/pkg/client"
+)
+
+// sshWaitTimeout bounds how long "eddc ssh" waits for a container to come up
+const sshWaitTimeout = 5 * time.Minute
+
+func commands() []*command {
+	return []*command{
+		loginCommand(),
+		{
+			name:    "containers",
+			summary: "Create and manage containers",
+			sub: []*command{
+				listContainersCommand(),
+				{
+					name: "get", args: "<name|id>", summary: "Show a container", completeNames: true,
+					run: func(ctx context.Context, a *app, args []string) error {
+						c, err := a.resolveContainer(ctx, args)
+						if err != nil {
+							return err
+						}
+						return a.out.container(c)
+					},
+				},
+				createContainerCommand(),
+				containerActionCommand("start", "Start a stopped container", (*client.Client).StartContainer),
+				containerActionCommand("stop", "Stop a container, keeping its storage", (*client.Client).StopContainer),
+				containerActionCommand("delete", "Delete a container and its storage", (*client.Client).DeleteContainer),
+				sharedContainersCommand(),
+				{
+					name: "grants", args: "<name|id>", summary: "List who a container is shared with", completeNames: true,
+					run: func(ctx context.Context, a *app, args []string) error {
+						c, err := a.resolveContainer(ctx, args)
+						if err != nil {
+							return err
+						}
+						grants, err := a.client.ListContainerGrants(ctx, c.ID)
+						if err != nil {
+							return err
+						}
+						return a.out.containerGrants(grants)
+					},
+				},
+				{
+					name: "share", args: "<name|id> <username>", summary: "Let another user start, stop and SSH into a container", completeNames: true,
+					run: func(ctx context.Context, a *app, args []string) error {
+						if len(args) != 2 {
+							return usagef("expected a container and a username")
+						}
+						c, err := a.resolveContainer(ctx, args[:1])
+						if err != nil {
+							return err
+						}
+						grant, err := a.client.GrantContainerAccess(ctx, c.ID, args[1])
+						if err != nil {
+							return err
+						}
+						return a.out.message(grant, "Shared %s with %s; their SSH keys can now log in", c.Name, args[1])
+					},
+				},
+				{
+					name: "unshare", args: "<name|id> <username>", summary: "Stop sharing a container with a user", completeNames: true,
+					run: func(ctx context.Context, a *app, args []string) error {
+						if len(args) != 2 {
+							return usagef("expected a container and a username")
+						}
+						c, err := a.resolveContainer(ctx, args[:1])
+						if err != nil {
+							return err
+						}
+						if err := a.client.RevokeContainerAccess(ctx, c.ID, args[1]); err != nil {
+							return err
+						}
+						return a.out.message(map[string]any{"id": c.ID, "username": args[1], "revoked": true},
+							"Stopped sharing %s with %s", c.Name, args[1])
+					},
+				},
+			},
+		},
+		sshCommand(),
+		{
+			name:    "ssh-keys",
+			summary: "Manage SSH keys for new containers",
+			sub: []*command{
+				listSSHKeysCommand(),
+				addSSHKeyCommand(),
+				{
+					name: "delete", args: "<id>", summary: "Delete an SSH key",
+					run: func(ctx context.Context, a *app, args []string) error {
+						id, err := parseID(args)
+						if err != nil {
+							return err
+						}
+						if err := a.client.DeleteSSHKey(ctx, id); err != nil {
+							return err
+						}
+						return a.out.message(map[string]any{"id": id, "deleted": true}, "Deleted SSH key %d", id)
+					},
+				},
+			},
+		},
+		{
+			name:    "api-keys",
+			summary: "Manage API keys",
+			sub: []*command{
+				listAPIKeysCommand(),
+				createAPIKeyCommand(),
+				rotateAPIKeyCommand(),
+				revokeAPIKeyCommand(),
+			},
+		},
+		orgsCommand(),
+		auditCommand(),
+		completionCommand(),
+		{
+			// Prints container names for shell completion
+			name: "__names", noClient: true,
+			run: func(ctx context.Context, a *app, args []string) error {
+				if !a.cfg.loggedIn() {
+					return nil
+				}
+				clientCfg := a.cfg.clientConfig()
+				clientCfg.MaxRetries = -1
+				c, err := client.New(clientCfg)
+				if err != nil {
+					return err
+				}
+				ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+				defer cancel()
+				containers, _, err := c.ListContainers(ctx, client.ContainerListOptions{ListOptions: client.ListOptions{Limit: 200}})
+				if err != nil {
+					return err
+				}
+				for _, c := range containers {
+					fmt.Println(c.Name)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func loginCommand() *command {
+	var url, apiKey string
+	return &command{
+		name: "login", summary: "Save the server URL and an API key", noClient: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&url, "url", "", "server URL (default: the saved URL, or "+defaultURL+")")
+			fs.StringVar(&apiKey, "api-key", "", "API key (default: read from stdin)")
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			if url != "" {
+				a.cfg.URL = url
+			}
+			if apiKey == "" {
+				fmt.Fprint(os.Stderr, "API key (create one in the web UI under Settings): ")
+				line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && line == "" {
+					return fmt.Errorf("read API key: %w", err)
+				}
+				apiKey = strings.TrimSpace(line)
+			}
+			a.cfg.APIKey = apiKey
+
+			// Check the key works before saving it
+			c, err := client.New(client.Config{BaseURL: a.cfg.URL, APIKey: a.cfg.APIKey, UserAgent: "eddc"})
+			if err != nil {
+				return err
+			}
+			if _, err := c.GetSettings(ctx); err != nil {
+				return fmt.Errorf("check API key: %w", err)
+			}
+
+			path, err := saveConfig(a.cfg)
+			if err != nil {
+				return err
+			}
+			return a.out.message(map[string]string{"url": a.cfg.URL, "config": path}, "Logged in to %s; saved to %s", a.cfg.URL, path)
+		},
+	}
+}
+
+func listContainersCommand() *command {
+	var status, namePrefix, org string
+	return &command{
+		name: "list", summary: "List containers",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&status, "status", "", "only containers in this state, e.g. running")
+			fs.StringVar(&namePrefix, "name", "", "only containers whose name starts with this")
+			orgFlag(fs, &org)
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+			opts := client.ContainerListOptions{
+				ListOptions: client.ListOptions{Limit: 200, NamePrefix: namePrefix, OrgID: orgID},
+				Status:      status,
+			}
+			var containers []client.Container
+			for {
+				page, next, err := a.client.ListContainers(ctx, opts)
+				if err != nil {
+					return err
+				}
+				containers = append(containers, page...)
+				if next == "" {
+					return a.out.containers(containers)
+				}
+				opts.Cursor = next
+			}
+		},
+	}
+}
+
+func sharedContainersCommand() *command {
+	var status string
+	return &command{
+		name: "shared", summary: "List containers others have shared with you",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&status, "status", "", "only containers in this state, e.g. running")
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			opts := client.ContainerListOptions{ListOptions: client.ListOptions{Limit: 200}, Status: status}
+			var containers []client.SharedContainer
+			for {
+				page, next, err := a.client.ListSharedContainers(ctx, opts)
+				if err != nil {
+					return err
+				}
+				containers = append(containers, page...)
+				if next == "" {
+					return a.out.sharedContainers(containers)
+				}
+				opts.Cursor = next
+			}
+		},
+	}
+}
+
+func createContainerCommand() *command {
+	var (
+		memoryMB, storageGB int
+		sshKeys, org        string
+		ttl                 time.Duration
+		onExpire            string
+		idleTimeout         string
+		wait                bool
+	)
+	return &command{
+		name: "create", args: "<name>", summary: "Create a container",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&memoryMB, "memory", 0, "memory in MB (default: server default)")
+			fs.IntVar(&storageGB, "storage", 0, "storage in GB (default: server default)")
+			fs.StringVar(&sshKeys, "ssh-keys", "", "comma-separated SSH key names or IDs to install (default: all)")
+			fs.DurationVar(&ttl, "ttl", 0, "expire the container after this long, e.g. 8h")
+			fs.StringVar(&onExpire, "on-expire", "", "what to do on expiry: delete or stop")
+			fs.StringVar(&idleTimeout, "idle-timeout", "", "stop after this long idle, e.g. 30m, or 0 to never (default: account setting)")
+			fs.BoolVar(&wait, "wait", true, "wait until the container is running")
+			orgFlag(fs, &org)
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			if len(args) != 1 {
+				return usagef("expected a container name")
+			}
+
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+			keyIDs, err := a.sshKeyIDs(ctx, orgID, sshKeys)
+			if err != nil {
+				return err
+			}
+			req := client.CreateContainerRequest{
+				Name:       args[0],
+				MemoryMB:   memoryMB,
+				StorageGB:  storageGB,
+				SSHKeyIDs:  keyIDs,
+				TTLSeconds: int64(ttl.Seconds()),
+				OnExpire:   onExpire,
+				OrgID:      orgID,
+			}
+			if idleTimeout != "" {
+				d, err := time.ParseDuration(idleTimeout)
+				if idleTimeout == "0" {
+					d, err = 0, nil
+				}
+				if err != nil {
+					return usagef("invalid --idle-timeout: %v", err)
+				}
+				minutes := int64(d.Minutes())
+				req.IdleTimeoutMinutes = &minutes
+			}
+
+			op, err := a.client.CreateContainer(ctx, req)
+			if err != nil {
+				return err
+			}
+			if !wait {
+				return a.out.operation(op)
+			}
+			fmt.Fprintf(os.Stderr, "Creating %s (%s)...\n", req.Name, op.ContainerID)
+			c, err := a.client.WaitForRunning(ctx, op.ContainerID)
+			if err != nil {
+				return err
+			}
+			return a.out.container(c)
+		},
+	}
+}
+
+// containerActionCommand is a command that runs a container operation and waits for it
+func containerActionCommand(name, summary string, action func(*client.Client, context.Context, string) (*client.Operation, error)) *command {
+	var wait bool
+	return &command{
+		name: name, args: "<name|id>", summary: summary, completeNames: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&wait, "wait", true, "wait for the "+name+" to finish")
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			c, err := a.resolveContainer(ctx, args)
+			if err != nil {
+				return err
+			}
+			op, err := action(a.client, ctx, c.ID)
+			if err != nil {
+				return err
+			}
+			if wait {
+				if op, err = a.client.WaitOperation(ctx, op.ID); err != nil {
+					return err
+				}
+			}
+			return a.out.operation(op)
+		},
+	}
+}
+
+func listAPIKeysCommand() *command {
+	var org string
+	return &command{
+		name: "list", summary: "List API keys",
+		flags: func(fs *flag.FlagSet) {
+			orgFlag(fs, &org)
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+			var keys []client.APIKey
+			opts := client.ListOptions{Limit: 200, OrgID: orgID}
+			for {
+				page, next, err := a.client.ListAPIKeys(ctx, opts)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, page...)
+				if next == "" {
+					return a.out.apiKeys(keys)
+				}
+				opts.Cursor = next
+			}
+		},
+	}
+}
+
+func createAPIKeyCommand() *command {
+	var scopes, containers, org string
+	var expires time.Duration
+	return &command{
+		name: "create", args: "<name>", summary: "Create an API key and print it once",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&scopes, "scopes", "", "comma-separated scopes, e.g. containers:read,containers:write (default: all of yours)")
+			fs.StringVar(&containers, "containers", "", "comma-separated container names or IDs to restrict the key to")
+			fs.DurationVar(&expires, "expires", 0, "expire the key after this long, e.g. 2160h for 90 days (default: server maximum, if any)")
+			orgFlag(fs, &org)
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			if len(args) != 1 {
+				return usagef("expected an API key name")
+			}
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+			req := client.CreateAPIKeyRequest{Name: args[0], Scopes: splitList(scopes), OrgID: orgID}
+			if expires > 0 {
+				t := time.Now().Add(expires)
+				req.ExpiresAt = &t
+			}
+			for _, name := range splitList(containers) {
+				c, err := a.resolveContainer(ctx, []string{name})
+				if err != nil {
+					return err
+				}
+				req.ContainerIDs = append(req.ContainerIDs, c.ID)
+			}
+
+			key, err := a.client.CreateAPIKey(ctx, req)
+			if err != nil {
+				return err
+			}
+			return a.out.message(key, "Created API key %d (%s) with scopes %s. It won't be shown again:\n%s",
+				key.ID, key.Name, strings.Join(key.Scopes, ","), *key.Key)
+		},
+	}
+}
+
+func rotateAPIKeyCommand() *command {
+	var grace, expires time.Duration
+	var org string
+	return &command{
+		name: "rotate", args: "<id>", summary: "Replace an API key, keeping the old one valid for a while",
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&grace, "grace", 24*time.Hour, "how long the old key keeps working")
+			fs.DurationVar(&expires, "expires", 0, "expire the new key after this long (default: the old key's lifetime)")
+			orgFlag(fs, &org)
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			id, err := parseID(args)
+			if err != nil {
+				return err
+			}
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+			seconds := int64(grace.Seconds())
+			req := client.RotateAPIKeyRequest{GracePeriodSeconds: &seconds}
+			if expires > 0 {
+				t := time.Now().Add(expires)
+				req.ExpiresAt = &t
+			}
+
+			key, err := a.client.RotateOrgAPIKey(ctx, orgID, id, req)
+			if err != nil {
+				return err
+			}
+			return a.out.message(key, "Rotated API key %d; the old key works for another %s. New key %d won't be shown again:\n%s",
+				id, grace, key.ID, *key.Key)
+		},
+	}
+}
+
+func revokeAPIKeyCommand() *command {
+	var reason, org string
+	return &command{
+		name: "delete", args: "<id>", summary: "Revoke an API key",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&reason, "reason", "", "why the key is being revoked, kept with the key")
+			orgFlag(fs, &org)
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			id, err := parseID(args)
+			if err != nil {
+				return err
+			}
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+			if err := a.client.RevokeOrgAPIKey(ctx, orgID, id, reason); err != nil {
+				return err
+			}
+			return a.out.message(map[string]any{"id": id, "revoked": true}, "Revoked API key %d", id)
+		},
+	}
+}
+
+func orgsCommand() *command {
+	var role string
+	return &command{
+		name:    "orgs",
+		summary: "Manage orgs and their members",
+		sub: []*command{
+			{
+				name: "list", summary: "List your orgs",
+				run: func(ctx context.Context, a *app, args []string) error {
+					orgs, err := a.client.ListOrgs(ctx)
+					if err != nil {
+						return err
+					}
+					return a.out.orgs(orgs)
+				},
+			},
+			{
+				name: "create", args: "<name>", summary: "Create an org, with you as its owner",
+				run: func(ctx context.Context, a *app, args []string) error {
+					if len(args) != 1 {
+						return usagef("expected an org name")
+					}
+					org, err := a.client.CreateOrg(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					return a.out.orgs([]client.Org{*org})
+				},
+			},
+			{
+				name: "members", args: "<org>", summary: "List an org's members",
+				run: func(ctx context.Context, a *app, args []string) error {
+					if len(args) != 1 {
+						return usagef("expected an org name or ID")
+					}
+					orgID, err := a.resolveOrg(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					members, err := a.client.ListOrgMembers(ctx, orgID)
+					if err != nil {
+						return err
+					}
+					return a.out.orgMembers(members)
+				},
+			},
+			{
+				name: "add", args: "<org> <username>", summary: "Add a member to an org, or change their role",
+				flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&role, "role", client.RoleMember, "owner, admin, member or viewer")
+				},
+				run: func(ctx context.Context, a *app, args []string) error {
+					if len(args) != 2 {
+						return usagef("expected an org and a username")
+					}
+					orgID, err := a.resolveOrg(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					member, err := a.client.SetOrgMember(ctx, orgID, args[1], role)
+					if err != nil {
+						return err
+					}
+					return a.out.orgMembers([]client.OrgMember{*member})
+				},
+			},
+			{
+				name: "remove", args: "<org> <username>", summary: "Remove a member from an org",
+				run: func(ctx context.Context, a *app, args []string) error {
+					if len(args) != 2 {
+						return usagef("expected an org and a username")
+					}
+					orgID, err := a.resolveOrg(ctx, args[0])
+					if err != nil {
+						return err
+					}
+					if err := a.client.RemoveOrgMember(ctx, orgID, args[1]); err != nil {
+						return err
+					}
+					return a.out.message(map[string]any{"org_id": orgID, "username": args[1], "removed": true},
+						"Removed %s from org %d", args[1], orgID)
+				},
+			},
+		},
+	}
+}
+
+func auditCommand() *command {
+	var (
+		opts client.AuditListOptions
+		all  bool
+	)
+	return &command{
+		name: "audit", summary: "Show recent changes made through the API",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&opts.Limit, "limit", 50, "number of entries to show")
+			fs.StringVar(&opts.Action, "action", "", `only this route, e.g. "DELETE /containers/{id}"`)
+			fs.StringVar(&opts.TargetType, "type", "", "only changes to this kind of resource, e.g. containers")
+			fs.StringVar(&opts.TargetID, "target", "", "only changes to the resource with this ID")
+			fs.BoolVar(&all, "all", false, "show every user's changes (admins only)")
+			fs.StringVar(&opts.User, "user", "", "with --all, only this user's changes")
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			if opts.User != "" && !all {
+				return usagef("--user needs --all")
+			}
+			list := a.client.ListAuditLog
+			if all {
+				list = a.client.ListAllAuditLog
+			}
+			entries, _, err := list(ctx, opts)
+			if err != nil {
+				return err
+			}
+			return a.out.auditEntries(entries)
+		},
+	}
+}
+
+func sshCommand() *command {
+	var start bool
+	return &command{
+		name: "ssh", args: "<name|id> [-- ssh arguments]", completeNames: true,
+		summary: "Wait for a container to run, then ssh into it",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&start, "start", true, "start the container if it's stopped")
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			if len(args) == 0 {
+				return usagef("expected a container name or ID")
+			}
+			c, err := a.resolveContainer(ctx, args[:1])
+			if err != nil {
+				return err
+			}
+
+			if c.Status == client.StatusStopped && start {
+				fmt.Fprintf(os.Stderr, "Starting %s...\n", c.Name)
+				if _, err := a.client.StartContainer(ctx, c.ID); err != nil && !client.IsInvalidState(err) {
+					return err
+				}
+			}
+			if c.Status != client.StatusRunning || c.ExternalIP == nil {
+				fmt.Fprintf(os.Stderr, "Waiting for %s to be running...\n", c.Name)
+			}
+			waitCtx, cancel := context.WithTimeout(ctx, sshWaitTimeout)
+			defer cancel()
+			if c, err = a.client.WaitForRunning(waitCtx, c.ID); err != nil {
+				return err
+			}
+
+			sshPath, err := exec.LookPath("ssh")
+			if err != nil {
+				return fmt.Errorf("ssh not found in PATH: %w", err)
+			}
+			cmd := exec.Command(sshPath, append([]string{"root@" + *c.ExternalIP}, args[1:]...)...)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+			err = cmd.Run()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				return &exitError{code: exitErr.ExitCode()}
+			}
+			return err
+		},
+	}
+}
+
+func listSSHKeysCommand() *command {
+	var org string
+	return &command{
+		name: "list", summary: "List SSH keys",
+		flags: func(fs *flag.FlagSet) {
+			orgFlag(fs, &org)
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+			keys, err := a.allSSHKeys(ctx, orgID)
+			if err != nil {
+				return err
+			}
+			return a.out.sshKeys(keys)
+		},
+	}
+}
+
+func addSSHKeyCommand() *command {
+	var org string
+	return &command{
+		name: "add", args: "<name> [public key file]",
+		summary: "Add a public key (default: ~/.ssh/id_ed25519.pub, then id_rsa.pub)",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&org, "org", "", "add the key to this org, name or ID, giving it access to the org's containers")
+		},
+		run: func(ctx context.Context, a *app, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return usagef("expected a key name and optionally a public key file")
+			}
+			orgID, err := a.resolveOrg(ctx, org)
+			if err != nil {
+				return err
+			}
+
+			var files []string
+			if len(args) == 2 {
+				files = []string{args[1]}
+			} else {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return err
+				}
+				files = []string{filepath.Join(home, ".ssh", "id_ed25519.pub"), filepath.Join(home, ".ssh", "id_rsa.pub")}
+			}
+
+			var data []byte
+			for _, f := range files {
+				if data, err = os.ReadFile(f); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("read public key: %w", err)
+			}
+
+			key, err := a.client.AddOrgSSHKey(ctx, orgID, args[0], strings.TrimSpace(string(data)))
+			if err != nil {
+				return err
+			}
+			return a.out.sshKeys([]client.SSHKey{*key})
+		},
+	}
+}
+
+// resolveContainer finds the container named, or with the ID given, by args[0]
+func (a *app) resolveContainer(ctx context.Context, args []string) (*client.Container, error) {
+	if len(args) != 1 {
+		return nil, usagef("expected a container name or ID")
+	}
+	c, err := a.client.FindContainer(ctx, args[0])
+	if client.IsNotFound(err) {
+		// Containers shared with you aren't in your own list
+		opts := client.ContainerListOptions{ListOptions: client.ListOptions{NamePrefix: args[0], Limit: 200}}
+		shared, _, err := a.client.ListSharedContainers(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range shared {
+			if shared[i].Name == args[0] {
+				return &shared[i].Container, nil
+			}
+		}
+		return a.client.GetContainer(ctx, args[0])
+	}
+	return c, err
+}
+
+// orgFlag adds the --org flag of commands that can act for an org
+func orgFlag(fs *flag.FlagSet, org *string) {
+	fs.StringVar(org, "org", "", "use this org's resources, by name or ID, instead of your own")
+}
+
+// resolveOrg finds the ID of the org named, or with the ID given, by s. An empty s
+// means your own resources, which is ID 0.
+func (a *app) resolveOrg(ctx context.Context, s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return id, nil
+	}
+	orgs, err := a.client.ListOrgs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, o := range orgs {
+		if o.Name == s {
+			return o.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("org %q not found", s)
+}
+
+func (a *app) allSSHKeys(ctx context.Context, orgID int64) ([]client.SSHKey, error) {
+	var keys []client.SSHKey
+	opts := client.ListOptions{Limit: 200, OrgID: orgID}
+	for {
+		page, next, err := a.client.ListSSHKeys(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if next == "" {
+			return keys, nil
+		}
+		opts.Cursor = next
+	}
+}
+
+// sshKeyIDs turns a comma-separated list of your or an org's key names or IDs into
+// IDs; an empty list means every key
+func (a *app) sshKeyIDs(ctx context.Context, orgID int64, list string) ([]int64, error) {
+	keys, err := a.allSSHKeys(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no SSH keys; add one with eddc ssh-keys add")
+	}
+
+	var ids []int64
+	if list == "" {
+		for _, k := range keys {
+			ids = append(ids, k.ID)
+		}
+		return ids, nil
+	}
+
+next:
+	for _, want := range strings.Split(list, ",") {
+		want = strings.TrimSpace(want)
+		for _, k := range keys {
+			if k.Name == want || strconv.FormatInt(k.ID, 10) == want {
+				ids = append(ids, k.ID)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("SSH key %q not found", want)
+	}
+	return ids, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseID(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, usagef("expected an ID")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, usagef("invalid ID %q", args[0])
+	}
+	return id, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func completionCommand() *command {
+	return &command{
+		name: "completion", args: "bash|zsh|fish", noClient: true,
+		summary: "Print a shell completion script for bash, zsh or fish",
+		run: func(ctx context.Context, a *app, args []string) error {
+			if len(args) != 1 {
+				return usagef("expected a shell: bash, zsh or fish")
+			}
+			switch args[0] {
+			case "bash":
+				fmt.Print(bashCompletion(commands()))
+			case "zsh":
+				// zsh can run bash completion functions
+				fmt.Print("autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion(commands()))
+			case "fish":
+				fmt.Print(fishCompletion(commands()))
+			default:
+				return usagef("unsupported shell %q", args[0])
+			}
+			return nil
+		},
+	}
+}
+
+// The scripts complete command names from the command table and container names
+// by running "eddc __names"
+
+func bashCompletion(cmds []*command) string {
+	var b strings.Builder
+	b.WriteString("_eddc() {\n")
+	b.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]} words\n")
+	b.WriteString("\tcase \"$COMP_CWORD:${COMP_WORDS[*]:1:COMP_CWORD-1}\" in\n")
+	fmt.Fprintf(&b, "\t1:*) words=%q ;;\n", strings.Join(visibleNames(cmds), " "))
+	for _, c := range cmds {
+		if c.sub != nil {
+			fmt.Fprintf(&b, "\t2:%s) words=%q ;;\n", c.name, strings.Join(visibleNames(c.sub), " "))
+			for _, s := range c.sub {
+				if s.completeNames {
+					fmt.Fprintf(&b, "\t\"3:%s %s\") words=$(eddc __names 2>/dev/null) ;;\n", c.name, s.name)
+				}
+			}
+		} else if c.completeNames {
+			fmt.Fprintf(&b, "\t2:%s) words=$(eddc __names 2>/dev/null) ;;\n", c.name)
+		}
+	}
+	b.WriteString("\t*) return ;;\n")
+	b.WriteString("\tesac\n")
+	b.WriteString("\tCOMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -F _eddc eddc\n")
+	return b.String()
+}
+
+func fishCompletion(cmds []*command) string {
+	var b strings.Builder
+	b.WriteString("complete -c eddc -f\n")
+	top := strings.Join(visibleNames(cmds), " ")
+	for _, c := range visible(cmds) {
+		fmt.Fprintf(&b, "complete -c eddc -n 'not __fish_seen_subcommand_from %s' -a %s -d %q\n", top, c.name, c.summary)
+		if c.completeNames {
+			fmt.Fprintf(&b, "complete -c eddc -n '__fish_seen_subcommand_from %s' -a '(eddc __names 2>/dev/null)'\n", c.name)
+		}
+		for _, s := range c.sub {
+			fmt.Fprintf(&b, "complete -c eddc -n '__fish_seen_subcommand_from %s; and not __fish_seen_subcommand_from %s' -a %s -d %q\n",
+				c.name, strings.Join(visibleNames(c.sub), " "), s.name, s.summary)
+			if s.completeNames {
+				fmt.Fprintf(&b, "complete -c eddc -n '__fish_seen_subcommand_from %s; and __fish_seen_subcommand_from %s' -a '(eddc __names 2>/dev/null)'\n",
+					c.name, s.name)
+			}
+		}
+	}
+	return b.String()
+}
+
+func visible(cmds []*command) []*command {
+	var out []*command
+	for _, c := range cmds {
+		if !strings.HasPrefix(c.name, "__") {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func visibleNames(cmds []*command) []string {
+	var names []string
+	for _, c := range visible(cmds) {
+		names = append(names, c.name)
+	}
+	return names
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"eddisonso.com/edd-compute/pkg/client"
+)
+
+const defaultURL = "https://cloud.eddisonso.com"
+
+// config is stored as JSON in the user's config directory, readable only by them
+// since it holds an API key
+type config struct {
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+	// Token is an SSO access token from $EDDC_TOKEN, used instead of the API key.
+	// It's short-lived, so it's never saved.
+	Token string `json:"-"`
+}
+
+// loggedIn reports whether there are credentials to call the API with
+func (c *config) loggedIn() bool {
+	return c.APIKey != "" || c.Token != ""
+}
+
+// clientConfig is how to reach the API with these credentials
+func (c *config) clientConfig() client.Config {
+	cfg := client.Config{BaseURL: c.URL, APIKey: c.APIKey}
+	if c.Token != "" {
+		cfg.APIKey, cfg.BearerToken = "", c.Token
+	}
+	return cfg
+}
+
+// configPath is $EDDC_CONFIG, or eddc/config.json in the user's config directory
+func configPath() (string, error) {
+	if p := os.Getenv("EDDC_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("find config directory: %w", err)
+	}
+	return filepath.Join(dir, "eddc", "config.json"), nil
+}
+
+// loadConfig reads the config file, if there is one, and applies the EDDC_URL,
+// EDDC_API_KEY and EDDC_TOKEN environment overrides
+func loadConfig() (*config, error) {
+	cfg := &config{URL: defaultURL}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("read config: %w", err)
+	default:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv("EDDC_URL"); v != "" {
+		cfg.URL = v
+	}
+	if v := os.Getenv("EDDC_API_KEY"); v != "" {
+		cfg.APIKey = v
+	}
+	cfg.Token = os.Getenv("EDDC_TOKEN")
+	return cfg, nil
+}
+
+func saveConfig(cfg *config) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("create config directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encode config: %w", err)
+	}
+	// Write then rename so a failed write can't leave a truncated config behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return "", fmt.Errorf("write config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("write config: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,194 @@
+// Command eddc manages edd-compute containers and keys from the command line.
+//
+//	eddc login
+//	eddc containers create dev --ttl 8h
+//	eddc ssh dev
+//
+// Run "eddc help" for every command.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"eddisonso.com/edd-compute/pkg/client"
+)
+
+// command is one eddc command, or a group of subcommands
+type command struct {
+	name    string
+	args    string // Usage after the command name, e.g. "<name>"
+	summary string
+	// flags registers the command's flags; output flags are added to every command
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, a *app, args []string) error
+	sub   []*command
+	// completeNames completes the first argument with container names
+	completeNames bool
+	// noClient commands run without a configured API key
+	noClient bool
+}
+
+// app is what commands run with
+type app struct {
+	cfg    *config
+	client *client.Client
+	out    *printer
+}
+
+// usageError is reported with the command's usage
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
+		fmt.Fprintln(os.Stderr, "eddc:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	cmds := commands()
+	path := []string{"eddc"}
+	for {
+		if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			printUsage(path, cmds)
+			return nil
+		}
+		cmd := findCommand(cmds, args[0])
+		if cmd == nil {
+			printUsage(path, cmds)
+			return fmt.Errorf("unknown command %q", strings.Join(append(path[1:], args[0]), " "))
+		}
+		path = append(path, cmd.name)
+		args = args[1:]
+		if cmd.sub != nil {
+			cmds = cmd.sub
+			continue
+		}
+		return runCommand(ctx, cmd, strings.Join(path, " "), args)
+	}
+}
+
+func runCommand(ctx context.Context, cmd *command, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := fs.String("o", "table", "output format: table or json")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n\n%s\n\nFlags:\n", name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("output format must be table or json")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	a := &app{cfg: cfg, out: &printer{w: os.Stdout, json: *output == "json"}}
+	if !cmd.noClient {
+		if !cfg.loggedIn() {
+			return fmt.Errorf("not logged in; run eddc login or set EDDC_TOKEN")
+		}
+		clientCfg := cfg.clientConfig()
+		clientCfg.UserAgent = "eddc"
+		a.client, err = client.New(clientCfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cmd.run(ctx, a, positional)
+	var usage *usageError
+	if errors.As(err, &usage) {
+		fmt.Fprintf(os.Stderr, "%s\n\n", usage.msg)
+		fs.Usage()
+		return &exitError{code: 2}
+	}
+	return err
+}
+
+// parseInterspersed parses flags that come before, between or after positional
+// arguments, e.g. "containers create dev --ttl 8h". Everything after "--" is positional.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional, rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func findCommand(cmds []*command, name string) *command {
+	for _, c := range cmds {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func printUsage(path []string, cmds []*command) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", strings.Join(path, " "))
+	for _, c := range cmds {
+		if strings.HasPrefix(c.name, "__") {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"%s <command> -h\" for a command's flags.\n", strings.Join(path, " "))
+}
+
+// exitError exits with a status without printing anything more
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
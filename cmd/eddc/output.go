@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"eddisonso.com/edd-compute/pkg/client"
+)
+
+// printer writes results as a table for people or as JSON for scripts
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// print writes v as indented JSON, or as a table with the given columns and rows
+func (p *printer) print(v any, columns []string, rows [][]string) error {
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// message writes a confirmation for people; JSON output gets v instead
+func (p *printer) message(v any, format string, args ...any) error {
+	if p.json {
+		return p.print(v, nil, nil)
+	}
+	_, err := fmt.Fprintf(p.w, format+"\n", args...)
+	return err
+}
+
+var containerColumns = []string{"ID", "NAME", "STATUS", "IP", "MEMORY", "STORAGE", "EXPIRES", "CREATED"}
+
+func containerRow(c client.Container) []string {
+	expires := "-"
+	if c.ExpiresAt != nil {
+		expires = ago(*c.ExpiresAt)
+	}
+	return []string{
+		c.ID, c.Name, c.Status, orDash(c.ExternalIP),
+		strconv.Itoa(c.MemoryMB) + "MB", strconv.Itoa(c.StorageGB) + "GB",
+		expires, ago(c.CreatedAt),
+	}
+}
+
+func (p *printer) containers(containers []client.Container) error {
+	rows := make([][]string, len(containers))
+	for i, c := range containers {
+		rows[i] = containerRow(c)
+	}
+	return p.print(containers, containerColumns, rows)
+}
+
+func (p *printer) container(c *client.Container) error {
+	return p.print(c, containerColumns, [][]string{containerRow(*c)})
+}
+
+func (p *printer) sharedContainers(containers []client.SharedContainer) error {
+	rows := make([][]string, len(containers))
+	for i, c := range containers {
+		rows[i] = append(containerRow(c.Container), c.GrantedBy)
+	}
+	return p.print(containers, append(append([]string(nil), containerColumns...), "SHARED BY"), rows)
+}
+
+func (p *printer) containerGrants(grants []client.ContainerGrant) error {
+	rows := make([][]string, len(grants))
+	for i, g := range grants {
+		rows[i] = []string{g.Username, g.GrantedBy, ago(g.CreatedAt)}
+	}
+	return p.print(grants, []string{"USER", "SHARED BY", "SHARED"}, rows)
+}
+
+func (p *printer) operation(op *client.Operation) error {
+	return p.print(op, []string{"OPERATION", "TYPE", "CONTAINER", "STATUS", "PROGRESS", "MESSAGE"}, [][]string{{
+		op.ID, op.Type, op.ContainerID, op.Status, strconv.Itoa(op.Progress) + "%", op.Message,
+	}})
+}
+
+func (p *printer) sshKeys(keys []client.SSHKey) error {
+	rows := make([][]string, len(keys))
+	for i, k := range keys {
+		rows[i] = []string{strconv.FormatInt(k.ID, 10), k.Name, k.Fingerprint, ago(k.CreatedAt)}
+	}
+	return p.print(keys, []string{"ID", "NAME", "FINGERPRINT", "CREATED"}, rows)
+}
+
+func (p *printer) apiKeys(keys []client.APIKey) error {
+	rows := make([][]string, len(keys))
+	for i, k := range keys {
+		lastUsed := "never"
+		if k.LastUsed != nil {
+			lastUsed = ago(*k.LastUsed)
+		}
+		containers := "all"
+		if k.ContainerIDs != nil {
+			containers = strings.Join(k.ContainerIDs, ",")
+		}
+		expires := "never"
+		if k.ExpiresAt != nil {
+			expires = ago(*k.ExpiresAt)
+		}
+		rows[i] = []string{
+			strconv.FormatInt(k.ID, 10), k.Name, k.Status, strings.Join(k.Scopes, ","), containers,
+			ago(k.CreatedAt), expires, lastUsed,
+		}
+	}
+	return p.print(keys, []string{"ID", "NAME", "STATUS", "SCOPES", "CONTAINERS", "CREATED", "EXPIRES", "LAST USED"}, rows)
+}
+
+func (p *printer) orgs(orgs []client.Org) error {
+	rows := make([][]string, len(orgs))
+	for i, o := range orgs {
+		rows[i] = []string{strconv.FormatInt(o.ID, 10), o.Name, o.Role, ago(o.CreatedAt)}
+	}
+	return p.print(orgs, []string{"ID", "NAME", "ROLE", "CREATED"}, rows)
+}
+
+func (p *printer) orgMembers(members []client.OrgMember) error {
+	rows := make([][]string, len(members))
+	for i, m := range members {
+		rows[i] = []string{m.Username, m.Role, ago(m.CreatedAt)}
+	}
+	return p.print(members, []string{"USER", "ROLE", "ADDED"}, rows)
+}
+
+func (p *printer) auditEntries(entries []client.AuditEntry) error {
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		via := e.AuthMethod
+		if e.APIKeyID != nil {
+			via = "key " + strconv.FormatInt(*e.APIKeyID, 10)
+		}
+		target := e.TargetType
+		if e.TargetID != "" {
+			target += "/" + e.TargetID
+		}
+		rows[i] = []string{
+			ago(e.CreatedAt), orDash(&e.Username), orDash(&via), e.SourceIP, e.Action, target,
+			strconv.Itoa(e.StatusCode), e.Result,
+		}
+	}
+	return p.print(entries, []string{"WHEN", "USER", "VIA", "FROM", "ACTION", "TARGET", "STATUS", "RESULT"}, rows)
+}
+
+// ago formats a time relative to now, e.g. "3h ago" or "in 2d"
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := time.Since(t)
+	if d < 0 {
+		return "in " + shortDuration(-d)
+	}
+	return shortDuration(d) + " ago"
+}
+
+func shortDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return strconv.Itoa(int(d.Seconds())) + "s"
+	case d < time.Hour:
+		return strconv.Itoa(int(d.Minutes())) + "m"
+	case d < 48*time.Hour:
+		return strconv.Itoa(int(d.Hours())) + "h"
+	default:
+		return strconv.Itoa(int(d.Hours()/24)) + "d"
+	}
+}
+
+func orDash(s *string) string {
+	if s == nil || *s == "" {
+		return "-"
+	}
+	return *s
+}
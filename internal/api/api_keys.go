@@ -1,10 +1,12 @@
 package api
 
 import (
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -13,28 +15,70 @@ import (
 	"eddisonso.com/edd-compute/internal/db"
 )
 
-const maxAPIKeysPerUser = 5
+const (
+	maxAPIKeysPerUser = 5
+	// defaultRotationGrace is how long a rotated key keeps working by default
+	defaultRotationGrace = 24 * time.Hour
+	maxRevokeReasonLen   = 200
+)
 
 type apiKeyRequest struct {
-	Name string `json:"name"`
+	Name string `json:"name" validate:"required,max=63"`
+	// Scopes default to the creator's own
+	Scopes []string `json:"scopes" validate:"max=16"`
+	// ContainerIDs restricts the key to these containers; the default is all of them,
+	// unless the creator is itself restricted
+	ContainerIDs []string `json:"container_ids" validate:"max=50"`
+	// ExpiresAt is when the key stops working; the default is never, or the server's
+	// maximum key lifetime if it has one
+	ExpiresAt string `json:"expires_at" validate:"format=date-time"`
+	// OrgID makes a key that acts for an org, which needs the admin role. Org keys
+	// only reach the org's resources, and can only hold orgKeyScopes.
+	OrgID int64 `json:"org_id" validate:"min=1"`
+}
+
+type apiKeyRotateRequest struct {
+	// GracePeriodSeconds is how long the old key keeps working, 24 hours by default
+	GracePeriodSeconds *int64 `json:"grace_period_seconds" validate:"min=0,max=2592000"`
+	// ExpiresAt is when the new key stops working; by default it gets the same
+	// lifetime as the old one
+	ExpiresAt string `json:"expires_at" validate:"format=date-time"`
 }
 
 type apiKeyResponse struct {
-	ID        int64   `json:"id"`
-	Name      string  `json:"name"`
-	Key       *string `json:"key,omitempty"` // Only returned on creation
-	CreatedAt string  `json:"created_at"`
-	LastUsed  *string `json:"last_used,omitempty"`
+	ID   int64   `json:"id"`
+	Name string  `json:"name"`
+	Key  *string `json:"key,omitempty"` // Only returned on creation
+	// Prefix is the start of the key, e.g. eddc_abcdefghijklm, to tell keys apart;
+	// keys from before the eddc_ format have none
+	Prefix       *string  `json:"prefix,omitempty"`
+	Scopes       []string `json:"scopes"`
+	ContainerIDs []string `json:"container_ids,omitempty"`
+	// Status is active, rotated (still valid until expires_at), expired or revoked
+	Status        string  `json:"status"`
+	CreatedAt     string  `json:"created_at"`
+	LastUsed      *string `json:"last_used,omitempty"`
+	ExpiresAt     *string `json:"expires_at,omitempty"`
+	RevokedAt     *string `json:"revoked_at,omitempty"`
+	RevokedReason *string `json:"revoked_reason,omitempty"`
+	ReplacedBy    *int64  `json:"replaced_by,omitempty"`
+	// OrgID is the org the key acts for, if it isn't personal
+	OrgID *int64 `json:"org_id,omitempty"`
 }
 
 func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
+	owner, ok := h.queryOwnerFor(w, r, permManage)
 	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	keys, err := h.db.ListAPIKeysByUser(userID)
+	opts, err := parseListOptions(r, defaultListRows, maxListRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys, next, err := h.db.ListAPIKeysByOwner(owner, opts)
 	if err != nil {
 		slog.Error("failed to list api keys", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
@@ -46,6 +90,7 @@ func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
 		resp = append(resp, apiKeyToResponse(k, false))
 	}
 
+	setNextCursor(w, next)
 	writeJSON(w, resp)
 }
 
@@ -57,8 +102,7 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req apiKeyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, "invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
@@ -68,20 +112,28 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check key limit
-	count, err := h.db.CountAPIKeysByUser(userID)
-	if err != nil {
-		slog.Error("failed to count api keys", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
+	owner, ok := h.requestOwner(w, r, req.OrgID, permManage)
+	if !ok {
+		return
+	}
+	scopes, containerIDs, ok := h.apiKeyGrants(w, r, req, owner)
+	if !ok {
 		return
 	}
-	if count >= maxAPIKeysPerUser {
-		writeError(w, fmt.Sprintf("API key limit reached (%d)", maxAPIKeysPerUser), http.StatusBadRequest)
+
+	var expiresAt time.Time
+	if req.ExpiresAt != "" {
+		// Already checked by decodeRequest
+		expiresAt, _ = time.Parse(time.RFC3339, req.ExpiresAt)
+	}
+	expiry, err := h.apiKeyExpiry(expiresAt, time.Now())
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, err.Error(), map[string]any{"field": "expires_at"})
 		return
 	}
 
 	// Generate new API key
-	plaintext, keyHash, err := auth.GenerateAPIKey()
+	plaintext, keyID, keyHash, err := h.apiKeys.Generate()
 	if err != nil {
 		slog.Error("failed to generate api key", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
@@ -89,16 +141,26 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	key := &db.APIKey{
-		UserID:  userID,
-		KeyHash: keyHash,
-		Name:    req.Name,
+		UserID:       userID,
+		OrgID:        sql.NullInt64{Int64: owner.OrgID, Valid: owner.IsOrg()},
+		KeyID:        sql.NullString{String: keyID, Valid: true},
+		KeyHash:      keyHash,
+		Name:         req.Name,
+		Scopes:       scopes,
+		ContainerIDs: containerIDs,
+		ExpiresAt:    expiry,
 	}
 
-	if err := h.db.CreateAPIKey(key); err != nil {
+	if err := h.db.CreateAPIKey(key, maxAPIKeysPerUser); err != nil {
+		if errors.Is(err, db.ErrLimitExceeded) {
+			writeLimitError(w, "API key", maxAPIKeysPerUser)
+			return
+		}
 		slog.Error("failed to create api key", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	noteAuditTarget(r.Context(), strconv.FormatInt(key.ID, 10))
 
 	// Return response with plaintext key (only time it's shown)
 	resp := apiKeyToResponse(key, true)
@@ -107,10 +169,12 @@ func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, resp)
 }
 
+// DeleteAPIKey revokes a key. It's kept, with the time and the optional reason
+// query parameter, so it still shows up in the list. Org keys are named with the
+// org_id query parameter.
 func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
+	owner, ok := h.queryOwnerFor(w, r, permManage)
 	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
@@ -121,30 +185,253 @@ func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.db.DeleteAPIKey(id, userID); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "API key not found", http.StatusNotFound)
-			return
-		}
-		slog.Error("failed to delete api key", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if len(reason) > maxRevokeReasonLen {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf("reason must be at most %d characters", maxRevokeReasonLen), map[string]any{"field": "reason"})
+		return
+	}
+
+	if err := h.db.RevokeAPIKey(id, owner, reason); err != nil {
+		writeDBError(w, err, "API key")
 		return
 	}
 
 	writeJSON(w, map[string]string{"status": "ok"})
 }
 
+// RotateAPIKey issues a replacement for a key with the same name, scopes and
+// containers. The old key keeps working for a grace period so clients can switch over.
+// Org keys are named with the org_id query parameter.
+func (h *Handler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	owner, ok := h.queryOwnerFor(w, r, permManage)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var req apiKeyRotateRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	old, err := h.db.GetAPIKey(id, owner)
+	if err != nil {
+		slog.Error("failed to get api key", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if old == nil {
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, "API key not found", nil)
+		return
+	}
+
+	now := time.Now()
+	if status := old.Status(now); status != db.APIKeyActive {
+		writeErrorCode(w, http.StatusConflict, codeInvalidState, "API key is "+status, map[string]any{"status": status})
+		return
+	}
+
+	// The caller gets the new key's secret, so it needs everything the key grants
+	scopes, containerIDs, ok := h.apiKeyGrants(w, r, apiKeyRequest{Scopes: old.Scopes, ContainerIDs: old.ContainerIDs}, owner)
+	if !ok {
+		return
+	}
+
+	var expiresAt time.Time
+	switch {
+	case req.ExpiresAt != "":
+		expiresAt, _ = time.Parse(time.RFC3339, req.ExpiresAt)
+	case old.ExpiresAt.Valid:
+		expiresAt = now.Add(old.ExpiresAt.Time.Sub(old.CreatedAt))
+	}
+	expiry, err := h.apiKeyExpiry(expiresAt, now)
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, err.Error(), map[string]any{"field": "expires_at"})
+		return
+	}
+
+	grace := defaultRotationGrace
+	if req.GracePeriodSeconds != nil {
+		grace = time.Duration(*req.GracePeriodSeconds) * time.Second
+	}
+
+	plaintext, keyID, keyHash, err := h.apiKeys.Generate()
+	if err != nil {
+		slog.Error("failed to generate api key", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	key := &db.APIKey{
+		UserID:       userID,
+		OrgID:        old.OrgID,
+		KeyID:        sql.NullString{String: keyID, Valid: true},
+		KeyHash:      keyHash,
+		Name:         old.Name,
+		Scopes:       scopes,
+		ContainerIDs: containerIDs,
+		ExpiresAt:    expiry,
+		CreatedAt:    now.UTC(),
+	}
+	if err := h.db.RotateAPIKey(old, key, now.Add(grace)); err != nil {
+		writeDBError(w, err, "API key")
+		return
+	}
+
+	resp := apiKeyToResponse(key, true)
+	resp.Key = &plaintext
+	writeJSON(w, resp)
+}
+
+// lookupAPIKey finds the key a bearer token belongs to, or returns nil if it isn't
+// a valid key. Malformed tokens are turned away without a database lookup.
+func (h *Handler) lookupAPIKey(token string) (*db.APIKey, error) {
+	parsed, err := auth.ParseAPIKey(token)
+	if err != nil {
+		return nil, nil
+	}
+
+	var key *db.APIKey
+	if parsed.Legacy {
+		key, err = h.db.GetLegacyAPIKeyByHash(parsed.LegacyHash())
+	} else {
+		key, err = h.db.GetAPIKeyByKeyID(parsed.KeyID)
+	}
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if !h.apiKeys.Verify(parsed, key.KeyHash) {
+		return nil, nil
+	}
+	return key, nil
+}
+
+// apiKeyExpiry checks a requested expiry, where zero means none, against the
+// server's maximum key lifetime
+func (h *Handler) apiKeyExpiry(expiresAt, now time.Time) (sql.NullTime, error) {
+	maxLifetime := h.cfg.APIKeyMaxLifetime
+	if expiresAt.IsZero() {
+		if maxLifetime <= 0 {
+			return sql.NullTime{}, nil
+		}
+		expiresAt = now.Add(maxLifetime)
+	}
+
+	if !expiresAt.After(now) {
+		return sql.NullTime{}, fmt.Errorf("expires_at must be in the future")
+	}
+	if maxLifetime > 0 && expiresAt.After(now.Add(maxLifetime)) {
+		return sql.NullTime{}, fmt.Errorf("API keys can't be valid for more than %d days", int(maxLifetime.Hours()/24))
+	}
+	return sql.NullTime{Time: expiresAt.UTC(), Valid: true}, nil
+}
+
+// apiKeyGrants works out the scopes and container restriction for a new key. A key
+// can't be given more than its creator holds, so a leaked key can't be used to mint
+// a more powerful one. Org keys are limited to orgKeyScopes and the org's containers.
+func (h *Handler) apiKeyGrants(w http.ResponseWriter, r *http.Request, req apiKeyRequest, owner db.Owner) ([]string, []string, bool) {
+	creator := userFromContext(r.Context())
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = creator.Scopes
+		if owner.IsOrg() {
+			scopes = slices.DeleteFunc(slices.Clone(orgKeyScopes), func(s string) bool { return !creator.hasScope(s) })
+		}
+	}
+	scopes = slices.Compact(slices.Sorted(slices.Values(scopes)))
+	for _, scope := range scopes {
+		if scope != scopeAll && !slices.Contains(apiScopes, scope) {
+			writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("unknown scope %q", scope),
+				map[string]any{"field": "scopes", "allowed": apiScopes})
+			return nil, nil, false
+		}
+		if owner.IsOrg() && !slices.Contains(orgKeyScopes, scope) {
+			writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("org API keys can't hold the %s scope", scope),
+				map[string]any{"field": "scopes", "allowed": orgKeyScopes})
+			return nil, nil, false
+		}
+		if !creator.hasScope(scope) {
+			writeErrorCode(w, http.StatusForbidden, codeForbidden, "can't grant the "+scope+" scope without holding it", nil)
+			return nil, nil, false
+		}
+	}
+
+	containerIDs := req.ContainerIDs
+	if len(containerIDs) == 0 {
+		containerIDs = creator.ContainerIDs
+	}
+	if containerIDs == nil {
+		return scopes, nil, true
+	}
+
+	// Webhooks deliver events for every container, so they'd bypass the restriction
+	if slices.Contains(scopes, scopeAll) || slices.Contains(scopes, scopeWebhooksManage) {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "keys restricted to containers need explicit scopes, not including "+scopeWebhooksManage,
+			map[string]any{"field": "scopes"})
+		return nil, nil, false
+	}
+	containerIDs = slices.Compact(slices.Sorted(slices.Values(containerIDs)))
+	for _, id := range containerIDs {
+		container, err := h.db.GetContainer(id)
+		if err != nil {
+			slog.Error("failed to get container", "error", err)
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		if container == nil || container.Owner() != owner || !creator.canAccessContainer(id) {
+			writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("container %q not found", id),
+				map[string]any{"field": "container_ids"})
+			return nil, nil, false
+		}
+	}
+	return scopes, containerIDs, true
+}
+
 func apiKeyToResponse(k *db.APIKey, includeKey bool) apiKeyResponse {
 	resp := apiKeyResponse{
-		ID:        k.ID,
-		Name:      k.Name,
-		CreatedAt: k.CreatedAt.Format(time.RFC3339),
+		ID:           k.ID,
+		Name:         k.Name,
+		Scopes:       k.Scopes,
+		ContainerIDs: k.ContainerIDs,
+		Status:       k.Status(time.Now()),
+		CreatedAt:    k.CreatedAt.Format(time.RFC3339),
 	}
 
+	if k.KeyID.Valid {
+		prefix := auth.APIKeyPrefix + "_" + k.KeyID.String
+		resp.Prefix = &prefix
+	}
 	if k.LastUsed.Valid {
 		lastUsed := k.LastUsed.Time.Format(time.RFC3339)
 		resp.LastUsed = &lastUsed
 	}
+	if k.ExpiresAt.Valid {
+		expiresAt := k.ExpiresAt.Time.Format(time.RFC3339)
+		resp.ExpiresAt = &expiresAt
+	}
+	if k.RevokedAt.Valid {
+		revokedAt := k.RevokedAt.Time.Format(time.RFC3339)
+		resp.RevokedAt = &revokedAt
+	}
+	if k.RevokedReason.Valid {
+		resp.RevokedReason = &k.RevokedReason.String
+	}
+	if k.ReplacedBy.Valid {
+		resp.ReplacedBy = &k.ReplacedBy.Int64
+	}
+	resp.OrgID = ownerOrgID(k.Owner())
 
 	return resp
 }
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+const (
+	defaultAuditRows = 50
+	maxAuditRows     = 200
+	// maxAuditSummaryLen bounds the stored request summary; longer bodies are left out
+	maxAuditSummaryLen = 4096
+	// auditCursorSort tags audit log cursors, which page by entry ID
+	auditCursorSort = "-id"
+)
+
+// auditRedactedFields are request body fields never written to the audit log
+var auditRedactedFields = []string{"secret", "password", "token"}
+
+type auditResponse struct {
+	ID         int64           `json:"id"`
+	UserID     *int64          `json:"user_id,omitempty"`
+	Username   string          `json:"username,omitempty"`
+	AuthMethod string          `json:"auth_method,omitempty"`
+	APIKeyID   *int64          `json:"api_key_id,omitempty"`
+	SourceIP   string          `json:"source_ip"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Summary    json.RawMessage `json:"summary,omitempty"`
+	StatusCode int             `json:"status_code"`
+	Result     string          `json:"result"`
+	RequestID  string          `json:"request_id,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+const auditContextKey contextKey = "audit"
+
+// auditRecord collects what's learned about a request while it's handled: the
+// caller, once authMiddleware knows it, and the ID of anything it creates
+type auditRecord struct {
+	user     *userInfo
+	targetID string
+}
+
+// noteAuditActor records who is making an audited request
+func noteAuditActor(ctx context.Context, user *userInfo) {
+	if rec, ok := ctx.Value(auditContextKey).(*auditRecord); ok {
+		rec.user = user
+	}
+}
+
+// noteAuditTarget records the ID of the resource an audited request created
+func noteAuditTarget(ctx context.Context, id string) {
+	if rec, ok := ctx.Value(auditContextKey).(*auditRecord); ok {
+		rec.targetID = id
+	}
+}
+
+// audit writes an audit log entry for each request to a mutating route once it's
+// handled, whether it succeeded or not. pattern is the route as given to h.route.
+func (h *Handler) audit(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	_, path, _ := strings.Cut(pattern, " ")
+	targetType, targetParam := auditTarget(path)
+	return func(w http.ResponseWriter, r *http.Request) {
+		summary := auditSummary(r)
+		rec := &auditRecord{}
+		if targetParam != "" {
+			rec.targetID = r.PathValue(targetParam)
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r.WithContext(context.WithValue(r.Context(), auditContextKey, rec)))
+
+		entry := &db.AuditEntry{
+			SourceIP:   h.clientIP(r),
+			Action:     pattern,
+			TargetType: targetType,
+			TargetID:   rec.targetID,
+			Summary:    summary,
+			StatusCode: recorder.status,
+			Result:     auditResult(recorder.status),
+			RequestID:  w.Header().Get(requestIDHeader),
+		}
+		if u := rec.user; u != nil {
+			entry.UserID = sql.NullInt64{Int64: u.UserID, Valid: true}
+			entry.Username = u.Username
+			entry.AuthMethod = u.AuthMethod
+			if u.AuthMethod == authAPIKey {
+				entry.APIKeyID = sql.NullInt64{Int64: u.APIKeyID, Valid: true}
+			}
+		}
+		if err := h.db.CreateAuditEntry(entry); err != nil {
+			slog.Error("failed to write audit entry", "action", entry.Action, "error", err)
+		}
+		if h.cfg.ForwardAudit {
+			slog.Info("audit",
+				"action", entry.Action,
+				"target_type", entry.TargetType,
+				"target_id", entry.TargetID,
+				"user", entry.Username,
+				"auth_method", entry.AuthMethod,
+				"api_key_id", entry.APIKeyID.Int64,
+				"source_ip", entry.SourceIP,
+				"status", entry.StatusCode,
+				"result", entry.Result,
+				"request_id", entry.RequestID,
+			)
+		}
+	}
+}
+
+// auditTarget works out what kind of resource a route acts on from its path, and
+// which path parameter names it: the innermost one, so actions on a container's
+// schedules are about the container. Routes without parameters act on the
+// collection, and name what they create with noteAuditTarget.
+func auditTarget(path string) (targetType, param string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, "{") {
+			param = strings.Trim(s, "{}")
+			if i > 0 {
+				targetType = segments[i-1]
+			}
+		}
+	}
+	if param == "" {
+		targetType = segments[0]
+	}
+	return targetType, param
+}
+
+func auditResult(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return db.AuditDenied
+	case status >= 400:
+		return db.AuditFailure
+	default:
+		return db.AuditSuccess
+	}
+}
+
+// auditSummary describes a request's query and JSON body for the audit log, with
+// secrets redacted. The body is put back for the handler to read.
+func auditSummary(r *http.Request) string {
+	summary := map[string]any{}
+	if q := r.URL.Query(); len(q) > 0 {
+		summary["query"] = q
+	}
+
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodyBytes+1))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		var fields map[string]any
+		if err == nil && json.Unmarshal(body, &fields) == nil {
+			for name := range fields {
+				if slices.ContainsFunc(auditRedactedFields, func(s string) bool {
+					return strings.Contains(strings.ToLower(name), s)
+				}) {
+					fields[name] = "[redacted]"
+				}
+			}
+			summary["body"] = fields
+		}
+	}
+
+	if len(summary) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(summary)
+	if err == nil && len(b) > maxAuditSummaryLen {
+		delete(summary, "body")
+		summary["body_truncated"] = true
+		b, err = json.Marshal(summary)
+	}
+	if err != nil || len(b) > maxAuditSummaryLen {
+		return `{"truncated":true}`
+	}
+	return string(b)
+}
+
+// clientIP is the address a request came from. Behind a trusted proxy that's the
+// last X-Forwarded-For entry, the one the proxy added; earlier entries are
+// whatever the client claimed.
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.cfg.TrustForwardedFor {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(xff[len(xff)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isAdmin reports whether the caller may see every user's audit log
+func (h *Handler) isAdmin(user *userInfo) bool {
+	return user.Username != "" && slices.Contains(h.cfg.AdminUsers, user.Username)
+}
+
+// ListAuditLog returns the caller's own audited requests, newest first
+func (h *Handler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, err.Error(), nil)
+		return
+	}
+	filter.UserID = userID
+	h.writeAuditEntries(w, filter)
+}
+
+// ListAllAuditLog returns every user's audited requests to admins, optionally
+// narrowed to one user with ?user=<username>
+func (h *Handler) ListAllAuditLog(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !h.isAdmin(user) {
+		writeErrorCode(w, http.StatusForbidden, codeForbidden, "admin access required", nil)
+		return
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, err.Error(), nil)
+		return
+	}
+	filter.Username = r.URL.Query().Get("user")
+	h.writeAuditEntries(w, filter)
+}
+
+// parseAuditFilter reads the paging and filtering query parameters of the audit log
+func parseAuditFilter(r *http.Request) (db.AuditFilter, error) {
+	q := r.URL.Query()
+
+	limit, err := parseLimit(r, defaultAuditRows, maxAuditRows)
+	if err != nil {
+		return db.AuditFilter{}, err
+	}
+	filter := db.AuditFilter{
+		Limit:      limit,
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+	}
+
+	if s := q.Get("cursor"); s != "" {
+		c, err := db.ParseCursor(s)
+		if err != nil {
+			return db.AuditFilter{}, err
+		}
+		id, err := strconv.ParseInt(c.ID, 10, 64)
+		if c.Sort != auditCursorSort || err != nil {
+			return db.AuditFilter{}, fmt.Errorf("invalid cursor")
+		}
+		filter.BeforeID = id
+	}
+	return filter, nil
+}
+
+func (h *Handler) writeAuditEntries(w http.ResponseWriter, filter db.AuditFilter) {
+	entries, err := h.db.ListAuditEntries(filter)
+	if err != nil {
+		slog.Error("failed to list audit log", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]auditResponse, 0, len(entries))
+	for _, e := range entries {
+		resp = append(resp, auditToResponse(e))
+	}
+	if len(entries) == filter.Limit {
+		last := entries[len(entries)-1]
+		setNextCursor(w, &db.Cursor{Sort: auditCursorSort, ID: strconv.FormatInt(last.ID, 10)})
+	}
+
+	writeJSON(w, resp)
+}
+
+func auditToResponse(e *db.AuditEntry) auditResponse {
+	resp := auditResponse{
+		ID:         e.ID,
+		Username:   e.Username,
+		AuthMethod: e.AuthMethod,
+		SourceIP:   e.SourceIP,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		StatusCode: e.StatusCode,
+		Result:     e.Result,
+		RequestID:  e.RequestID,
+		CreatedAt:  e.CreatedAt.Format(time.RFC3339),
+	}
+	if e.UserID.Valid {
+		resp.UserID = &e.UserID.Int64
+	}
+	if e.APIKeyID.Valid {
+		resp.APIKeyID = &e.APIKeyID.Int64
+	}
+	if e.Summary != "" {
+		resp.Summary = json.RawMessage(e.Summary)
+	}
+	return resp
+}
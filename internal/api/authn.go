@@ -0,0 +1,199 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/auth"
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+// How a caller authenticated
+const (
+	authSession = "session"
+	authJWT     = "jwt"
+	authAPIKey  = "api_key"
+)
+
+// authenticator identifies the caller of a request from one kind of credential.
+// It returns nil if the request doesn't carry that kind of credential, so the next
+// authenticator can try, or an *authFailure if it carries one that's no good.
+type authenticator interface {
+	authenticate(r *http.Request) (*userInfo, error)
+}
+
+// authFailure rejects a request with 401 and the given message
+type authFailure struct {
+	message string
+}
+
+func (e *authFailure) Error() string {
+	return e.message
+}
+
+// authenticators lists the ways callers can authenticate, tried in order
+func (h *Handler) authenticators() []authenticator {
+	chain := []authenticator{sessionAuthenticator{h}}
+	if h.oidc != nil {
+		chain = append(chain, jwtAuthenticator{h})
+	}
+	return append(chain, apiKeyAuthenticator{h})
+}
+
+// authMiddleware identifies the caller with the first authenticator that recognises
+// its credentials, injects user info into context, applies the caller's rate limit
+// and checks the caller holds scope
+func (h *Handler) authMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	next = h.rateLimit(requireScope(scope, next))
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range h.authn {
+			user, err := a.authenticate(r)
+			var failure *authFailure
+			if errors.As(err, &failure) {
+				writeErrorCode(w, http.StatusUnauthorized, codeUnauthorized, failure.message, nil)
+				return
+			}
+			if err != nil {
+				slog.Error("authentication failed", "error", err)
+				writeError(w, "authentication error", http.StatusInternalServerError)
+				return
+			}
+			if user != nil {
+				noteAuditActor(r.Context(), user)
+				next(w, r.WithContext(setUserContext(r.Context(), user)))
+				return
+			}
+		}
+
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// sessionAuthenticator accepts SFS session cookies
+type sessionAuthenticator struct{ h *Handler }
+
+func (a sessionAuthenticator) authenticate(r *http.Request) (*userInfo, error) {
+	token := a.h.validator.SessionToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	username, err := a.h.validator.ValidateSession(token)
+	if err != nil {
+		return nil, fmt.Errorf("validate session: %w", err)
+	}
+	if username == "" {
+		// Fall through to the other authenticators, as an expired cookie shouldn't
+		// stop a request that also carries a bearer token
+		return nil, nil
+	}
+	// Those names belong to SSO identities; an SFS account mustn't pass for one
+	if strings.HasPrefix(username, db.IdentityUsernamePrefix) {
+		return nil, &authFailure{message: "invalid session"}
+	}
+
+	user, err := a.h.db.GetOrCreateUser(username)
+	if err != nil {
+		return nil, fmt.Errorf("look up user: %w", err)
+	}
+	return &userInfo{
+		UserID:     user.ID,
+		Username:   user.Username,
+		AuthMethod: authSession,
+		Scopes:     []string{scopeAll},
+	}, nil
+}
+
+// jwtAuthenticator accepts bearer JWTs from the configured OIDC issuer. Callers are
+// identified by the token's issuer and subject, never by a username in the token:
+// an identity acts as an SFS user only once that user has linked it (see
+// LinkIdentity), and otherwise as a user of its own.
+type jwtAuthenticator struct{ h *Handler }
+
+func (a jwtAuthenticator) authenticate(r *http.Request) (*userInfo, error) {
+	token := auth.GetAPIKeyFromRequest(r)
+	if !auth.LooksLikeJWT(token) {
+		return nil, nil
+	}
+
+	claims, err := a.h.oidc.Verify(r.Context(), token)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		slog.Info("rejected bearer token", "reason", err)
+		return nil, &authFailure{message: "invalid token"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.h.db.GetOrCreateIdentityUser(claims.Issuer, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("look up identity: %w", err)
+	}
+	return &userInfo{
+		UserID:     user.ID,
+		Username:   user.Username,
+		AuthMethod: authJWT,
+		Scopes:     tokenScopes(claims.Scopes),
+	}, nil
+}
+
+// tokenScopes picks out the API scopes a token was issued with. Tokens that don't
+// mention any, such as ordinary SSO logins, get full access like a web session.
+func tokenScopes(claimed []string) []string {
+	var scopes []string
+	for _, s := range claimed {
+		if slices.Contains(apiScopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return []string{scopeAll}
+	}
+	return scopes
+}
+
+// apiKeyAuthenticator accepts API keys as bearer tokens
+type apiKeyAuthenticator struct{ h *Handler }
+
+func (a apiKeyAuthenticator) authenticate(r *http.Request) (*userInfo, error) {
+	token := auth.GetAPIKeyFromRequest(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	key, err := a.h.lookupAPIKey(token)
+	if err != nil {
+		return nil, fmt.Errorf("look up api key: %w", err)
+	}
+	if key == nil {
+		return nil, nil
+	}
+	if now := time.Now(); !key.Usable(now) {
+		return nil, &authFailure{message: "API key " + key.Status(now)}
+	}
+
+	user, err := a.h.db.GetUser(key.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("look up user: %w", err)
+	}
+	if user == nil {
+		slog.Warn("api key belongs to unknown user", "key", key.ID, "user", key.UserID)
+		return nil, nil
+	}
+
+	// Update last used
+	_ = a.h.db.UpdateAPIKeyLastUsed(key.ID)
+	return &userInfo{
+		UserID:       user.ID,
+		Username:     user.Username,
+		AuthMethod:   authAPIKey,
+		APIKeyID:     key.ID,
+		Scopes:       key.Scopes,
+		ContainerIDs: key.ContainerIDs,
+		OrgID:        key.OrgID.Int64,
+	}, nil
+}
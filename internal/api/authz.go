@@ -0,0 +1,168 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+// permission is what a caller may do with a resource. Each includes the ones before it.
+type permission int
+
+const (
+	permNone permission = iota
+	// permView is seeing a resource
+	permView
+	// permOperate is starting, stopping and SSHing into containers, and adding SSH keys
+	permOperate
+	// permManage is creating, changing and deleting resources
+	permManage
+)
+
+// roleGrants is what each org role may do with the org's containers and keys.
+// Owners and admins differ only in who they can add to the org; see SetOrgMember.
+var roleGrants = map[string]permission{
+	db.RoleOwner:  permManage,
+	db.RoleAdmin:  permManage,
+	db.RoleMember: permOperate,
+	db.RoleViewer: permView,
+}
+
+// orgKeyScopes are the scopes an org's API keys can hold. Webhooks, settings and
+// the audit log are personal, so an org key can't reach its creator's.
+var orgKeyScopes = []string{
+	scopeContainersRead, scopeContainersWrite,
+	scopeSSHKeysRead, scopeSSHKeysWrite,
+	scopeAPIKeysManage,
+}
+
+// permission works out what the caller may do with resources owner has. Personal
+// resources are their owner's alone; an org's are open to its members as far as
+// their role allows. Callers using an org's API key are confined to that org.
+func (h *Handler) permission(user *userInfo, owner db.Owner) (permission, error) {
+	if user.OrgID != 0 && owner.OrgID != user.OrgID {
+		return permNone, nil
+	}
+	if !owner.IsOrg() {
+		if owner.UserID == user.UserID {
+			return permManage, nil
+		}
+		return permNone, nil
+	}
+
+	role, err := h.db.GetOrgRole(owner.OrgID, user.UserID)
+	if err != nil {
+		return permNone, err
+	}
+	return roleGrants[role], nil
+}
+
+// containerPermission is permission for a container, which may also have been
+// shared with the caller. Grantees can use a container but not change or delete it.
+func (h *Handler) containerPermission(user *userInfo, c *db.Container) (permission, error) {
+	have, err := h.permission(user, c.Owner())
+	if err != nil || have >= permOperate || user.OrgID != 0 {
+		return have, err
+	}
+
+	grant, err := h.db.GetContainerGrant(c.ID, user.UserID)
+	if err != nil {
+		return permNone, err
+	}
+	if grant != nil {
+		return permOperate, nil
+	}
+	return have, nil
+}
+
+// authorize checks the caller may act on a resource with want, writing an error
+// response and returning false if not. Callers that can't even see the resource get
+// a 404 naming what, so its existence isn't given away.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, owner db.Owner, want permission, what string) bool {
+	return h.authorizeWith(w, r, func(user *userInfo) (permission, error) {
+		return h.permission(user, owner)
+	}, want, what)
+}
+
+// authorizeContainer is authorize for a container, taking grants into account
+func (h *Handler) authorizeContainer(w http.ResponseWriter, r *http.Request, c *db.Container, want permission) bool {
+	return h.authorizeWith(w, r, func(user *userInfo) (permission, error) {
+		return h.containerPermission(user, c)
+	}, want, "container")
+}
+
+func (h *Handler) authorizeWith(w http.ResponseWriter, r *http.Request, check func(*userInfo) (permission, error), want permission, what string) bool {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	have, err := check(user)
+	if err != nil {
+		slog.Error("failed to check permission", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	if have < permView {
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, what+" not found", nil)
+		return false
+	}
+	if have < want {
+		writeErrorCode(w, http.StatusForbidden, codeForbidden, "your access to this "+what+" doesn't allow this", nil)
+		return false
+	}
+	return true
+}
+
+// requestOwner works out who a list or create request is for: the org orgID if
+// it's set, otherwise the caller. Org API keys act for their org by default.
+// The caller must hold want on the owner's resources.
+func (h *Handler) requestOwner(w http.ResponseWriter, r *http.Request, orgID int64, want permission) (db.Owner, bool) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return db.Owner{}, false
+	}
+	if orgID == 0 {
+		orgID = user.OrgID
+	}
+	if orgID == 0 {
+		return db.UserOwner(user.UserID), true
+	}
+
+	owner := db.OrgOwner(orgID)
+	if !h.authorize(w, r, owner, want, "org") {
+		return db.Owner{}, false
+	}
+	return owner, true
+}
+
+// queryOwner is requestOwner for the optional org_id query parameter of list endpoints
+func (h *Handler) queryOwner(w http.ResponseWriter, r *http.Request) (db.Owner, bool) {
+	return h.queryOwnerFor(w, r, permView)
+}
+
+// queryOwnerFor is requestOwner for the optional org_id query parameter
+func (h *Handler) queryOwnerFor(w http.ResponseWriter, r *http.Request, want permission) (db.Owner, bool) {
+	var orgID int64
+	if s := r.URL.Query().Get("org_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "invalid org_id", map[string]any{"field": "org_id"})
+			return db.Owner{}, false
+		}
+		orgID = id
+	}
+	return h.requestOwner(w, r, orgID, want)
+}
+
+// ownerOrgID is the org a resource belongs to for responses, or nil for personal ones
+func ownerOrgID(owner db.Owner) *int64 {
+	if !owner.IsOrg() {
+		return nil
+	}
+	return &owner.OrgID
+}
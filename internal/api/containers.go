@@ -2,7 +2,8 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -18,34 +19,66 @@ const (
 	defaultMemoryMB      = 512
 	defaultStorageGB     = 5
 	defaultImage         = "eddisonso/edd-compute-base:latest"
+	maxContainerTTL      = 30 * 24 * time.Hour
 )
 
 type containerRequest struct {
-	Name      string  `json:"name"`
-	MemoryMB  int     `json:"memory_mb"`
-	StorageGB int     `json:"storage_gb"`
-	SSHKeyIDs []int64 `json:"ssh_key_ids"`
+	Name       string  `json:"name" validate:"required,max=63"`
+	MemoryMB   int     `json:"memory_mb" validate:"min=128,max=8192"`
+	StorageGB  int     `json:"storage_gb" validate:"min=1,max=100"`
+	SSHKeyIDs  []int64 `json:"ssh_key_ids" validate:"max=10"`
+	TTLSeconds int64   `json:"ttl_seconds" validate:"min=60,max=2592000"`
+	ExpiresAt  string  `json:"expires_at" validate:"format=date-time"`
+	OnExpire   string  `json:"on_expire" validate:"oneof=delete stop"`
+	// IdleTimeoutMinutes overrides the user's idle timeout; 0 disables idle stops
+	IdleTimeoutMinutes *int64 `json:"idle_timeout_minutes" validate:"min=0,max=10080"`
+	// OrgID creates the container for an org, which needs the admin role. Every one
+	// of the org's SSH keys is installed, so ssh_key_ids, otherwise required, is
+	// optional; any it names must be org keys.
+	OrgID int64 `json:"org_id" validate:"min=1"`
+}
+
+type extendRequest struct {
+	TTLSeconds int64  `json:"ttl_seconds" validate:"min=60,max=2592000"`
+	ExpiresAt  string `json:"expires_at" validate:"format=date-time"`
 }
 
 type containerResponse struct {
-	ID         string  `json:"id"`
-	Name       string  `json:"name"`
-	Status     string  `json:"status"`
-	ExternalIP *string `json:"external_ip"`
-	SSHCommand *string `json:"ssh_command,omitempty"`
-	MemoryMB   int     `json:"memory_mb"`
-	StorageGB  int     `json:"storage_gb"`
-	CreatedAt  string  `json:"created_at"`
+	ID                 string  `json:"id"`
+	Name               string  `json:"name"`
+	Status             string  `json:"status"`
+	ExternalIP         *string `json:"external_ip"`
+	SSHCommand         *string `json:"ssh_command,omitempty"`
+	MemoryMB           int     `json:"memory_mb"`
+	StorageGB          int     `json:"storage_gb"`
+	StorageUsedBytes   *int64  `json:"storage_used_bytes"`
+	ExpiresAt          *string `json:"expires_at,omitempty"`
+	OnExpire           string  `json:"on_expire,omitempty"`
+	IdleTimeoutMinutes *int64  `json:"idle_timeout_minutes,omitempty"`
+	// OrgID is the org that owns the container, if it isn't personal
+	OrgID     *int64 `json:"org_id,omitempty"`
+	CreatedAt string `json:"created_at"`
 }
 
 func (h *Handler) ListContainers(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
+	owner, ok := h.queryOwner(w, r)
 	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	containers, err := h.db.ListContainersByUser(userID)
+	listOpts, err := parseListOptions(r, defaultListRows, maxListRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := db.ContainerListOptions{
+		ListOptions: listOpts,
+		Status:      r.URL.Query().Get("status"),
+		Image:       r.URL.Query().Get("image"),
+		IDs:         userFromContext(r.Context()).ContainerIDs,
+	}
+
+	containers, next, err := h.db.ListContainersByOwner(owner, opts)
 	if err != nil {
 		slog.Error("failed to list containers", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
@@ -57,6 +90,7 @@ func (h *Handler) ListContainers(w http.ResponseWriter, r *http.Request) {
 		resp = append(resp, containerToResponse(c))
 	}
 
+	setNextCursor(w, next)
 	writeJSON(w, resp)
 }
 
@@ -67,9 +101,18 @@ func (h *Handler) CreateContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A key restricted to some containers couldn't use the ones it creates
+	if userFromContext(r.Context()).ContainerIDs != nil {
+		writeErrorCode(w, http.StatusForbidden, codeForbidden, "API key is restricted to specific containers", nil)
+		return
+	}
+
 	var req containerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, "invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	owner, ok := h.requestOwner(w, r, req.OrgID, permManage)
+	if !ok {
 		return
 	}
 
@@ -80,25 +123,22 @@ func (h *Handler) CreateContainer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check container limit
-	count, err := h.db.CountContainersByUser(userID)
+	expiresAt, err := parseExpiry(req.TTLSeconds, req.ExpiresAt, time.Now())
 	if err != nil {
-		slog.Error("failed to count containers", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if count >= maxContainersPerUser {
-		writeError(w, fmt.Sprintf("container limit reached (%d)", maxContainersPerUser), http.StatusBadRequest)
-		return
+
+	onExpire := req.OnExpire
+	if onExpire == "" {
+		onExpire = db.ExpireActionDelete
 	}
 
-	// Validate SSH keys
-	if len(req.SSHKeyIDs) == 0 {
-		writeError(w, "at least one SSH key is required", http.StatusBadRequest)
+	if len(req.SSHKeyIDs) == 0 && !owner.IsOrg() {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "ssh_key_ids is required", map[string]any{"field": "ssh_key_ids"})
 		return
 	}
-
-	sshKeys, err := h.db.GetSSHKeysByIDs(userID, req.SSHKeyIDs)
+	sshKeys, err := h.db.GetSSHKeysByIDs(owner, req.SSHKeyIDs)
 	if err != nil {
 		slog.Error("failed to get ssh keys", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
@@ -108,14 +148,23 @@ func (h *Handler) CreateContainer(w http.ResponseWriter, r *http.Request) {
 		writeError(w, "one or more SSH keys not found", http.StatusBadRequest)
 		return
 	}
+	// Everyone in an org reaches its containers with the org's keys, whichever
+	// were named, as syncContainerSSHKeys keeps them in step with the org's list
+	if owner.IsOrg() {
+		if sshKeys, err = h.db.ListSSHKeysByOrg(owner.OrgID); err != nil {
+			slog.Error("failed to get org ssh keys", "error", err)
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// Set defaults
 	memoryMB := req.MemoryMB
-	if memoryMB <= 0 {
+	if memoryMB == 0 {
 		memoryMB = defaultMemoryMB
 	}
 	storageGB := req.StorageGB
-	if storageGB <= 0 {
+	if storageGB == 0 {
 		storageGB = defaultStorageGB
 	}
 
@@ -125,269 +174,421 @@ func (h *Handler) CreateContainer(w http.ResponseWriter, r *http.Request) {
 
 	// Create container record
 	container := &db.Container{
-		ID:        containerID,
-		UserID:    userID,
-		Name:      req.Name,
-		Namespace: namespace,
-		Status:    "pending",
-		MemoryMB:  memoryMB,
-		StorageGB: storageGB,
-		Image:     defaultImage,
-	}
-
-	if err := h.db.CreateContainer(container); err != nil {
+		ID:                 containerID,
+		UserID:             userID,
+		OrgID:              sql.NullInt64{Int64: owner.OrgID, Valid: owner.IsOrg()},
+		Name:               req.Name,
+		Namespace:          namespace,
+		Status:             db.StatusProvisioning,
+		MemoryMB:           memoryMB,
+		StorageGB:          storageGB,
+		Image:              defaultImage,
+		ExpiresAt:          expiresAt,
+		ExpireAction:       onExpire,
+		IdleTimeoutMinutes: nullInt64(req.IdleTimeoutMinutes),
+	}
+
+	if err := h.db.CreateContainer(container, maxContainersPerUser); err != nil {
+		if errors.Is(err, db.ErrLimitExceeded) {
+			writeLimitError(w, "container", maxContainersPerUser)
+			return
+		}
 		slog.Error("failed to create container record", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	h.recordEvent(container, db.StatusEventType(db.StatusProvisioning), "container created")
+	noteAuditTarget(r.Context(), container.ID)
 
-	// Create K8s resources in background
-	go h.provisionContainer(container, sshKeys)
+	op, err := h.startOperation(userID, container, db.OperationCreateContainer, func(ctx context.Context, progress progressFunc) (any, error) {
+		if err := h.provisionContainer(ctx, container, sshKeys, progress); err != nil {
+			return nil, err
+		}
+		return containerToResponse(container), nil
+	})
+	if err != nil {
+		slog.Error("failed to create operation", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 
-	writeJSON(w, containerToResponse(container))
+	writeOperation(w, op)
 }
 
-func (h *Handler) provisionContainer(container *db.Container, sshKeys []*db.SSHKey) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+// provisionContainer creates a new container's Kubernetes resources and waits for it to run
+func (h *Handler) provisionContainer(ctx context.Context, container *db.Container, sshKeys []*db.SSHKey, progress progressFunc) error {
+	if err := h.createContainerResources(ctx, container, sshKeys, progress); err != nil {
+		h.failContainer(container, "provisioning failed", err)
+		return err
+	}
 
-	// Build authorized_keys
-	var authorizedKeys strings.Builder
+	if err := h.transition(container, db.StatusStarting, "resources created, waiting for pod"); err != nil {
+		return err
+	}
+	slog.Info("container provisioned", "container", container.ID, "namespace", container.Namespace)
+
+	progress(80, "waiting for container to start")
+	return h.waitForContainer(ctx, container)
+}
+
+// authorizedKeys builds an authorized_keys file from SSH keys
+func authorizedKeys(sshKeys []*db.SSHKey) string {
+	var b strings.Builder
 	for _, key := range sshKeys {
-		authorizedKeys.WriteString(key.PublicKey)
-		authorizedKeys.WriteString("\n")
+		b.WriteString(key.PublicKey)
+		b.WriteString("\n")
 	}
+	return b.String()
+}
 
-	// Create namespace
+func (h *Handler) createContainerResources(ctx context.Context, container *db.Container, sshKeys []*db.SSHKey, progress progressFunc) error {
+	progress(10, "creating namespace")
 	if err := h.k8s.CreateNamespace(ctx, container.Namespace, container.UserID, container.ID); err != nil {
-		slog.Error("failed to create namespace", "container", container.ID, "error", err)
-		h.db.UpdateContainerStatus(container.ID, "failed")
-		return
+		return err
 	}
-
-	// Create SSH secret
-	if err := h.k8s.CreateSSHSecret(ctx, container.Namespace, authorizedKeys.String()); err != nil {
-		slog.Error("failed to create ssh secret", "container", container.ID, "error", err)
-		h.db.UpdateContainerStatus(container.ID, "failed")
-		return
+	progress(20, "creating ssh secret")
+	keys := authorizedKeys(sshKeys)
+	if err := h.db.SetContainerAuthorizedKeys(container.ID, keys); err != nil {
+		return err
 	}
-
-	// Create PVC
+	if err := h.k8s.CreateSSHSecret(ctx, container.Namespace, keys); err != nil {
+		return err
+	}
+	progress(30, "creating volume")
 	if err := h.k8s.CreatePVC(ctx, container.Namespace, container.StorageGB); err != nil {
-		slog.Error("failed to create pvc", "container", container.ID, "error", err)
-		h.db.UpdateContainerStatus(container.ID, "failed")
-		return
+		return err
 	}
-
-	// Create NetworkPolicy
+	progress(40, "creating network policy")
 	if err := h.k8s.CreateNetworkPolicy(ctx, container.Namespace); err != nil {
-		slog.Error("failed to create network policy", "container", container.ID, "error", err)
-		h.db.UpdateContainerStatus(container.ID, "failed")
-		return
+		return err
 	}
-
-	// Create Pod
+	progress(50, "creating pod")
 	if err := h.k8s.CreatePod(ctx, container.Namespace, container.Image, container.MemoryMB); err != nil {
-		slog.Error("failed to create pod", "container", container.ID, "error", err)
-		h.db.UpdateContainerStatus(container.ID, "failed")
-		return
-	}
-
-	// Create LoadBalancer service
-	if err := h.k8s.CreateLoadBalancer(ctx, container.Namespace); err != nil {
-		slog.Error("failed to create load balancer", "container", container.ID, "error", err)
-		h.db.UpdateContainerStatus(container.ID, "failed")
-		return
+		return err
 	}
-
-	h.db.UpdateContainerStatus(container.ID, "running")
-	slog.Info("container provisioned", "container", container.ID, "namespace", container.Namespace)
-
-	// Poll for external IP
-	go h.pollExternalIP(container)
+	progress(65, "creating load balancer")
+	return h.k8s.CreateLoadBalancer(ctx, container.Namespace)
 }
 
-func (h *Handler) pollExternalIP(container *db.Container) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
-
+// waitForContainer follows a starting container until its pod is running and its
+// load balancer has an external IP. A running container whose IP is still pending
+// when ctx ends counts as started.
+func (h *Handler) waitForContainer(ctx context.Context, container *db.Container) error {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Warn("timeout waiting for external IP", "container", container.ID)
-			return
-		case <-ticker.C:
-			ip, err := h.k8s.GetServiceExternalIP(ctx, container.Namespace)
-			if err != nil {
-				slog.Error("failed to get external ip", "container", container.ID, "error", err)
-				continue
+			if container.Status == db.StatusRunning {
+				return nil
 			}
-			if ip != "" {
-				if err := h.db.UpdateContainerIP(container.ID, ip); err != nil {
-					slog.Error("failed to update container ip", "container", container.ID, "error", err)
+			return fmt.Errorf("timed out waiting for container to start (status %s)", container.Status)
+		case <-ticker.C:
+			h.syncContainer(ctx, container)
+			switch container.Status {
+			case db.StatusStarting:
+			case db.StatusRunning:
+				if container.ExternalIP.Valid {
+					return nil
 				}
-				slog.Info("external IP assigned", "container", container.ID, "ip", ip)
-				return
+			default:
+				return fmt.Errorf("container is %s", container.Status)
 			}
 		}
 	}
 }
 
-func (h *Handler) GetContainer(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
-	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+// watchContainer runs waitForContainer in the background for callers that don't
+// track the outcome themselves
+func (h *Handler) watchContainer(container *db.Container) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
 
-	containerID := r.PathValue("id")
-	container, err := h.db.GetContainer(containerID)
-	if err != nil {
-		slog.Error("failed to get container", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
-		return
+	if err := h.waitForContainer(ctx, container); err != nil {
+		slog.Warn("container did not start", "container", container.ID, "error", err)
 	}
-	if container == nil || container.UserID != userID {
-		writeError(w, "container not found", http.StatusNotFound)
-		return
-	}
-
-	// Get current pod status from K8s
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-	defer cancel()
+}
 
-	status, err := h.k8s.GetPodStatus(ctx, container.Namespace)
-	if err == nil && status != "" && status != container.Status {
-		container.Status = status
-		h.db.UpdateContainerStatus(container.ID, status)
+// syncContainer updates a container's status and external IP from what Kubernetes reports.
+// Observed changes that the state machine doesn't allow (e.g. a pod vanishing while the
+// container is running) are left for an explicit action to resolve.
+func (h *Handler) syncContainer(ctx context.Context, container *db.Container) {
+	podStatus, err := h.k8s.GetPodStatus(ctx, container.Namespace)
+	if err != nil {
+		slog.Error("failed to get pod status", "container", container.ID, "error", err)
+	} else {
+		var observed string
+		switch podStatus {
+		case "running":
+			observed = db.StatusRunning
+		case "failed":
+			observed = db.StatusFailed
+		case "not_found":
+			observed = db.StatusStopped
+		}
+		if observed != "" && observed != container.Status && db.CanTransition(container.Status, observed) {
+			if err := h.transition(container, observed, "pod is "+podStatus); err != nil {
+				slog.Error("failed to update container status", "container", container.ID, "error", err)
+			}
+		}
 	}
 
-	// Check for IP if not yet assigned
 	if !container.ExternalIP.Valid {
 		ip, err := h.k8s.GetServiceExternalIP(ctx, container.Namespace)
 		if err == nil && ip != "" {
+			if err := h.db.UpdateContainerIP(container.ID, ip); err != nil {
+				slog.Error("failed to update container ip", "container", container.ID, "error", err)
+				return
+			}
 			container.ExternalIP.String = ip
 			container.ExternalIP.Valid = true
-			h.db.UpdateContainerIP(container.ID, ip)
+			h.recordEvent(container, db.EventIPAssigned, "external IP "+ip+" assigned")
 		}
 	}
-
-	writeJSON(w, containerToResponse(container))
 }
 
-func (h *Handler) DeleteContainer(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
-	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
-		return
+// transition moves a container to a new state and records the change as a lifecycle event
+func (h *Handler) transition(container *db.Container, to, message string) error {
+	if _, err := h.db.TransitionContainer(container.ID, to); err != nil {
+		return err
 	}
+	container.Status = to
+	if to == db.StatusStopped {
+		container.StoppedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	h.recordEvent(container, db.StatusEventType(to), message)
+	return nil
+}
 
-	containerID := r.PathValue("id")
-	container, err := h.db.GetContainer(containerID)
+// getContainerForRequest loads the container named by the {id} path value, writing an
+// error response and returning false if it doesn't exist or the caller doesn't hold want on it
+func (h *Handler) getContainerForRequest(w http.ResponseWriter, r *http.Request, want permission) (*db.Container, bool) {
+	container, err := h.db.GetContainer(r.PathValue("id"))
 	if err != nil {
 		slog.Error("failed to get container", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
-	if container == nil || container.UserID != userID {
+	if container == nil || !canAccessContainer(r.Context(), container.ID) {
 		writeError(w, "container not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !h.authorizeContainer(w, r, container, want) {
+		return nil, false
+	}
+	return container, true
+}
+
+func (h *Handler) GetContainer(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permView)
+	if !ok {
 		return
 	}
 
-	// Delete namespace (will cascade delete all resources)
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	// Refresh status and IP from K8s
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	if err := h.k8s.DeleteNamespace(ctx, container.Namespace); err != nil {
-		slog.Error("failed to delete namespace", "error", err)
-		writeError(w, "failed to delete container", http.StatusInternalServerError)
+	h.syncContainer(ctx, container)
+
+	writeJSON(w, containerToResponse(container))
+}
+
+func (h *Handler) DeleteContainer(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permManage)
+	if !ok {
 		return
 	}
 
-	if err := h.db.DeleteContainer(containerID); err != nil {
-		slog.Error("failed to delete container record", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
-		return
+	h.startContainerOperation(w, r, container, db.OperationDeleteContainer, db.StatusDeleting,
+		func(ctx context.Context, progress progressFunc) (any, error) {
+			progress(10, "deleting namespace")
+			if err := h.deleteContainer(ctx, container); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		})
+}
+
+// deleteContainer removes the container's namespace (which cascades to all its
+// resources) and then its record
+func (h *Handler) deleteContainer(ctx context.Context, container *db.Container) error {
+	if err := h.transition(container, db.StatusDeleting, "delete requested"); err != nil {
+		return err
+	}
+
+	if err := h.k8s.DeleteNamespace(ctx, container.Namespace); err != nil {
+		h.failContainer(container, "delete failed", err)
+		return err
+	}
+	if err := h.db.DeleteContainer(container.ID); err != nil {
+		return err
 	}
 
-	writeJSON(w, map[string]string{"status": "ok"})
+	h.recordEvent(container, db.EventContainerDeleted, "container deleted")
+	return nil
+}
+
+// failContainer marks a container failed after an action on it errored
+func (h *Handler) failContainer(container *db.Container, action string, cause error) {
+	if err := h.transition(container, db.StatusFailed, fmt.Sprintf("%s: %v", action, cause)); err != nil {
+		slog.Error("failed to mark container failed", "container", container.ID, "error", err)
+	}
 }
 
 func (h *Handler) StopContainer(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
+	container, ok := h.getContainerForRequest(w, r, permOperate)
 	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	containerID := r.PathValue("id")
-	container, err := h.db.GetContainer(containerID)
-	if err != nil {
-		slog.Error("failed to get container", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
-		return
+	h.startContainerOperation(w, r, container, db.OperationStopContainer, db.StatusStopping,
+		func(ctx context.Context, progress progressFunc) (any, error) {
+			progress(10, "deleting pod")
+			if err := h.stopContainer(ctx, container); err != nil {
+				return nil, err
+			}
+			return containerToResponse(container), nil
+		})
+}
+
+// stopContainer deletes the container's pod, keeping its volume, secret and service
+func (h *Handler) stopContainer(ctx context.Context, container *db.Container) error {
+	if err := h.transition(container, db.StatusStopping, "stop requested"); err != nil {
+		return err
 	}
-	if container == nil || container.UserID != userID {
-		writeError(w, "container not found", http.StatusNotFound)
+
+	if err := h.k8s.DeletePod(ctx, container.Namespace); err != nil {
+		h.failContainer(container, "stop failed", err)
+		return err
+	}
+
+	return h.transition(container, db.StatusStopped, "pod deleted")
+}
+
+func (h *Handler) StartContainer(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permOperate)
+	if !ok {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-	defer cancel()
+	h.startContainerOperation(w, r, container, db.OperationStartContainer, db.StatusStarting,
+		func(ctx context.Context, progress progressFunc) (any, error) {
+			progress(10, "creating pod")
+			if err := h.startContainer(ctx, container); err != nil {
+				return nil, err
+			}
+			progress(50, "waiting for container to start")
+			if err := h.waitForContainer(ctx, container); err != nil {
+				return nil, err
+			}
+			return containerToResponse(container), nil
+		})
+}
 
-	if err := h.k8s.DeletePod(ctx, container.Namespace); err != nil {
-		slog.Error("failed to delete pod", "error", err)
-		writeError(w, "failed to stop container", http.StatusInternalServerError)
+// startContainerOperation checks that the container can move to the action's first state,
+// so invalid requests get an immediate 409, and then runs the action as an operation
+// belonging to the caller
+func (h *Handler) startContainerOperation(w http.ResponseWriter, r *http.Request, container *db.Container, opType, firstState string, fn operationFunc) {
+	if !db.CanTransition(container.Status, firstState) {
+		writeDBError(w, &db.TransitionError{ContainerID: container.ID, Current: container.Status, Requested: firstState}, "container")
 		return
 	}
 
-	if err := h.db.UpdateContainerStopped(containerID); err != nil {
-		slog.Error("failed to update container status", "error", err)
+	op, err := h.startOperation(userFromContext(r.Context()).UserID, container, opType, fn)
+	if err != nil {
+		slog.Error("failed to create operation", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 
-	container.Status = "stopped"
-	writeJSON(w, containerToResponse(container))
+	writeOperation(w, op)
 }
 
-func (h *Handler) StartContainer(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
+// startContainer recreates the container's pod against its existing volume and secret
+func (h *Handler) startContainer(ctx context.Context, container *db.Container) error {
+	if err := h.transition(container, db.StatusStarting, "start requested"); err != nil {
+		return err
+	}
+
+	if err := h.k8s.CreatePod(ctx, container.Namespace, container.Image, container.MemoryMB); err != nil {
+		h.failContainer(container, "start failed", err)
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) ExtendContainer(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permManage)
 	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	containerID := r.PathValue("id")
-	container, err := h.db.GetContainer(containerID)
-	if err != nil {
-		slog.Error("failed to get container", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
+	var req extendRequest
+	if !decodeRequest(w, r, &req) {
 		return
 	}
-	if container == nil || container.UserID != userID {
-		writeError(w, "container not found", http.StatusNotFound)
+	if req.TTLSeconds == 0 && req.ExpiresAt == "" {
+		writeError(w, "ttl_seconds or expires_at is required", http.StatusBadRequest)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
-	defer cancel()
+	// A relative extension is added to the current expiry rather than to now
+	base := time.Now()
+	if req.TTLSeconds > 0 && container.ExpiresAt.Valid && container.ExpiresAt.Time.After(base) {
+		base = container.ExpiresAt.Time
+	}
 
-	if err := h.k8s.CreatePod(ctx, container.Namespace, container.Image, container.MemoryMB); err != nil {
-		slog.Error("failed to create pod", "error", err)
-		writeError(w, "failed to start container", http.StatusInternalServerError)
+	expiresAt, err := parseExpiry(req.TTLSeconds, req.ExpiresAt, base)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := h.db.UpdateContainerStatus(containerID, "pending"); err != nil {
-		slog.Error("failed to update container status", "error", err)
+	if err := h.db.UpdateContainerExpiry(container.ID, expiresAt); err != nil {
+		slog.Error("failed to update container expiry", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
 	}
 
-	container.Status = "pending"
+	container.ExpiresAt = expiresAt
+	container.ExpiryWarnedAt = sql.NullTime{}
 	writeJSON(w, containerToResponse(container))
 }
 
+// parseExpiry turns either a TTL relative to base or an absolute RFC 3339 time
+// into an expiry, capped at maxContainerTTL from now
+func parseExpiry(ttlSeconds int64, expiresAt string, base time.Time) (sql.NullTime, error) {
+	if ttlSeconds != 0 && expiresAt != "" {
+		return sql.NullTime{}, fmt.Errorf("only one of ttl_seconds and expires_at may be set")
+	}
+
+	var t time.Time
+	switch {
+	case ttlSeconds < 0:
+		return sql.NullTime{}, fmt.Errorf("ttl_seconds must be positive")
+	case ttlSeconds > 0:
+		t = base.Add(time.Duration(ttlSeconds) * time.Second)
+	case expiresAt != "":
+		parsed, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return sql.NullTime{}, fmt.Errorf("expires_at must be an RFC 3339 timestamp")
+		}
+		if !parsed.After(time.Now()) {
+			return sql.NullTime{}, fmt.Errorf("expires_at must be in the future")
+		}
+		t = parsed
+	default:
+		return sql.NullTime{}, nil
+	}
+
+	if t.After(time.Now().Add(maxContainerTTL)) {
+		return sql.NullTime{}, fmt.Errorf("expiry cannot be more than %d days away", int(maxContainerTTL.Hours()/24))
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+}
+
 func containerToResponse(c *db.Container) containerResponse {
 	resp := containerResponse{
 		ID:        c.ID,
@@ -404,5 +605,20 @@ func containerToResponse(c *db.Container) containerResponse {
 		resp.SSHCommand = &sshCmd
 	}
 
+	if c.StorageUsedBytes.Valid {
+		resp.StorageUsedBytes = &c.StorageUsedBytes.Int64
+	}
+
+	if c.ExpiresAt.Valid {
+		expiresAt := c.ExpiresAt.Time.Format(time.RFC3339)
+		resp.ExpiresAt = &expiresAt
+		resp.OnExpire = c.ExpireAction
+	}
+
+	if c.IdleTimeoutMinutes.Valid {
+		resp.IdleTimeoutMinutes = &c.IdleTimeoutMinutes.Int64
+	}
+
+	resp.OrgID = ownerOrgID(c.Owner())
 	return resp
 }
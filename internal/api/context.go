@@ -6,24 +6,43 @@ import (
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey  contextKey = "user"
+	routeContextKey contextKey = "route"
+)
 
 type userInfo struct {
-	UserID   int64
+	UserID int64
+	// Username is the user's SFS username, which is empty only for the legacy user
+	// (see db.ClaimLegacyUser) until it's claimed. Users created for unlinked SSO
+	// identities have a db.IdentityUsernamePrefix name instead.
 	Username string
+	// AuthMethod is how the caller authenticated: authSession, authJWT or authAPIKey
+	AuthMethod string
+	// APIKeyID is the key used, if AuthMethod is authAPIKey
+	APIKeyID int64
+	// Scopes are what the caller may do; see hasScope
+	Scopes []string
+	// ContainerIDs restricts the caller to these containers unless nil
+	ContainerIDs []string
+	// OrgID confines the caller to that org's resources, for org API keys
+	OrgID int64
 }
 
-func setUserContext(ctx context.Context, userID int64, username string) context.Context {
-	return context.WithValue(ctx, userContextKey, &userInfo{
-		UserID:   userID,
-		Username: username,
-	})
+func setUserContext(ctx context.Context, info *userInfo) context.Context {
+	return context.WithValue(ctx, userContextKey, info)
 }
 
 func getUserFromContext(ctx context.Context) (int64, string, bool) {
-	info, ok := ctx.Value(userContextKey).(*userInfo)
-	if !ok || info == nil {
+	info := userFromContext(ctx)
+	if info == nil {
 		return 0, "", false
 	}
 	return info.UserID, info.Username, true
 }
+
+// userFromContext returns everything known about the caller, or nil outside authMiddleware
+func userFromContext(ctx context.Context) *userInfo {
+	info, _ := ctx.Value(userContextKey).(*userInfo)
+	return info
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+	"eddisonso.com/edd-compute/internal/k8s"
+)
+
+const (
+	diskUsageRetention   = 30 * 24 * time.Hour
+	defaultDiskUsageRows = 100
+	maxDiskUsageRows     = 500
+)
+
+type diskUsageResponse struct {
+	UsedBytes     int64   `json:"used_bytes"`
+	CapacityBytes int64   `json:"capacity_bytes"`
+	UsedPercent   float64 `json:"used_percent"`
+	RecordedAt    string  `json:"recorded_at"`
+}
+
+func (h *Handler) GetContainerDiskUsage(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permView)
+	if !ok {
+		return
+	}
+
+	limit, err := parseLimit(r, defaultDiskUsageRows, maxDiskUsageRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	samples, err := h.db.ListDiskUsage(container.ID, limit)
+	if err != nil {
+		slog.Error("failed to list disk usage", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]diskUsageResponse, 0, len(samples))
+	for _, s := range samples {
+		resp = append(resp, diskUsageResponse{
+			UsedBytes:     s.UsedBytes,
+			CapacityBytes: s.CapacityBytes,
+			UsedPercent:   usedPercent(s.UsedBytes, s.CapacityBytes),
+			RecordedAt:    s.RecordedAt.Format(time.RFC3339),
+		})
+	}
+
+	writeJSON(w, resp)
+}
+
+// RunDiskMonitor samples the storage volume of every running container until ctx is cancelled.
+// A lifecycle event is recorded whenever usage rises across one of the percentage thresholds.
+func (h *Handler) RunDiskMonitor(ctx context.Context, interval time.Duration, thresholds []int) {
+	sort.Ints(thresholds)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.sampleDiskUsage(ctx, thresholds)
+		}
+	}
+}
+
+func (h *Handler) sampleDiskUsage(ctx context.Context, thresholds []int) {
+	containers, err := h.db.ListContainersByStatus(db.StatusRunning)
+	if err != nil {
+		slog.Error("failed to list running containers", "error", err)
+		return
+	}
+
+	for _, c := range containers {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := h.sampleContainerDiskUsage(ctx, c, thresholds); err != nil {
+			if errors.Is(err, k8s.ErrStatsForbidden) {
+				// Every container would fail the same way
+				slog.Error("failed to sample disk usage", "error", err)
+				break
+			}
+			slog.Error("failed to sample disk usage", "container", c.ID, "error", err)
+		}
+	}
+
+	if err := h.db.DeleteDiskUsageBefore(time.Now().Add(-diskUsageRetention)); err != nil {
+		slog.Error("failed to prune disk usage", "error", err)
+	}
+}
+
+func (h *Handler) sampleContainerDiskUsage(ctx context.Context, c *db.Container, thresholds []int) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	stats, err := h.k8s.GetPodStats(ctx, c.Namespace)
+	if err != nil {
+		return err
+	}
+	if stats == nil {
+		return nil
+	}
+	volume, ok := stats.Volumes["storage"]
+	if !ok {
+		return nil
+	}
+
+	// Fall back to the requested size when the volume plugin doesn't report capacity
+	capacity := int64(volume.CapacityBytes)
+	if capacity == 0 {
+		capacity = int64(c.StorageGB) << 30
+	}
+	sample := &db.DiskUsage{
+		ContainerID:   c.ID,
+		UsedBytes:     int64(volume.UsedBytes),
+		CapacityBytes: capacity,
+	}
+
+	previous, err := h.db.GetLatestDiskUsage(c.ID)
+	if err != nil {
+		return err
+	}
+	if err := h.db.CreateDiskUsage(sample); err != nil {
+		return err
+	}
+	if err := h.db.UpdateContainerStorageUsed(c.ID, sample.UsedBytes); err != nil {
+		return err
+	}
+
+	var before float64
+	if previous != nil {
+		before = usedPercent(previous.UsedBytes, previous.CapacityBytes)
+	}
+	now := usedPercent(sample.UsedBytes, sample.CapacityBytes)
+
+	// Only alert on the highest threshold crossed so a jump from 50% to 99% raises one event
+	crossed := 0
+	for _, t := range thresholds {
+		if before < float64(t) && now >= float64(t) {
+			crossed = t
+		}
+	}
+	if crossed > 0 {
+		slog.Warn("container disk usage above threshold", "container", c.ID, "threshold", crossed, "used_percent", now)
+		h.recordEvent(c, db.EventDiskThreshold,
+			fmt.Sprintf("storage volume is %.1f%% full (%d of %d bytes), above the %d%% threshold", now, sample.UsedBytes, sample.CapacityBytes, crossed))
+	}
+	return nil
+}
+
+func usedPercent(used, capacity int64) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(used) / float64(capacity) * 100
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+// Error codes returned in the "code" field of error responses. These are part of
+// the API: clients match on them, so existing codes must never change meaning.
+const (
+	codeInvalidRequest   = "invalid_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeInvalidState     = "invalid_state"
+	codeLimitExceeded    = "limit_exceeded"
+	codeIdempotencyReuse = "idempotency_key_reused"
+	codeIdempotencyBusy  = "idempotency_key_in_progress"
+	codeIdempotencyDone  = "idempotency_key_completed"
+	codeUnprocessable    = "unprocessable"
+	codeRateLimited      = "rate_limited"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInternal         = "internal"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// writeError writes an error response with the default code for the status
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeErrorCode(w, status, statusCode(status), message, nil)
+}
+
+// writeErrorCode writes an error response with a specific code and optional details
+func writeErrorCode(w http.ResponseWriter, status int, code, message string, details map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	resp := errorResponse{Error: errorBody{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: w.Header().Get(requestIDHeader),
+	}}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("failed to encode error response", "error", err)
+	}
+}
+
+// writeDBError maps an error from the db package onto a response. what names the
+// resource in client messages, e.g. "container". Conflicts get a fixed message, since
+// the db error names internal rows; it and unexpected errors are logged, and the
+// latter reported as internal errors.
+func writeDBError(w http.ResponseWriter, err error, what string) {
+	var transition *db.TransitionError
+	switch {
+	case errors.As(err, &transition):
+		writeErrorCode(w, http.StatusConflict, codeInvalidState, "container is "+transition.Current, map[string]any{
+			"status":    transition.Current,
+			"requested": transition.Requested,
+		})
+	case errors.Is(err, db.ErrNotFound):
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, what+" not found", nil)
+	case errors.Is(err, db.ErrLastOwner):
+		slog.Error("database conflict", "resource", what, "error", err)
+		writeErrorCode(w, http.StatusConflict, codeConflict, "org must keep at least one owner", nil)
+	case errors.Is(err, db.ErrConflict):
+		slog.Error("database conflict", "resource", what, "error", err)
+		writeErrorCode(w, http.StatusConflict, codeConflict, what+" is no longer in a state that allows this", nil)
+	default:
+		slog.Error("database error", "resource", what, "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+	}
+}
+
+// writeLimitError reports that creating another resource would exceed the caller's quota
+func writeLimitError(w http.ResponseWriter, what string, limit int) {
+	writeErrorCode(w, http.StatusBadRequest, codeLimitExceeded, what+" limit reached", map[string]any{
+		"limit": limit,
+	})
+}
+
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInvalidRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusUnprocessableEntity:
+		return codeUnprocessable
+	case http.StatusTooManyRequests:
+		return codeRateLimited
+	default:
+		if status >= 500 {
+			return codeInternal
+		}
+		return codeInvalidRequest
+	}
+}
+
+// jsonMuxErrors answers requests mux has no route for with error responses in place
+// of its plain-text 404 and 405s, keeping the Allow header it sets on the latter
+func jsonMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Redirects to a cleaned path report the pattern they'll match
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{header: w.Header(), status: http.StatusNotFound}
+		mux.ServeHTTP(rec, r)
+		if rec.status == http.StatusMethodNotAllowed {
+			writeError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeError(w, "not found", http.StatusNotFound)
+	})
+}
+
+// statusRecorder keeps the status a handler writes and drops its body
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (rec *statusRecorder) Header() http.Header { return rec.header }
+
+func (rec *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+
+func (rec *statusRecorder) WriteHeader(status int) { rec.status = status }
+
+// requestID tags each request with an ID, returned in the X-Request-ID header and in
+// error responses so failures can be matched to server logs. A well-formed ID sent by
+// the client (or a proxy in front of us) is kept.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
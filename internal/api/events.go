@@ -0,0 +1,115 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+const (
+	defaultEventRows = 50
+	maxEventRows     = 500
+)
+
+type eventResponse struct {
+	ID          int64  `json:"id"`
+	ContainerID string `json:"container_id"`
+	Type        string `json:"type"`
+	Message     string `json:"message"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (h *Handler) ListContainerEvents(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permView)
+	if !ok {
+		return
+	}
+
+	limit, err := parseLimit(r, defaultEventRows, maxEventRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.db.ListContainerEvents(container.ID, limit)
+	if err != nil {
+		slog.Error("failed to list container events", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]eventResponse, 0, len(events))
+	for _, e := range events {
+		resp = append(resp, eventToResponse(e))
+	}
+
+	writeJSON(w, resp)
+}
+
+// recordEvent stores a lifecycle event for a container
+// Failures are logged rather than returned since events never block the action that caused them
+func (h *Handler) recordEvent(c *db.Container, eventType, message string) {
+	event := &db.ContainerEvent{
+		ContainerID: c.ID,
+		UserID:      c.UserID,
+		OrgID:       c.OrgID,
+		Type:        eventType,
+		Message:     message,
+	}
+	if err := h.db.CreateContainerEvent(event); err != nil {
+		slog.Error("failed to record container event", "container", c.ID, "type", eventType, "error", err)
+		return
+	}
+	slog.Info("container event", "container", c.ID, "type", eventType, "message", message)
+
+	audience, err := h.eventAudience(c)
+	if err != nil {
+		slog.Error("failed to find who can see container event", "container", c.ID, "error", err)
+		audience = []int64{c.UserID}
+	}
+	for _, userID := range audience {
+		h.events.notify(userID)
+	}
+	h.enqueueWebhooks(c, event, audience)
+}
+
+// eventAudience returns the users who can see a container's events: its owner, or
+// every member of the org that owns it, and anyone it's shared with
+func (h *Handler) eventAudience(c *db.Container) ([]int64, error) {
+	var users []int64
+	if c.OrgID.Valid {
+		members, err := h.db.ListOrgMembers(c.OrgID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			users = append(users, m.UserID)
+		}
+	} else {
+		users = append(users, c.UserID)
+	}
+
+	grants, err := h.db.ListContainerGrants(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		users = append(users, g.UserID)
+	}
+
+	slices.Sort(users)
+	return slices.Compact(users), nil
+}
+
+func eventToResponse(e *db.ContainerEvent) eventResponse {
+	return eventResponse{
+		ID:          e.ID,
+		ContainerID: e.ContainerID,
+		Type:        e.Type,
+		Message:     e.Message,
+		CreatedAt:   e.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+// maxGrantsPerContainer bounds how many users a container can be shared with
+const maxGrantsPerContainer = 10
+
+type grantResponse struct {
+	ContainerID string `json:"container_id"`
+	UserID      int64  `json:"user_id"`
+	Username    string `json:"username"`
+	// GrantedBy is the username of whoever shared the container
+	GrantedBy string `json:"granted_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// sharedContainerResponse is a container someone else shared with the caller,
+// which they can start, stop and SSH into but not change or delete
+type sharedContainerResponse struct {
+	containerResponse
+	GrantedBy string `json:"granted_by"`
+	GrantedAt string `json:"granted_at"`
+}
+
+// ListContainerGrants returns who a container is shared with
+func (h *Handler) ListContainerGrants(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permManage)
+	if !ok {
+		return
+	}
+
+	grants, err := h.db.ListContainerGrants(container.ID)
+	if err != nil {
+		slog.Error("failed to list container grants", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]grantResponse, 0, len(grants))
+	for _, g := range grants {
+		resp = append(resp, grantToResponse(g))
+	}
+	writeJSON(w, resp)
+}
+
+// GrantContainerAccess shares a container with another user. Their SSH keys are
+// added to the container, and they can start and stop it.
+func (h *Handler) GrantContainerAccess(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	container, ok := h.getContainerForRequest(w, r, permManage)
+	if !ok {
+		return
+	}
+
+	grantee, ok := h.granteeForRequest(w, r)
+	if !ok {
+		return
+	}
+	if !container.OrgID.Valid && grantee.ID == container.UserID {
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "the container already belongs to "+grantee.Username, nil)
+		return
+	}
+
+	grant := &db.ContainerGrant{ContainerID: container.ID, UserID: grantee.ID, GrantedBy: userID}
+	if err := h.db.CreateContainerGrant(grant, maxGrantsPerContainer); err != nil {
+		if errors.Is(err, db.ErrLimitExceeded) {
+			writeLimitError(w, "container grant", maxGrantsPerContainer)
+			return
+		}
+		slog.Error("failed to create container grant", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.syncContainerSSHKeys(r.Context(), container); err != nil {
+		slog.Error("failed to update ssh keys", "container", container.ID, "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := h.db.GetContainerGrant(container.ID, grantee.ID)
+	if err != nil || saved == nil {
+		slog.Error("failed to get container grant", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, grantToResponse(saved))
+}
+
+// RevokeContainerAccess stops sharing a container with a user and removes their
+// SSH keys from it. Grantees can give up their own access.
+func (h *Handler) RevokeContainerAccess(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	container, ok := h.getContainerForRequest(w, r, permView)
+	if !ok {
+		return
+	}
+
+	grantee, ok := h.granteeForRequest(w, r)
+	if !ok {
+		return
+	}
+	if grantee.ID != userID && !h.authorizeContainer(w, r, container, permManage) {
+		return
+	}
+
+	if err := h.db.DeleteContainerGrant(container.ID, grantee.ID); err != nil {
+		writeDBError(w, err, "container grant")
+		return
+	}
+	if err := h.syncContainerSSHKeys(r.Context(), container); err != nil {
+		slog.Error("failed to update ssh keys", "container", container.ID, "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// ListSharedContainers returns the containers others have shared with the caller.
+// They're kept out of the main list, which is what the caller owns.
+func (h *Handler) ListSharedContainers(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	listOpts, err := parseListOptions(r, defaultListRows, maxListRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Grants are personal, so an org's API key has none
+	if user.OrgID != 0 {
+		writeJSON(w, []sharedContainerResponse{})
+		return
+	}
+	opts := db.ContainerListOptions{
+		ListOptions: listOpts,
+		Status:      r.URL.Query().Get("status"),
+		Image:       r.URL.Query().Get("image"),
+		IDs:         user.ContainerIDs,
+	}
+
+	containers, next, err := h.db.ListContainersSharedWith(user.UserID, opts)
+	if err != nil {
+		slog.Error("failed to list shared containers", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	grants, err := h.db.ListContainerGrantsByUser(user.UserID)
+	if err != nil {
+		slog.Error("failed to list container grants", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	byContainer := make(map[string]*db.ContainerGrant, len(grants))
+	for _, g := range grants {
+		byContainer[g.ContainerID] = g
+	}
+
+	resp := make([]sharedContainerResponse, 0, len(containers))
+	for _, c := range containers {
+		shared := sharedContainerResponse{containerResponse: containerToResponse(c)}
+		if g := byContainer[c.ID]; g != nil {
+			shared.GrantedBy = g.GrantedByUsername
+			shared.GrantedAt = g.CreatedAt.Format(time.RFC3339)
+		}
+		resp = append(resp, shared)
+	}
+
+	setNextCursor(w, next)
+	writeJSON(w, resp)
+}
+
+// granteeForRequest looks up the user named in the path, who must have signed in
+// at least once
+func (h *Handler) granteeForRequest(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	user, err := h.db.GetUserByUsername(r.PathValue("username"))
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, "user not found", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+func grantToResponse(g *db.ContainerGrant) grantResponse {
+	return grantResponse{
+		ContainerID: g.ContainerID,
+		UserID:      g.UserID,
+		Username:    g.Username,
+		GrantedBy:   g.GrantedByUsername,
+		CreatedAt:   g.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -1,57 +1,205 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"eddisonso.com/edd-compute/internal/auth"
 	"eddisonso.com/edd-compute/internal/db"
 	"eddisonso.com/edd-compute/internal/k8s"
 )
 
+// Page sizes for the container, SSH key and API key lists
+const (
+	defaultListRows = 50
+	maxListRows     = 200
+)
+
+// Config holds server settings for the API
+type Config struct {
+	// APIKeyMaxLifetime caps how long an API key stays valid. Keys created without an
+	// expiry get this one. Zero allows keys that never expire.
+	APIKeyMaxLifetime time.Duration
+	// APIKeyPepper is the server-side secret API keys are hashed with. Changing it
+	// invalidates every key made since the eddc_ format was introduced.
+	APIKeyPepper []byte
+	// Session configures how SFS session cookies are validated and cached
+	Session auth.SessionConfig
+	// OIDC enables JWT bearer tokens from an identity provider when set
+	OIDC *auth.OIDCConfig
+	// RateLimits are each caller's request budgets
+	RateLimits RateLimits
+	// AdminUsers are the SFS usernames that can see every user's audit log
+	AdminUsers []string
+	// TrustForwardedFor takes the client address recorded in the audit log from
+	// X-Forwarded-For, for when the API is only reachable through a proxy
+	TrustForwardedFor bool
+	// ForwardAudit also sends audit log entries to the service log
+	ForwardAudit bool
+}
+
 type Handler struct {
+	cfg       Config
 	db        *db.DB
 	k8s       *k8s.Client
 	validator *auth.SessionValidator
+	apiKeys   *auth.APIKeyHasher
+	oidc      *auth.OIDCVerifier
+	authn     []authenticator
+	limiter   *rateLimiter
+	events    *eventBroker
 	mux       *http.ServeMux
+	root      http.Handler
+	routes    []string
+	spec      []byte
+
+	// webhookWake tells the webhook deliverer a delivery was queued
+	webhookWake chan struct{}
 }
 
-func NewHandler(database *db.DB, k8sClient *k8s.Client) http.Handler {
+func NewHandler(database *db.DB, k8sClient *k8s.Client, cfg Config) *Handler {
 	h := &Handler{
+		cfg:       cfg,
 		db:        database,
 		k8s:       k8sClient,
-		validator: auth.NewSessionValidator("http://simple-file-share-backend"),
+		validator: auth.NewSessionValidator(cfg.Session),
+		apiKeys:   auth.NewAPIKeyHasher(cfg.APIKeyPepper),
+		events:    newEventBroker(),
+		limiter:   newRateLimiter(),
 		mux:       http.NewServeMux(),
+
+		webhookWake: make(chan struct{}, 1),
 	}
+	if cfg.OIDC != nil {
+		h.oidc = auth.NewOIDCVerifier(*cfg.OIDC)
+	}
+	h.authn = h.authenticators()
 
 	// Health check (both paths for internal probes and external ingress access)
-	h.mux.HandleFunc("GET /healthz", h.Healthz)
-	h.mux.HandleFunc("GET /compute/healthz", h.Healthz)
+	h.handle("GET /healthz", h.Healthz)
+	h.handle("GET /compute/healthz", h.Healthz)
+
+	// API description
+	h.route("GET /openapi.json", h.GetOpenAPISpec)
 
 	// Container endpoints
-	h.mux.HandleFunc("GET /compute/containers", h.authMiddleware(h.ListContainers))
-	h.mux.HandleFunc("POST /compute/containers", h.authMiddleware(h.CreateContainer))
-	h.mux.HandleFunc("GET /compute/containers/{id}", h.authMiddleware(h.GetContainer))
-	h.mux.HandleFunc("DELETE /compute/containers/{id}", h.authMiddleware(h.DeleteContainer))
-	h.mux.HandleFunc("POST /compute/containers/{id}/stop", h.authMiddleware(h.StopContainer))
-	h.mux.HandleFunc("POST /compute/containers/{id}/start", h.authMiddleware(h.StartContainer))
+	h.route("GET /containers", h.authMiddleware(scopeContainersRead, h.ListContainers))
+	h.route("POST /containers", h.authMiddleware(scopeContainersWrite, h.expensive(h.idempotent(h.CreateContainer))))
+	h.route("GET /containers/{id}", h.authMiddleware(scopeContainersRead, h.GetContainer))
+	h.route("DELETE /containers/{id}", h.authMiddleware(scopeContainersWrite, h.expensive(h.DeleteContainer)))
+	h.route("POST /containers/{id}/stop", h.authMiddleware(scopeContainersWrite, h.StopContainer))
+	h.route("POST /containers/{id}/start", h.authMiddleware(scopeContainersWrite, h.StartContainer))
+	h.route("POST /containers/{id}/extend", h.authMiddleware(scopeContainersWrite, h.ExtendContainer))
+	h.route("PUT /containers/{id}/idle-timeout", h.authMiddleware(scopeContainersWrite, h.UpdateContainerIdleTimeout))
+
+	// Schedule endpoints
+	h.route("GET /containers/{id}/schedules", h.authMiddleware(scopeContainersRead, h.ListSchedules))
+	h.route("POST /containers/{id}/schedules", h.authMiddleware(scopeContainersWrite, h.CreateSchedule))
+	h.route("PUT /containers/{id}/schedules/{scheduleId}", h.authMiddleware(scopeContainersWrite, h.UpdateSchedule))
+	h.route("DELETE /containers/{id}/schedules/{scheduleId}", h.authMiddleware(scopeContainersWrite, h.DeleteSchedule))
+	h.route("GET /containers/{id}/disk-usage", h.authMiddleware(scopeContainersRead, h.GetContainerDiskUsage))
+	h.route("GET /containers/{id}/events", h.authMiddleware(scopeContainersRead, h.ListContainerEvents))
+
+	// Sharing containers with other users
+	h.route("GET /containers/{id}/grants", h.authMiddleware(scopeContainersRead, h.ListContainerGrants))
+	h.route("PUT /containers/{id}/grants/{username}", h.authMiddleware(scopeContainersWrite, h.GrantContainerAccess))
+	h.route("DELETE /containers/{id}/grants/{username}", h.authMiddleware(scopeContainersWrite, h.RevokeContainerAccess))
+	h.route("GET /shared-containers", h.authMiddleware(scopeContainersRead, h.ListSharedContainers))
+
+	// Event endpoints
+	h.route("GET /events/stream", h.authMiddleware(scopeContainersRead, h.StreamEvents))
+
+	// Webhook endpoints
+	h.route("GET /webhooks", h.authMiddleware(scopeWebhooksManage, h.ListWebhooks))
+	h.route("POST /webhooks", h.authMiddleware(scopeWebhooksManage, h.CreateWebhook))
+	h.route("DELETE /webhooks/{id}", h.authMiddleware(scopeWebhooksManage, h.DeleteWebhook))
+	h.route("GET /webhooks/{id}/deliveries", h.authMiddleware(scopeWebhooksManage, h.ListWebhookDeliveries))
+	h.route("POST /webhooks/{id}/deliveries/{deliveryId}/redeliver", h.authMiddleware(scopeWebhooksManage, h.RedeliverWebhook))
+
+	// Operation endpoints
+	h.route("GET /operations", h.authMiddleware(scopeContainersRead, h.ListOperations))
+	h.route("GET /operations/{id}", h.authMiddleware(scopeContainersRead, h.GetOperation))
+
+	// Settings endpoints
+	h.route("GET /settings", h.authMiddleware(scopeSettingsRead, h.GetSettings))
+	h.route("PUT /settings", h.authMiddleware(scopeSettingsWrite, h.UpdateSettings))
+
+	// SSO identity endpoints
+	h.route("GET /identities", h.authMiddleware(scopeSettingsRead, h.ListIdentities))
+	h.route("POST /identities", h.authMiddleware(scopeSettingsWrite, h.LinkIdentity))
 
 	// SSH key endpoints
-	h.mux.HandleFunc("GET /compute/ssh-keys", h.authMiddleware(h.ListSSHKeys))
-	h.mux.HandleFunc("POST /compute/ssh-keys", h.authMiddleware(h.AddSSHKey))
-	h.mux.HandleFunc("DELETE /compute/ssh-keys/{id}", h.authMiddleware(h.DeleteSSHKey))
+	h.route("GET /ssh-keys", h.authMiddleware(scopeSSHKeysRead, h.ListSSHKeys))
+	h.route("POST /ssh-keys", h.authMiddleware(scopeSSHKeysWrite, h.idempotent(h.AddSSHKey)))
+	h.route("DELETE /ssh-keys/{id}", h.authMiddleware(scopeSSHKeysWrite, h.DeleteSSHKey))
 
 	// API key endpoints
-	h.mux.HandleFunc("GET /compute/api-keys", h.authMiddleware(h.ListAPIKeys))
-	h.mux.HandleFunc("POST /compute/api-keys", h.authMiddleware(h.CreateAPIKey))
-	h.mux.HandleFunc("DELETE /compute/api-keys/{id}", h.authMiddleware(h.DeleteAPIKey))
+	h.route("GET /api-keys", h.authMiddleware(scopeAPIKeysManage, h.ListAPIKeys))
+	h.route("POST /api-keys", h.authMiddleware(scopeAPIKeysManage, h.idempotentSecret(h.CreateAPIKey)))
+	h.route("DELETE /api-keys/{id}", h.authMiddleware(scopeAPIKeysManage, h.DeleteAPIKey))
+	h.route("POST /api-keys/{id}/rotate", h.authMiddleware(scopeAPIKeysManage, h.idempotentSecret(h.RotateAPIKey)))
 
+	// Orgs
+	h.route("GET /orgs", h.authMiddleware(scopeOrgsRead, h.ListOrgs))
+	h.route("POST /orgs", h.authMiddleware(scopeOrgsWrite, h.CreateOrg))
+	h.route("GET /orgs/{id}/members", h.authMiddleware(scopeOrgsRead, h.ListOrgMembers))
+	h.route("PUT /orgs/{id}/members/{username}", h.authMiddleware(scopeOrgsWrite, h.SetOrgMember))
+	h.route("DELETE /orgs/{id}/members/{username}", h.authMiddleware(scopeOrgsWrite, h.RemoveOrgMember))
+
+	// Audit log endpoints
+	h.route("GET /audit", h.authMiddleware(scopeAuditRead, h.ListAuditLog))
+	h.route("GET /admin/audit", h.authMiddleware(scopeAuditRead, h.ListAllAuditLog))
+
+	// The spec is generated from apiOperations; TestSpecCoverage keeps the two in
+	// step, so a failure here only leaves /openapi.json unavailable
+	spec, err := buildOpenAPISpec()
+	if err != nil {
+		slog.Error("failed to build openapi spec", "error", err)
+	}
+	h.spec = spec
+
+	h.root = requestID(jsonMuxErrors(h.mux))
 	return h
 }
 
+// handle registers a route exactly as given and records it for TestSpecCoverage
+func (h *Handler) handle(pattern string, fn http.HandlerFunc) {
+	h.mux.HandleFunc(pattern, fn)
+	h.routes = append(h.routes, pattern)
+}
+
+// route registers an API route, given relative to the version prefix (e.g.
+// "GET /containers"), under every API version and at its deprecated unversioned
+// path. Only the versioned routes are documented. Requests to anything but GET
+// routes are recorded in the audit log.
+func (h *Handler) route(pattern string, fn http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	if method != http.MethodGet {
+		fn = h.audit(pattern, fn)
+	}
+	fn = withRoute(pattern, fn)
+	for _, v := range apiVersions {
+		h.handle(method+" "+v.prefix+path, withVersion(v.version, fn))
+	}
+	h.mux.HandleFunc(method+" "+unversionedPrefix+path, deprecatedPath(withVersion(unversionedAPI, fn)))
+}
+
+// withRoute records the route pattern a request matched, relative to the version
+// prefix, so it reads the same whichever path the route was reached through
+func withRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), routeContextKey, pattern)))
+	}
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.mux.ServeHTTP(w, r)
+	h.root.ServeHTTP(w, r)
 }
 
 func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
@@ -59,57 +207,83 @@ func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("ok"))
 }
 
-// authMiddleware validates session or API key and injects user info into context
-func (h *Handler) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Try session cookie first
-		token := auth.GetSessionToken(r)
-		if token != "" {
-			username, err := h.validator.ValidateSession(token)
-			if err != nil {
-				slog.Error("session validation failed", "error", err)
-				http.Error(w, "authentication error", http.StatusInternalServerError)
-				return
-			}
-			if username != "" {
-				// For now, use username as user ID (simplified)
-				// In production, would lookup user ID from username
-				r = r.WithContext(setUserContext(r.Context(), 1, username))
-				next(w, r)
-				return
-			}
-		}
+// writeJSON writes data in the shape of the API version the request was made against
+func writeJSON(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(shapeForVersion(data, responseVersion(w))); err != nil {
+		slog.Error("failed to encode json response", "error", err)
+	}
+}
 
-		// Try API key
-		apiKey := auth.GetAPIKeyFromRequest(r)
-		if apiKey != "" {
-			keyHash := auth.HashAPIKey(apiKey)
-			key, err := h.db.GetAPIKeyByHash(keyHash)
-			if err != nil {
-				slog.Error("api key lookup failed", "error", err)
-				http.Error(w, "authentication error", http.StatusInternalServerError)
-				return
-			}
-			if key != nil {
-				// Update last used
-				_ = h.db.UpdateAPIKeyLastUsed(key.ID)
-				r = r.WithContext(setUserContext(r.Context(), key.UserID, ""))
-				next(w, r)
-				return
+// parseLimit reads the optional "limit" query parameter, bounded to [1, max]
+func parseLimit(r *http.Request, def, max int) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, nil
+}
+
+// parseListOptions reads the paging, sorting and filtering query parameters shared by list endpoints:
+// limit, cursor, sort (created_at or name), order (asc or desc), name_prefix, created_before and
+// created_after. Results are newest first by default, or A-Z when sorted by name.
+func parseListOptions(r *http.Request, def, max int) (db.ListOptions, error) {
+	q := r.URL.Query()
+
+	limit, err := parseLimit(r, def, max)
+	if err != nil {
+		return db.ListOptions{}, err
+	}
+	opts := db.ListOptions{
+		Limit:      limit,
+		Sort:       q.Get("sort"),
+		NamePrefix: q.Get("name_prefix"),
+	}
+
+	switch q.Get("order") {
+	case "":
+		opts.Ascending = opts.Sort == db.SortName
+	case "asc":
+		opts.Ascending = true
+	case "desc":
+	default:
+		return db.ListOptions{}, fmt.Errorf("order must be \"asc\" or \"desc\"")
+	}
+
+	for param, t := range map[string]*time.Time{
+		"created_before": &opts.CreatedBefore,
+		"created_after":  &opts.CreatedAfter,
+	} {
+		if s := q.Get(param); s != "" {
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				return db.ListOptions{}, fmt.Errorf("%s must be an RFC 3339 time", param)
 			}
 		}
+	}
 
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	if s := q.Get("cursor"); s != "" {
+		if opts.Cursor, err = db.ParseCursor(s); err != nil {
+			return db.ListOptions{}, err
+		}
 	}
-}
 
-func writeJSON(w http.ResponseWriter, data any) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		slog.Error("failed to encode json response", "error", err)
+	if err := opts.Validate(); err != nil {
+		return db.ListOptions{}, err
 	}
+	return opts, nil
 }
 
-func writeError(w http.ResponseWriter, message string, code int) {
-	http.Error(w, message, code)
+// setNextCursor tells the client where the next page starts, if there is one.
+// It goes in a header so list bodies stay plain arrays.
+func setNextCursor(w http.ResponseWriter, next *db.Cursor) {
+	if next != nil {
+		w.Header().Set("X-Next-Cursor", next.String())
+	}
 }
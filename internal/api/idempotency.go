@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+const (
+	idempotencyKeyRetention = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBodyBytes  = 1 << 20
+
+	// A key whose first request hasn't finished after this long is assumed abandoned,
+	// e.g. because the service restarted mid-request
+	idempotencyAbandonAfter = time.Minute
+)
+
+// idempotent lets clients safely retry a create request by sending an Idempotency-Key
+// header. The first request's response is stored and replayed for retries with the
+// same key; reusing a key for a different request is rejected with 422.
+// Must run inside authMiddleware since keys are scoped to the user.
+func (h *Handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return h.idempotency(next, false)
+}
+
+// idempotentSecret is idempotent for routes whose successful responses carry a
+// secret, such as a new API key. Those responses aren't stored, so a retry after
+// success gets a 409 instead of the secret again; the client has to look the
+// resource up instead.
+func (h *Handler) idempotentSecret(next http.HandlerFunc) http.HandlerFunc {
+	return h.idempotency(next, true)
+}
+
+func (h *Handler) idempotency(next http.HandlerFunc, secret bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		userID, _, ok := getUserFromContext(r.Context())
+		if !ok {
+			writeError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			writeError(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// The same key on another endpoint counts as a different request
+		sum := sha256.New()
+		io.WriteString(sum, requestRoute(r)+"\n")
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		now := time.Now()
+		existing, err := h.db.ReserveIdempotencyKey(userID, key, fingerprint,
+			now.Add(-idempotencyKeyRetention), now.Add(-idempotencyAbandonAfter))
+		if err != nil {
+			slog.Error("failed to reserve idempotency key", "error", err)
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				writeErrorCode(w, http.StatusUnprocessableEntity, codeIdempotencyReuse, "Idempotency-Key was already used for a different request", nil)
+			case !existing.StatusCode.Valid:
+				writeErrorCode(w, http.StatusConflict, codeIdempotencyBusy, "a request with this Idempotency-Key is still in progress", nil)
+			case secret && existing.StatusCode.Int64 < 300:
+				if existing.Location != "" {
+					w.Header().Set("Location", existing.Location)
+				}
+				writeErrorCode(w, http.StatusConflict, codeIdempotencyDone, "a request with this Idempotency-Key already succeeded; its response held a secret, so it isn't kept to replay", nil)
+			default:
+				replayResponse(w, existing)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// Server errors aren't stored so the client can retry them for real
+		if rec.status >= 500 {
+			if err := h.db.ReleaseIdempotencyKey(userID, key); err != nil {
+				slog.Error("failed to release idempotency key", "error", err)
+			}
+			return
+		}
+
+		stored := &db.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			StatusCode:  sql.NullInt64{Int64: int64(rec.status), Valid: true},
+			ContentType: rec.Header().Get("Content-Type"),
+			Location:    rec.Header().Get("Location"),
+			Body:        rec.body.Bytes(),
+		}
+		if secret && rec.status < 300 {
+			stored.Body = nil
+		}
+		if err := h.db.CompleteIdempotencyKey(stored); err != nil {
+			slog.Error("failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// requestRoute identifies the route a request was made to along with its path
+// values, e.g. "POST /api-keys/12/rotate", the same for the versioned path and
+// its unversioned alias
+func requestRoute(r *http.Request) string {
+	pattern, ok := r.Context().Value(routeContextKey).(string)
+	if !ok {
+		return r.Method + " " + r.URL.Path
+	}
+	method, path, _ := strings.Cut(pattern, " ")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			name = strings.TrimSuffix(strings.TrimSuffix(name, "}"), "...")
+			segments[i] = url.PathEscape(r.PathValue(name))
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
+
+func replayResponse(w http.ResponseWriter, k *db.IdempotencyKey) {
+	if k.ContentType != "" {
+		w.Header().Set("Content-Type", k.ContentType)
+	}
+	if k.Location != "" {
+		w.Header().Set("Location", k.Location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(k.StatusCode.Int64))
+	w.Write(k.Body)
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RunIdempotencyCleanup prunes expired idempotency keys until ctx is cancelled
+func (h *Handler) RunIdempotencyCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.db.DeleteIdempotencyKeysBefore(time.Now().Add(-idempotencyKeyRetention)); err != nil {
+				slog.Error("failed to prune idempotency keys", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/auth"
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+type identityLinkRequest struct {
+	// Token is a JWT from the SSO provider for the identity to link
+	Token string `json:"token" validate:"required"`
+}
+
+type identityResponse struct {
+	Issuer    string `json:"issuer"`
+	Subject   string `json:"subject"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ListIdentities returns the SSO identities linked to the caller
+func (h *Handler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	identities, err := h.db.ListUserIdentities(userID)
+	if err != nil {
+		slog.Error("failed to list identities", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]identityResponse, 0, len(identities))
+	for _, i := range identities {
+		resp = append(resp, identityToResponse(i))
+	}
+	writeJSON(w, resp)
+}
+
+// LinkIdentity lets SSO tokens for an identity act as the caller. Only a web
+// session can link, since it's what proves the caller is the SFS user; the
+// token proves they hold the identity.
+func (h *Handler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user := userFromContext(r.Context())
+	if user == nil {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if user.AuthMethod != authSession {
+		writeErrorCode(w, http.StatusForbidden, codeForbidden, "identities can only be linked from a signed-in web session", nil)
+		return
+	}
+	if h.oidc == nil {
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, "SSO tokens aren't accepted by this server", nil)
+		return
+	}
+
+	var req identityLinkRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	claims, err := h.oidc.Verify(r.Context(), req.Token)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		slog.Info("rejected identity token", "reason", err)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, "invalid token", map[string]any{"field": "token"})
+		return
+	}
+	if err != nil {
+		slog.Error("failed to verify identity token", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.LinkIdentity(claims.Issuer, claims.Subject, user.UserID); err != nil {
+		if errors.Is(err, db.ErrConflict) {
+			writeErrorCode(w, http.StatusConflict, codeConflict, "identity is linked to another user", nil)
+			return
+		}
+		slog.Error("failed to link identity", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, identityToResponse(&db.UserIdentity{
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		UserID:    user.UserID,
+		CreatedAt: time.Now().UTC(),
+	}))
+}
+
+func identityToResponse(i *db.UserIdentity) identityResponse {
+	return identityResponse{
+		Issuer:    i.Issuer,
+		Subject:   i.Subject,
+		CreatedAt: i.CreatedAt.Format(time.RFC3339),
+	}
+}
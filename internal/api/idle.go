@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+	"eddisonso.com/edd-compute/internal/k8s"
+)
+
+// IdleConfig controls when a running container is considered idle
+type IdleConfig struct {
+	// DefaultTimeout applies to users and containers without their own setting; 0 disables
+	DefaultTimeout time.Duration
+	// CPUMillicores is the CPU usage below which a container counts as idle
+	CPUMillicores uint64
+	// NetworkBytesPerSec is the traffic rate below which a container counts as idle.
+	// Sessions themselves aren't visible to the kubelet, so an open but quiet SSH
+	// session doesn't keep a container running; only its traffic or CPU does.
+	NetworkBytesPerSec uint64
+}
+
+type idleTimeoutRequest struct {
+	IdleTimeoutMinutes *int64 `json:"idle_timeout_minutes" validate:"min=0,max=10080"`
+}
+
+type settingsResponse struct {
+	IdleTimeoutMinutes *int64 `json:"idle_timeout_minutes"`
+}
+
+func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.db.GetUserSettings(userID)
+	if err != nil {
+		slog.Error("failed to get user settings", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, settingsToResponse(settings))
+}
+
+func (h *Handler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req idleTimeoutRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	settings := &db.UserSettings{UserID: userID, IdleTimeoutMinutes: nullInt64(req.IdleTimeoutMinutes)}
+	if err := h.db.UpsertUserSettings(settings); err != nil {
+		slog.Error("failed to update user settings", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, settingsToResponse(settings))
+}
+
+func (h *Handler) UpdateContainerIdleTimeout(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permManage)
+	if !ok {
+		return
+	}
+
+	var req idleTimeoutRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	timeout := nullInt64(req.IdleTimeoutMinutes)
+	if err := h.db.UpdateContainerIdleTimeout(container.ID, timeout); err != nil {
+		slog.Error("failed to update container idle timeout", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	container.IdleTimeoutMinutes = timeout
+	writeJSON(w, containerToResponse(container))
+}
+
+// nullInt64 converts an optional request value for storage.
+// For idle timeouts null means "inherit" and 0 means "never".
+func nullInt64(n *int64) sql.NullInt64 {
+	if n == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *n, Valid: true}
+}
+
+func settingsToResponse(s *db.UserSettings) settingsResponse {
+	var resp settingsResponse
+	if s.IdleTimeoutMinutes.Valid {
+		resp.IdleTimeoutMinutes = &s.IdleTimeoutMinutes.Int64
+	}
+	return resp
+}
+
+// idleSample is the last observation of a container, used to compute traffic
+// rates and how long it has been idle
+type idleSample struct {
+	at        time.Time
+	netBytes  uint64
+	idleSince time.Time
+}
+
+// RunIdleMonitor stops running containers that have been idle for longer than
+// their idle timeout until ctx is cancelled
+func (h *Handler) RunIdleMonitor(ctx context.Context, interval time.Duration, cfg IdleConfig) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	samples := make(map[string]*idleSample)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.checkIdle(ctx, cfg, samples)
+		}
+	}
+}
+
+func (h *Handler) checkIdle(ctx context.Context, cfg IdleConfig, samples map[string]*idleSample) {
+	containers, err := h.db.ListContainersByStatus(db.StatusRunning)
+	if err != nil {
+		slog.Error("failed to list running containers", "error", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(containers))
+	userTimeouts := make(map[int64]time.Duration)
+	for _, c := range containers {
+		if ctx.Err() != nil {
+			return
+		}
+		seen[c.ID] = true
+
+		var idleTimeout time.Duration
+		if c.IdleTimeoutMinutes.Valid {
+			idleTimeout = time.Duration(c.IdleTimeoutMinutes.Int64) * time.Minute
+		} else {
+			t, cached := userTimeouts[c.UserID]
+			if !cached {
+				t = h.userIdleTimeout(c.UserID, cfg.DefaultTimeout)
+				userTimeouts[c.UserID] = t
+			}
+			idleTimeout = t
+		}
+		if idleTimeout <= 0 {
+			delete(samples, c.ID)
+			continue
+		}
+
+		idleFor, err := h.observeIdle(ctx, c, cfg, samples)
+		if err != nil {
+			if errors.Is(err, k8s.ErrStatsForbidden) {
+				// Every container would fail the same way
+				slog.Error("failed to check container activity", "error", err)
+				return
+			}
+			slog.Error("failed to check container activity", "container", c.ID, "error", err)
+			continue
+		}
+		if idleFor < idleTimeout {
+			continue
+		}
+
+		if err := h.stopIdleContainer(ctx, c, idleFor); err != nil {
+			slog.Error("failed to stop idle container", "container", c.ID, "error", err)
+			continue
+		}
+		delete(samples, c.ID)
+	}
+
+	// Forget containers that stopped or were deleted since the last check
+	for id := range samples {
+		if !seen[id] {
+			delete(samples, id)
+		}
+	}
+}
+
+func (h *Handler) userIdleTimeout(userID int64, def time.Duration) time.Duration {
+	settings, err := h.db.GetUserSettings(userID)
+	if err != nil {
+		slog.Error("failed to get user settings", "user", userID, "error", err)
+		return 0
+	}
+	if settings.IdleTimeoutMinutes.Valid {
+		return time.Duration(settings.IdleTimeoutMinutes.Int64) * time.Minute
+	}
+	return def
+}
+
+// observeIdle samples a container and returns how long it has been continuously idle
+func (h *Handler) observeIdle(ctx context.Context, c *db.Container, cfg IdleConfig, samples map[string]*idleSample) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	stats, err := h.k8s.GetPodStats(ctx, c.Namespace)
+	if err != nil {
+		return 0, err
+	}
+	if stats == nil {
+		delete(samples, c.ID)
+		return 0, nil
+	}
+
+	now := time.Now()
+	netBytes := stats.NetworkRxBytes + stats.NetworkTxBytes
+	prev, ok := samples[c.ID]
+	samples[c.ID] = &idleSample{at: now, netBytes: netBytes}
+	if !ok {
+		// Need two samples to compute a traffic rate
+		return 0, nil
+	}
+
+	var rate uint64
+	if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 && netBytes >= prev.netBytes {
+		rate = uint64(float64(netBytes-prev.netBytes) / elapsed)
+	}
+	millicores := stats.CPUNanoCores / 1_000_000
+
+	if millicores >= cfg.CPUMillicores || rate >= cfg.NetworkBytesPerSec {
+		return 0, nil
+	}
+
+	idleSince := prev.idleSince
+	if idleSince.IsZero() {
+		idleSince = prev.at
+	}
+	samples[c.ID].idleSince = idleSince
+	return now.Sub(idleSince), nil
+}
+
+func (h *Handler) stopIdleContainer(ctx context.Context, c *db.Container, idleFor time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := h.stopContainer(ctx, c); err != nil {
+		return err
+	}
+
+	h.recordEvent(c, db.EventContainerIdle,
+		fmt.Sprintf("container stopped after %s with low CPU and network traffic", idleFor.Round(time.Minute)))
+	return nil
+}
@@ -0,0 +1,403 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// apiOperation documents one route for the OpenAPI specification. Every route
+// registered in NewHandler must have an entry here and vice versa, which
+// TestSpecCoverage checks.
+type apiOperation struct {
+	Pattern     string // Same as the ServeMux pattern, e.g. "GET /compute/v1/containers/{id}"
+	Summary     string
+	Tag         string
+	Public      bool     // No authentication required
+	Request     any      // Zero value of the request body type, if any
+	Response    any      // Zero value of the response body type, if any
+	List        bool     // Response is an array of Response
+	Status      int      // Success status, 200 if unset
+	Query       []string // Query parameters, see queryParams
+	Idempotent  bool     // Accepts an Idempotency-Key header
+	ContentType string   // Response content type, application/json if unset
+}
+
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+var (
+	listQuery      = []string{"limit", "cursor", "sort", "order", "name_prefix", "created_before", "created_after"}
+	containerQuery = append(append([]string(nil), listQuery...), "status", "image", "org_id")
+	ownedListQuery = append(append([]string(nil), listQuery...), "org_id")
+	sharedQuery    = append(append([]string(nil), listQuery...), "status", "image")
+	auditQuery     = []string{"limit", "cursor", "action", "target_type", "target_id"}
+)
+
+var apiOperations = []apiOperation{
+	{Pattern: "GET /healthz", Summary: "Liveness probe", Tag: "health", Public: true, ContentType: "text/plain"},
+	{Pattern: "GET /compute/healthz", Summary: "Liveness probe through the ingress", Tag: "health", Public: true, ContentType: "text/plain"},
+	{Pattern: "GET /compute/v1/openapi.json", Summary: "This OpenAPI document", Tag: "meta", Public: true},
+
+	{Pattern: "GET /compute/v1/containers", Summary: "List containers", Tag: "containers", Response: containerResponse{}, List: true, Query: containerQuery},
+	{Pattern: "POST /compute/v1/containers", Summary: "Create a container", Tag: "containers", Request: containerRequest{}, Response: operationResponse{}, Status: http.StatusAccepted, Idempotent: true},
+	{Pattern: "GET /compute/v1/containers/{id}", Summary: "Get a container", Tag: "containers", Response: containerResponse{}},
+	{Pattern: "DELETE /compute/v1/containers/{id}", Summary: "Delete a container", Tag: "containers", Response: operationResponse{}, Status: http.StatusAccepted},
+	{Pattern: "POST /compute/v1/containers/{id}/stop", Summary: "Stop a container", Tag: "containers", Response: operationResponse{}, Status: http.StatusAccepted},
+	{Pattern: "POST /compute/v1/containers/{id}/start", Summary: "Start a container", Tag: "containers", Response: operationResponse{}, Status: http.StatusAccepted},
+	{Pattern: "POST /compute/v1/containers/{id}/extend", Summary: "Extend or set a container's expiry", Tag: "containers", Request: extendRequest{}, Response: containerResponse{}},
+	{Pattern: "PUT /compute/v1/containers/{id}/idle-timeout", Summary: "Set a container's idle timeout", Tag: "containers", Request: idleTimeoutRequest{}, Response: containerResponse{}},
+	{Pattern: "GET /compute/v1/containers/{id}/disk-usage", Summary: "Disk usage history", Tag: "containers", Response: diskUsageResponse{}, List: true, Query: []string{"limit"}},
+	{Pattern: "GET /compute/v1/containers/{id}/events", Summary: "Container lifecycle events", Tag: "events", Response: eventResponse{}, List: true, Query: []string{"limit"}},
+
+	{Pattern: "GET /compute/v1/containers/{id}/grants", Summary: "List who a container is shared with", Tag: "containers", Response: grantResponse{}, List: true},
+	{Pattern: "PUT /compute/v1/containers/{id}/grants/{username}", Summary: "Share a container with a user, adding their SSH keys", Tag: "containers", Response: grantResponse{}},
+	{Pattern: "DELETE /compute/v1/containers/{id}/grants/{username}", Summary: "Stop sharing a container with a user", Tag: "containers", Response: statusResponse{}},
+	{Pattern: "GET /compute/v1/shared-containers", Summary: "List containers shared with you", Tag: "containers", Response: sharedContainerResponse{}, List: true, Query: sharedQuery},
+
+	{Pattern: "GET /compute/v1/containers/{id}/schedules", Summary: "List schedules", Tag: "schedules", Response: scheduleResponse{}, List: true},
+	{Pattern: "POST /compute/v1/containers/{id}/schedules", Summary: "Create a schedule", Tag: "schedules", Request: scheduleRequest{}, Response: scheduleResponse{}},
+	{Pattern: "PUT /compute/v1/containers/{id}/schedules/{scheduleId}", Summary: "Update a schedule", Tag: "schedules", Request: scheduleRequest{}, Response: scheduleResponse{}},
+	{Pattern: "DELETE /compute/v1/containers/{id}/schedules/{scheduleId}", Summary: "Delete a schedule", Tag: "schedules", Response: statusResponse{}},
+
+	{Pattern: "GET /compute/v1/events/stream", Summary: "Stream events as Server-Sent Events", Tag: "events", Query: []string{"last_event_id", "org_id"}, ContentType: "text/event-stream"},
+
+	{Pattern: "GET /compute/v1/webhooks", Summary: "List webhooks", Tag: "webhooks", Response: webhookResponse{}, List: true},
+	{Pattern: "POST /compute/v1/webhooks", Summary: "Register a webhook", Tag: "webhooks", Request: webhookRequest{}, Response: webhookResponse{}},
+	{Pattern: "DELETE /compute/v1/webhooks/{id}", Summary: "Delete a webhook", Tag: "webhooks", Response: statusResponse{}},
+	{Pattern: "GET /compute/v1/webhooks/{id}/deliveries", Summary: "Webhook delivery log", Tag: "webhooks", Response: deliveryResponse{}, List: true, Query: []string{"limit"}},
+	{Pattern: "POST /compute/v1/webhooks/{id}/deliveries/{deliveryId}/redeliver", Summary: "Redeliver a webhook delivery", Tag: "webhooks", Response: deliveryResponse{}},
+
+	{Pattern: "GET /compute/v1/operations", Summary: "List operations", Tag: "operations", Response: operationResponse{}, List: true, Query: []string{"limit", "org_id"}},
+	{Pattern: "GET /compute/v1/operations/{id}", Summary: "Get an operation", Tag: "operations", Response: operationResponse{}, Query: []string{"wait"}},
+
+	{Pattern: "GET /compute/v1/settings", Summary: "Get account settings", Tag: "settings", Response: settingsResponse{}},
+	{Pattern: "PUT /compute/v1/settings", Summary: "Update account settings", Tag: "settings", Request: idleTimeoutRequest{}, Response: settingsResponse{}},
+
+	{Pattern: "GET /compute/v1/identities", Summary: "List the SSO identities linked to your account", Tag: "settings", Response: identityResponse{}, List: true},
+	{Pattern: "POST /compute/v1/identities", Summary: "Link an SSO identity to your account, from a web session", Tag: "settings", Request: identityLinkRequest{}, Response: identityResponse{}},
+
+	{Pattern: "GET /compute/v1/ssh-keys", Summary: "List SSH keys", Tag: "ssh-keys", Response: sshKeyResponse{}, List: true, Query: ownedListQuery},
+	{Pattern: "POST /compute/v1/ssh-keys", Summary: "Add an SSH key", Tag: "ssh-keys", Request: sshKeyRequest{}, Response: sshKeyResponse{}, Idempotent: true},
+	{Pattern: "DELETE /compute/v1/ssh-keys/{id}", Summary: "Delete an SSH key", Tag: "ssh-keys", Response: statusResponse{}},
+
+	{Pattern: "GET /compute/v1/api-keys", Summary: "List API keys", Tag: "api-keys", Response: apiKeyResponse{}, List: true, Query: ownedListQuery},
+	{Pattern: "POST /compute/v1/api-keys", Summary: "Create an API key", Tag: "api-keys", Request: apiKeyRequest{}, Response: apiKeyResponse{}, Idempotent: true},
+	{Pattern: "DELETE /compute/v1/api-keys/{id}", Summary: "Revoke an API key", Tag: "api-keys", Response: statusResponse{}, Query: []string{"reason", "org_id"}},
+	{Pattern: "POST /compute/v1/api-keys/{id}/rotate", Summary: "Replace an API key, keeping the old one valid for a grace period", Tag: "api-keys", Request: apiKeyRotateRequest{}, Response: apiKeyResponse{}, Idempotent: true, Query: []string{"org_id"}},
+
+	{Pattern: "GET /compute/v1/orgs", Summary: "List your orgs", Tag: "orgs", Response: orgResponse{}, List: true},
+	{Pattern: "POST /compute/v1/orgs", Summary: "Create an org", Tag: "orgs", Request: orgRequest{}, Response: orgResponse{}},
+	{Pattern: "GET /compute/v1/orgs/{id}/members", Summary: "List an org's members", Tag: "orgs", Response: orgMemberResponse{}, List: true},
+	{Pattern: "PUT /compute/v1/orgs/{id}/members/{username}", Summary: "Add an org member or change their role", Tag: "orgs", Request: orgMemberRequest{}, Response: orgMemberResponse{}},
+	{Pattern: "DELETE /compute/v1/orgs/{id}/members/{username}", Summary: "Remove an org member, deleting their org SSH keys and revoking their org API keys", Tag: "orgs", Response: statusResponse{}},
+
+	{Pattern: "GET /compute/v1/audit", Summary: "Your audit log of mutating requests", Tag: "audit", Response: auditResponse{}, List: true, Query: auditQuery},
+	{Pattern: "GET /compute/v1/admin/audit", Summary: "Every user's audit log; admins only", Tag: "audit", Response: auditResponse{}, List: true, Query: append(append([]string(nil), auditQuery...), "user")},
+}
+
+// queryParams documents the query parameters operations can list in Query
+var queryParams = map[string]map[string]any{
+	"limit":          {"description": "Maximum number of results", "schema": map[string]any{"type": "integer", "minimum": 1}},
+	"cursor":         {"description": "X-Next-Cursor from the previous page", "schema": map[string]any{"type": "string"}},
+	"sort":           {"description": "Field to sort by", "schema": map[string]any{"type": "string", "enum": []string{"created_at", "name"}}},
+	"order":          {"description": "Sort direction; newest first by default, or A-Z when sorting by name", "schema": map[string]any{"type": "string", "enum": []string{"asc", "desc"}}},
+	"name_prefix":    {"description": "Only names starting with this prefix", "schema": map[string]any{"type": "string"}},
+	"created_before": {"description": "Only resources created before this time", "schema": map[string]any{"type": "string", "format": "date-time"}},
+	"created_after":  {"description": "Only resources created after this time", "schema": map[string]any{"type": "string", "format": "date-time"}},
+	"status":         {"description": "Only containers in this state", "schema": map[string]any{"type": "string"}},
+	"image":          {"description": "Only containers running this image", "schema": map[string]any{"type": "string"}},
+	"last_event_id":  {"description": "Resume after this event; same as the Last-Event-ID header", "schema": map[string]any{"type": "integer"}},
+	"reason":         {"description": "Why the key is being revoked, kept with the key", "schema": map[string]any{"type": "string", "maxLength": maxRevokeReasonLen}},
+	"action":         {"description": "Only entries for this route, e.g. \"DELETE /containers/{id}\"", "schema": map[string]any{"type": "string"}},
+	"target_type":    {"description": "Only entries acting on this kind of resource, e.g. containers", "schema": map[string]any{"type": "string"}},
+	"target_id":      {"description": "Only entries acting on this resource", "schema": map[string]any{"type": "string"}},
+	"user":           {"description": "Only entries for this username", "schema": map[string]any{"type": "string"}},
+	"org_id":         {"description": "The org whose resources to use instead of your own", "schema": map[string]any{"type": "integer"}},
+	"wait":           {"description": "Wait up to this long (e.g. 30s, max 60s) for the operation to finish", "schema": map[string]any{"type": "string"}},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// buildOpenAPISpec renders apiOperations as an OpenAPI 3 document
+func buildOpenAPISpec() ([]byte, error) {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+
+	schemas["Error"] = schemaFor(reflect.TypeOf(errorResponse{}), schemas)
+	errorRef := map[string]any{
+		"description": "Error",
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+
+	for _, op := range apiOperations {
+		method, path, _ := strings.Cut(op.Pattern, " ")
+
+		var params []any
+		for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, name := range op.Query {
+			p, ok := queryParams[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: undocumented query parameter %q", op.Pattern, name)
+			}
+			param := map[string]any{"name": name, "in": "query"}
+			for k, v := range p {
+				param[k] = v
+			}
+			params = append(params, param)
+		}
+		if op.Idempotent {
+			params = append(params, map[string]any{
+				"name": "Idempotency-Key", "in": "header",
+				"description": "Retries with the same key replay the first response instead of creating another resource. Successful responses that include a secret aren't kept, so retrying one returns 409 idempotency_key_completed",
+				"schema":      map[string]any{"type": "string", "maxLength": maxIdempotencyKeyLength},
+			})
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		switch {
+		case op.Response != nil:
+			schema := schemaFor(reflect.TypeOf(op.Response), schemas)
+			if op.List {
+				schema = map[string]any{"type": "array", "items": schema}
+			}
+			success["content"] = map[string]any{contentType: map[string]any{"schema": schema}}
+		case op.ContentType != "":
+			success["content"] = map[string]any{contentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		if op.List && containsString(op.Query, "cursor") {
+			success["headers"] = map[string]any{
+				"X-Next-Cursor": map[string]any{
+					"description": "Pass as cursor to get the next page; absent on the last page",
+					"schema":      map[string]any{"type": "string"},
+				},
+			}
+		}
+
+		operation := map[string]any{
+			"operationId": operationID(method, path),
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"responses": map[string]any{
+				strconv.Itoa(status): success,
+				"default":            errorRef,
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(op.Request), schemas)},
+				},
+			}
+		}
+		if op.Public {
+			operation["security"] = []any{}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = operation
+	}
+
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "edd-compute",
+			"version": "1",
+			"description": "Every path is also served without the /v1 segment (e.g. /compute/containers) for clients " +
+				"that predate versioning. Those aliases are deprecated: responses carry Deprecation, Sunset and " +
+				"Link: rel=\"successor-version\" headers.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": "sfs_session"},
+				"apiKey":  map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{
+			map[string]any{"session": []string{}},
+			map[string]any{"apiKey": []string{}},
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// schemaFor returns a JSON schema for t, registering named struct types in schemas
+// and referring to them by $ref
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	nullable := false
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	var schema map[string]any
+	switch {
+	case t == reflect.TypeOf(json.RawMessage{}):
+		schema = map[string]any{}
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // Placeholder so recursive types terminate
+			schemas[name] = structSchema(t, schemas)
+		}
+		schema = map[string]any{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.String:
+		schema = map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema = map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema = map[string]any{"type": "number"}
+	case t.Kind() == reflect.Slice:
+		schema = map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		schema = map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Interface:
+		schema = map[string]any{}
+	default:
+		panic(fmt.Sprintf("no schema for %s", t))
+	}
+
+	if nullable {
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+	}
+	return schema
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous {
+				addFields(f.Type)
+				continue
+			}
+			if !f.IsExported() || f.Tag.Get("json") == "-" {
+				continue
+			}
+
+			name := jsonName(f)
+			prop := schemaFor(f.Type, schemas)
+			if applyRules(prop, f.Tag.Get("validate")) {
+				required = append(required, name)
+			}
+			properties[name] = prop
+		}
+	}
+	addFields(t)
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyRules adds a field's validate rules to its schema, reporting whether it's required
+func applyRules(schema map[string]any, rules string) bool {
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		n, _ := strconv.Atoi(arg)
+		switch key {
+		case "required":
+			required = true
+		case "min", "max":
+			kw := map[string]map[string]string{
+				"integer": {"min": "minimum", "max": "maximum"},
+				"string":  {"min": "minLength", "max": "maxLength"},
+				"array":   {"min": "minItems", "max": "maxItems"},
+			}[fmt.Sprint(schema["type"])][key]
+			if kw != "" {
+				schema[kw] = n
+			}
+		case "oneof":
+			schema["enum"] = strings.Fields(arg)
+		case "format":
+			schema["format"] = arg
+		}
+	}
+	return required
+}
+
+// schemaName turns a Go type name like containerRequest into ContainerRequest
+func schemaName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// operationID turns "GET /compute/v1/containers/{id}/events" into "getContainersIdEvents"
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if part == "compute" {
+			continue
+		}
+		r := []rune(part)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) GetOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	if h.spec == nil {
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.spec)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+// TestSpecCoverage makes sure every registered route is documented in
+// apiOperations and every documented route is registered
+func TestSpecCoverage(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "compute.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	h := NewHandler(database, nil, Config{})
+
+	documented := make(map[string]bool)
+	for _, op := range apiOperations {
+		if documented[op.Pattern] {
+			t.Errorf("route %q is documented twice", op.Pattern)
+		}
+		documented[op.Pattern] = true
+	}
+
+	registered := make(map[string]bool)
+	for _, pattern := range h.routes {
+		registered[pattern] = true
+		if !documented[pattern] {
+			t.Errorf("route %q is missing from the OpenAPI spec", pattern)
+		}
+	}
+	for _, op := range apiOperations {
+		if !registered[op.Pattern] {
+			t.Errorf("documented route %q is not registered", op.Pattern)
+		}
+	}
+}
+
+func TestBuildOpenAPISpec(t *testing.T) {
+	spec, err := buildOpenAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		t.Fatalf("spec isn't valid JSON: %v", err)
+	}
+	if len(doc.Paths) == 0 {
+		t.Fatal("spec has no paths")
+	}
+}
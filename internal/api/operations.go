@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+	"github.com/google/uuid"
+)
+
+const (
+	operationTimeout      = 10 * time.Minute
+	maxOperationWait      = 60 * time.Second
+	operationPollInterval = 500 * time.Millisecond
+	defaultOperationRows  = 20
+	maxOperationRows      = 100
+)
+
+type operationResponse struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	ContainerID string          `json:"container_id"`
+	Status      string          `json:"status"`
+	Progress    int             `json:"progress"`
+	Message     string          `json:"message"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       *string         `json:"error,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	UpdatedAt   string          `json:"updated_at"`
+	DoneAt      *string         `json:"done_at,omitempty"`
+}
+
+// progressFunc reports how far along an operation is, as a percentage and a short message
+type progressFunc func(percent int, message string)
+
+// operationFunc does the work of an operation and returns a value to store as its result
+type operationFunc func(ctx context.Context, progress progressFunc) (any, error)
+
+// ListOperations returns recent operations on the caller's containers, or an org's
+// with ?org_id
+func (h *Handler) ListOperations(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.queryOwner(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := parseLimit(r, defaultOperationRows, maxOperationRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ops, err := h.db.ListOperationsByOwner(owner, limit)
+	if err != nil {
+		slog.Error("failed to list operations", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]operationResponse, 0, len(ops))
+	for _, o := range ops {
+		if canAccessContainer(r.Context(), o.ContainerID) {
+			resp = append(resp, operationToResponse(o))
+		}
+	}
+
+	writeJSON(w, resp)
+}
+
+// GetOperation returns an operation. With ?wait=<duration> it blocks until the
+// operation finishes or the wait (capped at a minute) runs out.
+func (h *Handler) GetOperation(w http.ResponseWriter, r *http.Request) {
+	var wait time.Duration
+	if s := r.URL.Query().Get("wait"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			writeError(w, "invalid wait duration", http.StatusBadRequest)
+			return
+		}
+		wait = min(d, maxOperationWait)
+	}
+
+	op, err := h.db.GetOperation(r.PathValue("id"))
+	if err != nil {
+		slog.Error("failed to get operation", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if op == nil || !canAccessContainer(r.Context(), op.ContainerID) {
+		writeError(w, "operation not found", http.StatusNotFound)
+		return
+	}
+	check := func(user *userInfo) (permission, error) {
+		return h.operationPermission(user, op)
+	}
+	if !h.authorizeWith(w, r, check, permView, "operation") {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	ticker := time.NewTicker(operationPollInterval)
+	defer ticker.Stop()
+
+	for !op.Done() {
+		select {
+		case <-ctx.Done():
+			writeJSON(w, operationToResponse(op))
+			return
+		case <-ticker.C:
+		}
+
+		op, err = h.db.GetOperation(op.ID)
+		if err != nil {
+			slog.Error("failed to get operation", "error", err)
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if op == nil {
+			writeError(w, "operation not found", http.StatusNotFound)
+			return
+		}
+	}
+	writeJSON(w, operationToResponse(op))
+}
+
+// operationPermission is the caller's permission on the operation's container, or
+// on what its owner had once the container is gone
+func (h *Handler) operationPermission(user *userInfo, op *db.Operation) (permission, error) {
+	c, err := h.db.GetContainer(op.ContainerID)
+	if err != nil {
+		return permNone, err
+	}
+	if c != nil {
+		return h.containerPermission(user, c)
+	}
+	return h.permission(user, op.Owner())
+}
+
+// startOperation records a new operation and runs fn in the background, storing its
+// progress and outcome. The operation outlives the request that started it.
+func (h *Handler) startOperation(userID int64, c *db.Container, opType string, fn operationFunc) (*db.Operation, error) {
+	op := &db.Operation{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		OrgID:       c.OrgID,
+		ContainerID: c.ID,
+		Type:        opType,
+		Status:      db.OperationPending,
+	}
+	if err := h.db.CreateOperation(op); err != nil {
+		return nil, err
+	}
+
+	go h.runOperation(op.ID, fn)
+	return op, nil
+}
+
+func (h *Handler) runOperation(id string, fn operationFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	progress := func(percent int, message string) {
+		if err := h.db.UpdateOperationProgress(id, percent, message); err != nil {
+			slog.Error("failed to update operation progress", "operation", id, "error", err)
+		}
+	}
+	progress(0, "started")
+
+	result, err := fn(ctx, progress)
+	if err != nil {
+		slog.Error("operation failed", "operation", id, "error", err)
+		if err := h.db.FailOperation(id, err.Error()); err != nil {
+			slog.Error("failed to record operation failure", "operation", id, "error", err)
+		}
+		return
+	}
+
+	var stored sql.NullString
+	if result != nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			slog.Error("failed to encode operation result", "operation", id, "error", err)
+		} else {
+			stored = sql.NullString{String: string(b), Valid: true}
+		}
+	}
+	if err := h.db.CompleteOperation(id, stored); err != nil {
+		slog.Error("failed to record operation result", "operation", id, "error", err)
+	}
+}
+
+// operationStates is the state each type of operation holds its container in while it runs
+var operationStates = map[string]string{
+	db.OperationCreateContainer: db.StatusProvisioning,
+	db.OperationDeleteContainer: db.StatusDeleting,
+	db.OperationStartContainer:  db.StatusStarting,
+	db.OperationStopContainer:   db.StatusStopping,
+}
+
+// FailInterruptedOperations fails the operations left in flight by the last shutdown,
+// returning how many there were. Containers they left mid-change are marked failed,
+// from where they can be started, stopped or deleted again, and the change is
+// recorded as an event like any other.
+func (h *Handler) FailInterruptedOperations() (int, error) {
+	ops, err := h.db.FailInterruptedOperations()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, op := range ops {
+		container, err := h.db.GetContainer(op.ContainerID)
+		if err != nil {
+			slog.Error("failed to get container", "container", op.ContainerID, "error", err)
+			continue
+		}
+		// An operation that hadn't started yet left its container as it was
+		if container == nil || container.Status != operationStates[op.Type] {
+			continue
+		}
+		if err := h.transition(container, db.StatusFailed, "interrupted by service restart"); err != nil {
+			slog.Error("failed to mark container failed", "container", container.ID, "error", err)
+		}
+	}
+	return len(ops), nil
+}
+
+// writeOperation responds 202 Accepted with the operation and where to poll it
+func writeOperation(w http.ResponseWriter, op *db.Operation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", apiBasePath+"/operations/"+op.ID)
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, operationToResponse(op))
+}
+
+func operationToResponse(o *db.Operation) operationResponse {
+	resp := operationResponse{
+		ID:          o.ID,
+		Type:        o.Type,
+		ContainerID: o.ContainerID,
+		Status:      o.Status,
+		Progress:    o.Progress,
+		Message:     o.Message,
+		CreatedAt:   o.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   o.UpdatedAt.Format(time.RFC3339),
+	}
+
+	if o.Result.Valid {
+		resp.Result = json.RawMessage(o.Result.String)
+	}
+	if o.Error.Valid {
+		resp.Error = &o.Error.String
+	}
+	if o.DoneAt.Valid {
+		doneAt := o.DoneAt.Time.Format(time.RFC3339)
+		resp.DoneAt = &doneAt
+	}
+
+	return resp
+}
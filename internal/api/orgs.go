@@ -0,0 +1,271 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+type orgRequest struct {
+	Name string `json:"name" validate:"required,max=63"`
+}
+
+type orgMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner admin member viewer"`
+}
+
+type orgResponse struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Role is the caller's role in the org
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+type orgMemberResponse struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ListOrgs returns the orgs the caller belongs to
+func (h *Handler) ListOrgs(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	orgs, err := h.db.ListOrgsByUser(userID)
+	if err != nil {
+		slog.Error("failed to list orgs", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]orgResponse, 0, len(orgs))
+	for _, o := range orgs {
+		resp = append(resp, orgToResponse(&o.Org, o.Role))
+	}
+	writeJSON(w, resp)
+}
+
+// CreateOrg creates an org with the caller as its owner
+func (h *Handler) CreateOrg(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req orgRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	org := &db.Org{Name: req.Name}
+	if err := h.db.CreateOrg(org, userID); err != nil {
+		if errors.Is(err, db.ErrConflict) {
+			writeErrorCode(w, http.StatusConflict, codeConflict, "org name already taken", map[string]any{"field": "name"})
+			return
+		}
+		slog.Error("failed to create org", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	noteAuditTarget(r.Context(), strconv.FormatInt(org.ID, 10))
+
+	writeJSON(w, orgToResponse(org, db.RoleOwner))
+}
+
+// ListOrgMembers returns an org's members to anyone in it
+func (h *Handler) ListOrgMembers(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := h.orgForRequest(w, r, permView)
+	if !ok {
+		return
+	}
+
+	members, err := h.db.ListOrgMembers(orgID)
+	if err != nil {
+		slog.Error("failed to list org members", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]orgMemberResponse, 0, len(members))
+	for _, m := range members {
+		resp = append(resp, orgMemberToResponse(m))
+	}
+	writeJSON(w, resp)
+}
+
+// SetOrgMember adds a user to an org or changes their role. Admins manage members
+// and viewers; only owners can make or unmake owners and admins. Users can only be
+// added once they've signed in.
+func (h *Handler) SetOrgMember(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := h.orgForRequest(w, r, permManage)
+	if !ok {
+		return
+	}
+
+	var req orgMemberRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	target, currentRole, ok := h.orgMemberForRequest(w, r, orgID)
+	if !ok {
+		return
+	}
+	if target == nil {
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, "user not found", nil)
+		return
+	}
+	if !h.canChangeOrgRole(w, r, orgID, currentRole, req.Role) {
+		return
+	}
+
+	if err := h.db.SetOrgMember(orgID, target.ID, req.Role); err != nil {
+		writeDBError(w, err, "org member")
+		return
+	}
+	if req.Role == db.RoleViewer && currentRole != "" && currentRole != db.RoleViewer {
+		h.syncOrgSSHKeys(r.Context(), orgID)
+	}
+
+	member, err := h.db.GetOrgMember(orgID, target.ID)
+	if err != nil || member == nil {
+		slog.Error("failed to get org member", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, orgMemberToResponse(member))
+}
+
+// RemoveOrgMember takes a user out of an org. Anyone can leave; removing others
+// follows the same rules as SetOrgMember.
+func (h *Handler) RemoveOrgMember(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	orgID, ok := h.orgForRequest(w, r, permView)
+	if !ok {
+		return
+	}
+
+	target, currentRole, ok := h.orgMemberForRequest(w, r, orgID)
+	if !ok {
+		return
+	}
+	if target == nil || currentRole == "" {
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, "org member not found", nil)
+		return
+	}
+	if target.ID != userID {
+		if !h.authorize(w, r, db.OrgOwner(orgID), permManage, "org") ||
+			!h.canChangeOrgRole(w, r, orgID, currentRole, "") {
+			return
+		}
+	}
+
+	if err := h.db.RemoveOrgMember(orgID, target.ID); err != nil {
+		writeDBError(w, err, "org member")
+		return
+	}
+	// Their org SSH keys are gone, so take them out of the org's containers
+	h.syncOrgSSHKeys(r.Context(), orgID)
+
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// orgForRequest parses the org ID in the path and checks the caller holds want on
+// the org's resources
+func (h *Handler) orgForRequest(w http.ResponseWriter, r *http.Request, want permission) (int64, bool) {
+	orgID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	if !h.authorize(w, r, db.OrgOwner(orgID), want, "org") {
+		return 0, false
+	}
+	return orgID, true
+}
+
+// orgMemberForRequest looks up the user named in the path and their current role
+// in the org, which is "" if they aren't a member. The user is nil if they've
+// never signed in.
+func (h *Handler) orgMemberForRequest(w http.ResponseWriter, r *http.Request, orgID int64) (*db.User, string, bool) {
+	user, err := h.db.GetUserByUsername(r.PathValue("username"))
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if user == nil {
+		return nil, "", true
+	}
+
+	role, err := h.db.GetOrgRole(orgID, user.ID)
+	if err != nil {
+		slog.Error("failed to get org role", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	return user, role, true
+}
+
+// canChangeOrgRole checks the caller may move a member from one role to another,
+// where "" is not being a member. Owner and admin roles are the owners' to hand out
+// and take away.
+func (h *Handler) canChangeOrgRole(w http.ResponseWriter, r *http.Request, orgID int64, from, to string) bool {
+	privileged := []string{db.RoleOwner, db.RoleAdmin}
+	if !slices.Contains(privileged, from) && !slices.Contains(privileged, to) {
+		return true
+	}
+
+	user := userFromContext(r.Context())
+	role, err := h.db.GetOrgRole(orgID, user.UserID)
+	if err != nil {
+		slog.Error("failed to get org role", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return false
+	}
+	if role != db.RoleOwner {
+		writeErrorCode(w, http.StatusForbidden, codeForbidden, "only org owners can change owners and admins", nil)
+		return false
+	}
+	return true
+}
+
+func orgToResponse(o *db.Org, role string) orgResponse {
+	return orgResponse{
+		ID:        o.ID,
+		Name:      o.Name,
+		Role:      role,
+		CreatedAt: o.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func orgMemberToResponse(m *db.OrgMember) orgMemberResponse {
+	return orgMemberResponse{
+		UserID:    m.UserID,
+		Username:  m.Username,
+		Role:      m.Role,
+		CreatedAt: m.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateBudget is a token bucket: PerMinute requests a minute on average, in bursts
+// of up to Burst. A zero PerMinute means no limit.
+type RateBudget struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Validate reports a budget that would refill backwards or never let a request through
+func (b RateBudget) Validate() error {
+	if b.PerMinute < 0 || math.IsNaN(b.PerMinute) || math.IsInf(b.PerMinute, 0) {
+		return fmt.Errorf("rate %v must be zero or a positive number", b.PerMinute)
+	}
+	if b.PerMinute > 0 && b.Burst < 1 {
+		return fmt.Errorf("burst %d must be at least 1 when the rate is limited", b.Burst)
+	}
+	return nil
+}
+
+// RateLimits are the budgets each caller gets. Every request spends from Read or
+// Write depending on its method; container create and delete also spend from
+// Expensive, since they churn namespaces in the cluster.
+type RateLimits struct {
+	Read      RateBudget
+	Write     RateBudget
+	Expensive RateBudget
+}
+
+// rateLimitSweepInterval is how often buckets that have refilled are dropped
+const rateLimitSweepInterval = time.Minute
+
+// rateLimiter holds a token bucket per caller and budget
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	budget  RateBudget
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+// rateDecision is the state of a bucket after a request, for the RateLimit headers
+type rateDecision struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// reset is how long until the bucket is full again
+	reset time.Duration
+	// retryAfter is how long until the next request would be allowed
+	retryAfter time.Duration
+}
+
+// allow spends a token from the caller's bucket for budget if there is one
+func (l *rateLimiter) allow(key string, budget RateBudget, now time.Time) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok || b.budget != budget {
+		b = &tokenBucket{budget: budget, tokens: float64(budget.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	d := rateDecision{limit: budget.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = b.timeToFill(1)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = b.timeToFill(float64(budget.Burst))
+	return d
+}
+
+// sweep drops buckets that have refilled, since a new bucket starts full anyway.
+// l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.budget.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Minutes()
+	b.tokens = math.Min(float64(b.budget.Burst), b.tokens+elapsed*b.budget.PerMinute)
+	b.updated = now
+}
+
+// timeToFill is how long until the bucket holds n tokens
+func (b *tokenBucket) timeToFill(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.budget.PerMinute * float64(time.Minute))
+}
+
+// rateLimitKey identifies the caller a budget belongs to. Each API key has its
+// own budget, so a runaway script can't lock its owner out of the web console.
+func rateLimitKey(user *userInfo, budget string) string {
+	if user.AuthMethod == authAPIKey {
+		return fmt.Sprintf("%s:key:%d", budget, user.APIKeyID)
+	}
+	return fmt.Sprintf("%s:user:%d", budget, user.UserID)
+}
+
+// checkRateLimit spends from the caller's budget, setting the RateLimit headers.
+// It writes a 429 and returns false if the budget is used up.
+func (h *Handler) checkRateLimit(w http.ResponseWriter, user *userInfo, name string, budget RateBudget) bool {
+	if budget.PerMinute <= 0 {
+		return true
+	}
+
+	d := h.limiter.allow(rateLimitKey(user, name), budget, time.Now())
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(d.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+	if d.allowed {
+		return true
+	}
+
+	retryAfter := ceilSeconds(d.retryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeErrorCode(w, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded", map[string]any{
+		"budget":              name,
+		"retry_after_seconds": retryAfter,
+	})
+	return false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimit spends from the caller's read or write budget, by request method. It
+// runs inside authMiddleware, once the caller is known.
+func (h *Handler) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, budget := "read", h.cfg.RateLimits.Read
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			name, budget = "write", h.cfg.RateLimits.Write
+		}
+		if !h.checkRateLimit(w, userFromContext(r.Context()), name, budget) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// expensive additionally spends from the caller's budget for requests that are
+// costly for the cluster, such as creating or deleting a container
+func (h *Handler) expensive(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.checkRateLimit(w, userFromContext(r.Context()), "expensive", h.cfg.RateLimits.Expensive) {
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+// RunReaper stops or deletes containers whose TTL has run out until ctx is cancelled.
+// Containers expiring within warnBefore get a single warning event first.
+func (h *Handler) RunReaper(ctx context.Context, interval, warnBefore time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.reapExpired(ctx, warnBefore)
+		}
+	}
+}
+
+func (h *Handler) reapExpired(ctx context.Context, warnBefore time.Duration) {
+	now := time.Now()
+	containers, err := h.db.ListContainersExpiringBefore(now.Add(warnBefore))
+	if err != nil {
+		slog.Error("failed to list expiring containers", "error", err)
+		return
+	}
+
+	for _, c := range containers {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if c.ExpiresAt.Time.After(now) {
+			if !c.ExpiryWarnedAt.Valid {
+				h.recordEvent(c, db.EventContainerExpiring,
+					fmt.Sprintf("container will be %s at %s", expiredState(c.ExpireAction), c.ExpiresAt.Time.Format(time.RFC3339)))
+				if err := h.db.UpdateContainerExpiryWarned(c.ID); err != nil {
+					slog.Error("failed to mark expiry warning", "container", c.ID, "error", err)
+				}
+			}
+			continue
+		}
+
+		if err := h.expireContainer(ctx, c); err != nil {
+			slog.Error("failed to expire container", "container", c.ID, "action", c.ExpireAction, "error", err)
+		}
+	}
+}
+
+func (h *Handler) expireContainer(ctx context.Context, c *db.Container) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if c.ExpireAction == db.ExpireActionStop {
+		if db.CanTransition(c.Status, db.StatusStopping) {
+			if err := h.stopContainer(ctx, c); err != nil {
+				return err
+			}
+		}
+		// Clear the expiry so a later manual start isn't immediately stopped again
+		if err := h.db.UpdateContainerExpiry(c.ID, sql.NullTime{}); err != nil {
+			return err
+		}
+	} else {
+		if err := h.deleteContainer(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	h.recordEvent(c, db.EventContainerExpired, fmt.Sprintf("container %s after its TTL expired", expiredState(c.ExpireAction)))
+	return nil
+}
+
+func expiredState(action string) string {
+	if action == db.ExpireActionStop {
+		return "stopped"
+	}
+	return "deleted"
+}
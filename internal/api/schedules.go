@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/cron"
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+const maxSchedulesPerContainer = 10
+
+type scheduleRequest struct {
+	Action   string `json:"action" validate:"required,oneof=start stop"`
+	Cron     string `json:"cron" validate:"required,max=100"`
+	Timezone string `json:"timezone" validate:"max=64"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+type scheduleResponse struct {
+	ID          int64   `json:"id"`
+	ContainerID string  `json:"container_id"`
+	Action      string  `json:"action"`
+	Cron        string  `json:"cron"`
+	Timezone    string  `json:"timezone"`
+	Enabled     bool    `json:"enabled"`
+	NextRunAt   *string `json:"next_run_at"`
+	LastRunAt   *string `json:"last_run_at,omitempty"`
+	LastError   *string `json:"last_error,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+func (h *Handler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permView)
+	if !ok {
+		return
+	}
+
+	schedules, err := h.db.ListSchedulesByContainer(container.ID)
+	if err != nil {
+		slog.Error("failed to list schedules", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]scheduleResponse, 0, len(schedules))
+	for _, s := range schedules {
+		resp = append(resp, scheduleToResponse(s))
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	container, ok := h.getContainerForRequest(w, r, permManage)
+	if !ok {
+		return
+	}
+
+	var req scheduleRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	schedule := &db.Schedule{
+		ContainerID: container.ID,
+		UserID:      container.UserID,
+		Enabled:     true,
+	}
+	if err := applyScheduleRequest(schedule, &req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.CreateSchedule(schedule, maxSchedulesPerContainer); err != nil {
+		if errors.Is(err, db.ErrLimitExceeded) {
+			writeLimitError(w, "schedule", maxSchedulesPerContainer)
+			return
+		}
+		slog.Error("failed to create schedule", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, scheduleToResponse(schedule))
+}
+
+func (h *Handler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := h.getScheduleForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Fields left out of the request keep their current values
+	enabled := schedule.Enabled
+	req := scheduleRequest{
+		Action:   schedule.Action,
+		Cron:     schedule.Cron,
+		Timezone: schedule.Timezone,
+		Enabled:  &enabled,
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if err := applyScheduleRequest(schedule, &req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.UpdateSchedule(schedule); err != nil {
+		slog.Error("failed to update schedule", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, scheduleToResponse(schedule))
+}
+
+func (h *Handler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, ok := h.getScheduleForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteSchedule(schedule.ID, schedule.ContainerID); err != nil {
+		writeDBError(w, err, "schedule")
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// getScheduleForRequest loads the schedule named by the {id} and {scheduleId} path values,
+// writing an error response and returning false if it doesn't exist or the caller
+// can't manage its container
+func (h *Handler) getScheduleForRequest(w http.ResponseWriter, r *http.Request) (*db.Schedule, bool) {
+	container, ok := h.getContainerForRequest(w, r, permManage)
+	if !ok {
+		return nil, false
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("scheduleId"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid schedule id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	schedule, err := h.db.GetSchedule(id)
+	if err != nil {
+		slog.Error("failed to get schedule", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if schedule == nil || schedule.ContainerID != container.ID {
+		writeError(w, "schedule not found", http.StatusNotFound)
+		return nil, false
+	}
+	return schedule, true
+}
+
+// applyScheduleRequest validates req and copies it onto s, recomputing the next run
+func applyScheduleRequest(s *db.Schedule, req *scheduleRequest) error {
+	expr, err := cron.Parse(req.Cron)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression: %v", err)
+	}
+
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(req.Timezone)
+	if err != nil {
+		return fmt.Errorf("unknown timezone %q", req.Timezone)
+	}
+
+	s.Action = req.Action
+	s.Cron = strings.Join(strings.Fields(req.Cron), " ")
+	s.Timezone = req.Timezone
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+
+	s.NextRunAt = sql.NullTime{}
+	if s.Enabled {
+		next := expr.Next(time.Now().In(loc))
+		if next.IsZero() {
+			return fmt.Errorf("cron expression never matches")
+		}
+		s.NextRunAt = sql.NullTime{Time: next.UTC(), Valid: true}
+	}
+	return nil
+}
+
+func scheduleToResponse(s *db.Schedule) scheduleResponse {
+	resp := scheduleResponse{
+		ID:          s.ID,
+		ContainerID: s.ContainerID,
+		Action:      s.Action,
+		Cron:        s.Cron,
+		Timezone:    s.Timezone,
+		Enabled:     s.Enabled,
+		CreatedAt:   s.CreatedAt.Format(time.RFC3339),
+	}
+
+	if s.NextRunAt.Valid {
+		next := s.NextRunAt.Time.Format(time.RFC3339)
+		resp.NextRunAt = &next
+	}
+	if s.LastRunAt.Valid {
+		last := s.LastRunAt.Time.Format(time.RFC3339)
+		resp.LastRunAt = &last
+	}
+	if s.LastError.Valid {
+		resp.LastError = &s.LastError.String
+	}
+
+	return resp
+}
+
+// RunScheduler executes due container schedules until ctx is cancelled.
+// Next run times live in the database, so runs missed while the service was down
+// fire once on the first check after startup.
+func (h *Handler) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.runDueSchedules(ctx)
+		}
+	}
+}
+
+func (h *Handler) runDueSchedules(ctx context.Context) {
+	schedules, err := h.db.ListDueSchedules(time.Now())
+	if err != nil {
+		slog.Error("failed to list due schedules", "error", err)
+		return
+	}
+
+	for _, s := range schedules {
+		if ctx.Err() != nil {
+			return
+		}
+
+		runErr := h.runSchedule(ctx, s)
+		if runErr != nil {
+			slog.Error("scheduled action failed", "schedule", s.ID, "container", s.ContainerID, "action", s.Action, "error", runErr)
+		}
+
+		var next sql.NullTime
+		if expr, err := cron.Parse(s.Cron); err == nil {
+			if loc, err := time.LoadLocation(s.Timezone); err == nil {
+				if t := expr.Next(time.Now().In(loc)); !t.IsZero() {
+					next = sql.NullTime{Time: t.UTC(), Valid: true}
+				}
+			}
+		}
+		if err := h.db.UpdateScheduleRun(s.ID, next, runErr); err != nil {
+			slog.Error("failed to update schedule", "schedule", s.ID, "error", err)
+		}
+	}
+}
+
+func (h *Handler) runSchedule(ctx context.Context, s *db.Schedule) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	container, err := h.db.GetContainer(s.ContainerID)
+	if err != nil {
+		return err
+	}
+	if container == nil {
+		return fmt.Errorf("container not found")
+	}
+
+	// A container already in (or headed to) the target state is left alone
+	switch s.Action {
+	case db.ScheduleActionStart:
+		if !db.CanTransition(container.Status, db.StatusStarting) {
+			return nil
+		}
+		if err = h.startContainer(ctx, container); err == nil {
+			go h.watchContainer(container)
+		}
+	case db.ScheduleActionStop:
+		if !db.CanTransition(container.Status, db.StatusStopping) {
+			return nil
+		}
+		err = h.stopContainer(ctx, container)
+	default:
+		err = fmt.Errorf("unknown action %q", s.Action)
+	}
+
+	if err != nil {
+		h.recordEvent(container, db.EventScheduleFailed, fmt.Sprintf("scheduled %s (%s %s) failed: %v", s.Action, s.Cron, s.Timezone, err))
+		return err
+	}
+	h.recordEvent(container, db.EventScheduleRun, fmt.Sprintf("scheduled %s (%s %s)", s.Action, s.Cron, s.Timezone))
+	return nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// Scopes limit what an API key can do. Sessions, and keys created before scopes
+// existed, hold scopeAll.
+const (
+	scopeAll             = "*"
+	scopeContainersRead  = "containers:read"
+	scopeContainersWrite = "containers:write"
+	scopeSSHKeysRead     = "ssh-keys:read"
+	scopeSSHKeysWrite    = "ssh-keys:write"
+	scopeAPIKeysManage   = "api-keys:manage"
+	scopeWebhooksManage  = "webhooks:manage"
+	scopeSettingsRead    = "settings:read"
+	scopeSettingsWrite   = "settings:write"
+	scopeAuditRead       = "audit:read"
+	scopeOrgsRead        = "orgs:read"
+	scopeOrgsWrite       = "orgs:write"
+)
+
+// apiScopes are the scopes a key can be given, other than scopeAll
+var apiScopes = []string{
+	scopeContainersRead, scopeContainersWrite,
+	scopeSSHKeysRead, scopeSSHKeysWrite,
+	scopeAPIKeysManage,
+	scopeWebhooksManage,
+	scopeSettingsRead, scopeSettingsWrite,
+	scopeAuditRead,
+	scopeOrgsRead, scopeOrgsWrite,
+}
+
+// impliedScopes lists scopes granted along with another; write access includes read
+var impliedScopes = map[string]string{
+	scopeContainersWrite: scopeContainersRead,
+	scopeSSHKeysWrite:    scopeSSHKeysRead,
+	scopeSettingsWrite:   scopeSettingsRead,
+	scopeOrgsWrite:       scopeOrgsRead,
+}
+
+// hasScope reports whether the caller holds scope
+func (u *userInfo) hasScope(scope string) bool {
+	for _, s := range u.Scopes {
+		if s == scopeAll || s == scope || impliedScopes[s] == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessContainer reports whether the caller may see or act on a container. Keys
+// can be restricted to some of their owner's containers.
+func (u *userInfo) canAccessContainer(id string) bool {
+	return u.ContainerIDs == nil || slices.Contains(u.ContainerIDs, id)
+}
+
+// requireScope rejects callers that don't hold scope with 403
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := userFromContext(r.Context())
+		if user == nil {
+			writeError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !user.hasScope(scope) {
+			writeErrorCode(w, http.StatusForbidden, codeForbidden, "credentials lack the "+scope+" scope",
+				map[string]any{"required_scope": scope})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// canAccessContainer reports whether the request's caller may see or act on a container
+func canAccessContainer(ctx context.Context, id string) bool {
+	user := userFromContext(ctx)
+	return user != nil && user.canAccessContainer(id)
+}
@@ -1,9 +1,11 @@
 package api
 
 import (
+	"context"
 	"crypto/md5"
+	"database/sql"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -17,25 +19,35 @@ import (
 const maxSSHKeysPerUser = 10
 
 type sshKeyRequest struct {
-	Name      string `json:"name"`
-	PublicKey string `json:"public_key"`
+	Name      string `json:"name" validate:"required,max=63"`
+	PublicKey string `json:"public_key" validate:"required,max=16384"`
+	// OrgID adds the key to an org, which needs the member role. The org's
+	// containers accept every org key.
+	OrgID int64 `json:"org_id" validate:"min=1"`
 }
 
 type sshKeyResponse struct {
 	ID          int64  `json:"id"`
 	Name        string `json:"name"`
 	Fingerprint string `json:"fingerprint"`
-	CreatedAt   string `json:"created_at"`
+	// OrgID is the org that owns the key, if it isn't personal
+	OrgID     *int64 `json:"org_id,omitempty"`
+	CreatedAt string `json:"created_at"`
 }
 
 func (h *Handler) ListSSHKeys(w http.ResponseWriter, r *http.Request) {
-	userID, _, ok := getUserFromContext(r.Context())
+	owner, ok := h.queryOwner(w, r)
 	if !ok {
-		writeError(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	keys, err := h.db.ListSSHKeysByUser(userID)
+	opts, err := parseListOptions(r, defaultListRows, maxListRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys, next, err := h.db.ListSSHKeysByOwner(owner, opts)
 	if err != nil {
 		slog.Error("failed to list ssh keys", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
@@ -47,6 +59,7 @@ func (h *Handler) ListSSHKeys(w http.ResponseWriter, r *http.Request) {
 		resp = append(resp, sshKeyToResponse(k))
 	}
 
+	setNextCursor(w, next)
 	writeJSON(w, resp)
 }
 
@@ -58,8 +71,11 @@ func (h *Handler) AddSSHKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req sshKeyRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, "invalid request body", http.StatusBadRequest)
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	owner, ok := h.requestOwner(w, r, req.OrgID, permOperate)
+	if !ok {
 		return
 	}
 
@@ -81,36 +97,37 @@ func (h *Handler) AddSSHKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check key limit
-	count, err := h.db.CountSSHKeysByUser(userID)
-	if err != nil {
-		slog.Error("failed to count ssh keys", "error", err)
-		writeError(w, "internal error", http.StatusInternalServerError)
-		return
-	}
-	if count >= maxSSHKeysPerUser {
-		writeError(w, fmt.Sprintf("SSH key limit reached (%d)", maxSSHKeysPerUser), http.StatusBadRequest)
-		return
-	}
-
 	fingerprint := sshKeyFingerprint(req.PublicKey)
 
 	key := &db.SSHKey{
 		UserID:      userID,
+		OrgID:       sql.NullInt64{Int64: owner.OrgID, Valid: owner.IsOrg()},
 		Name:        req.Name,
 		PublicKey:   req.PublicKey,
 		Fingerprint: fingerprint,
 	}
 
-	if err := h.db.CreateSSHKey(key); err != nil {
+	if err := h.db.CreateSSHKey(key, maxSSHKeysPerUser); err != nil {
+		if errors.Is(err, db.ErrLimitExceeded) {
+			writeLimitError(w, "SSH key", maxSSHKeysPerUser)
+			return
+		}
 		slog.Error("failed to create ssh key", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	noteAuditTarget(r.Context(), strconv.FormatInt(key.ID, 10))
+	if owner.IsOrg() {
+		h.syncOrgSSHKeys(r.Context(), owner.OrgID)
+	} else {
+		h.syncSharedSSHKeys(r.Context(), userID)
+	}
 
 	writeJSON(w, sshKeyToResponse(key))
 }
 
+// DeleteSSHKey deletes a key. Org members can delete the org keys they added;
+// other org keys need the admin role.
 func (h *Handler) DeleteSSHKey(w http.ResponseWriter, r *http.Request) {
 	userID, _, ok := getUserFromContext(r.Context())
 	if !ok {
@@ -125,24 +142,129 @@ func (h *Handler) DeleteSSHKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.db.DeleteSSHKey(id, userID); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			writeError(w, "SSH key not found", http.StatusNotFound)
-			return
-		}
-		slog.Error("failed to delete ssh key", "error", err)
+	key, err := h.db.GetSSHKey(id)
+	if err != nil {
+		slog.Error("failed to get ssh key", "error", err)
 		writeError(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	if key == nil {
+		writeErrorCode(w, http.StatusNotFound, codeNotFound, "SSH key not found", nil)
+		return
+	}
+	want := permManage
+	if key.UserID == userID {
+		want = permOperate
+	}
+	owner := key.Owner()
+	if !h.authorize(w, r, owner, want, "SSH key") {
+		return
+	}
+
+	if err := h.db.DeleteSSHKey(id, owner); err != nil {
+		writeDBError(w, err, "SSH key")
+		return
+	}
+	if owner.IsOrg() {
+		h.syncOrgSSHKeys(r.Context(), owner.OrgID)
+	} else {
+		h.syncSharedSSHKeys(r.Context(), key.UserID)
+	}
 
 	writeJSON(w, map[string]string{"status": "ok"})
 }
 
+// syncOrgSSHKeys rewrites the authorized_keys of each of an org's containers to
+// its current keys, so members added or removed since a container was created
+// gain or lose access. Failures are logged and left for the next change to fix.
+func (h *Handler) syncOrgSSHKeys(ctx context.Context, orgID int64) {
+	containers, err := h.db.ListContainersByOrg(orgID)
+	if err != nil {
+		slog.Error("failed to list org containers", "org", orgID, "error", err)
+		return
+	}
+	h.syncSSHKeysOf(ctx, containers)
+}
+
+// syncSharedSSHKeys is syncOrgSSHKeys for the containers shared with a user, whose
+// keys were added or removed
+func (h *Handler) syncSharedSSHKeys(ctx context.Context, userID int64) {
+	grants, err := h.db.ListContainerGrantsByUser(userID)
+	if err != nil {
+		slog.Error("failed to list container grants", "user", userID, "error", err)
+		return
+	}
+
+	var containers []*db.Container
+	for _, g := range grants {
+		c, err := h.db.GetContainer(g.ContainerID)
+		if err != nil {
+			slog.Error("failed to get container", "container", g.ContainerID, "error", err)
+			continue
+		}
+		if c != nil {
+			containers = append(containers, c)
+		}
+	}
+	h.syncSSHKeysOf(ctx, containers)
+}
+
+func (h *Handler) syncSSHKeysOf(ctx context.Context, containers []*db.Container) {
+	for _, c := range containers {
+		if err := h.syncContainerSSHKeys(ctx, c); err != nil {
+			slog.Error("failed to update ssh keys", "container", c.ID, "error", err)
+		}
+	}
+}
+
+// syncContainerSSHKeys rewrites a container's authorized_keys: the keys it was
+// created with, or its org's current keys, and the keys of everyone it's shared
+// with. Containers being deleted are left alone.
+func (h *Handler) syncContainerSSHKeys(ctx context.Context, c *db.Container) error {
+	if c.Status == db.StatusDeleting {
+		return nil
+	}
+
+	var base string
+	if c.OrgID.Valid {
+		keys, err := h.db.ListSSHKeysByOrg(c.OrgID.Int64)
+		if err != nil {
+			return err
+		}
+		base = authorizedKeys(keys)
+	} else {
+		keys, ok, err := h.db.GetContainerAuthorizedKeys(c.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Containers from before the keys were recorded have only them in their secret
+			if keys, err = h.k8s.GetSSHSecret(ctx, c.Namespace); err != nil {
+				return err
+			}
+			if err := h.db.SetContainerAuthorizedKeys(c.ID, keys); err != nil {
+				return err
+			}
+		}
+		base = keys
+	}
+	if base != "" && !strings.HasSuffix(base, "\n") {
+		base += "\n"
+	}
+
+	shared, err := h.db.ListSSHKeysByContainerGrants(c.ID)
+	if err != nil {
+		return err
+	}
+	return h.k8s.UpdateSSHSecret(ctx, c.Namespace, base+authorizedKeys(shared))
+}
+
 func sshKeyToResponse(k *db.SSHKey) sshKeyResponse {
 	return sshKeyResponse{
 		ID:          k.ID,
 		Name:        k.Name,
 		Fingerprint: k.Fingerprint,
+		OrgID:       ownerOrgID(k.Owner()),
 		CreatedAt:   k.CreatedAt.Format(time.RFC3339),
 	}
 }
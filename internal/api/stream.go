@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	streamBatchSize   = 100
+	streamHeartbeat   = 15 * time.Second
+	streamRetryMillis = 3000
+)
+
+// eventBroker wakes up event streams when a user can see a new event.
+// Events themselves are read from the database, so a missed wake-up only delays
+// delivery until the next one and resumed streams see exactly what they missed.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[int64]map[chan struct{}]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[int64]map[chan struct{}]struct{})}
+}
+
+func (b *eventBroker) subscribe(userID int64) chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(userID int64, ch chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers[userID], ch)
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+	}
+}
+
+func (b *eventBroker) notify(userID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[userID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// Already has a pending wake-up
+		}
+	}
+}
+
+// StreamEvents pushes events on the caller's containers, or an org's with ?org_id,
+// as Server-Sent Events. Clients resume with the Last-Event-ID header (or last_event_id query parameter,
+// since EventSource can't set headers on its first request); without one the
+// stream starts from new events only.
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	owner, ok := h.queryOwner(w, r)
+	if !ok {
+		return
+	}
+	userID := userFromContext(r.Context()).UserID
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, err := lastEventID(r)
+	if err != nil {
+		writeError(w, "invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+	if lastID < 0 {
+		lastID, err = h.db.GetLatestEventID()
+		if err != nil {
+			slog.Error("failed to get latest event id", "error", err)
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Subscribe before the first read so nothing recorded in between is missed
+	wake := h.events.subscribe(userID)
+	defer h.events.unsubscribe(userID, wake)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMillis)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		for {
+			events, err := h.db.ListEventsAfter(owner, lastID, streamBatchSize)
+			if err != nil {
+				slog.Error("failed to list events for stream", "error", err)
+				return
+			}
+			for _, e := range events {
+				lastID = e.ID
+				if !canAccessContainer(r.Context(), e.ContainerID) {
+					continue
+				}
+				data, err := json.Marshal(shapeForVersion(eventToResponse(e), responseVersion(w)))
+				if err != nil {
+					slog.Error("failed to encode event", "error", err)
+					return
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+			if len(events) < streamBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// lastEventID returns the ID the client last saw, or -1 if it didn't send one
+func lastEventID(r *http.Request) (int64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return -1, nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid event id %q", s)
+	}
+	return id, nil
+}
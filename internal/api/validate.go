@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxRequestBodyBytes = 1 << 20
+
+// Request bodies are validated from `validate` struct tags, which also feed the
+// OpenAPI schemas, so the documented and enforced rules can't drift apart.
+// Rules are comma-separated:
+//
+//	required     the field must be present and non-zero (non-empty for strings and slices)
+//	min=N,max=N  numeric range, string length in characters, or number of slice items
+//	oneof=a b c  allowed string values
+//	format=date-time  an RFC 3339 timestamp
+//
+// Other rules only apply to fields that are set: non-nil pointers, or non-zero values.
+
+// validationError describes the first field of a request body that broke a rule
+type validationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *validationError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// decodeRequest decodes a JSON request body into dst, which may hold defaults for
+// fields the client leaves out, and validates it. Unknown fields are rejected.
+// On failure it writes a 400 response and returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = fmt.Errorf("request body must be a single JSON object")
+	}
+	if err == nil {
+		err = validateStruct(reflect.ValueOf(dst).Elem())
+	}
+	if err == nil {
+		return true
+	}
+
+	var (
+		verr      *validationError
+		typeErr   *json.UnmarshalTypeError
+		syntaxErr *json.SyntaxError
+		tooLarge  *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &verr):
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, verr.Error(), map[string]any{"field": verr.Field})
+	case errors.As(err, &typeErr):
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+			map[string]any{"field": typeErr.Field})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeErrorCode(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("unknown field %q", field), map[string]any{"field": field})
+	case errors.As(err, &tooLarge):
+		writeErrorCode(w, http.StatusRequestEntityTooLarge, codeInvalidRequest, "request body too large", nil)
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		writeError(w, "invalid request body", http.StatusBadRequest)
+	default:
+		writeError(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+	}
+	return false
+}
+
+func validateStruct(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		rules := f.Tag.Get("validate")
+		if rules == "" {
+			continue
+		}
+		if err := validateField(jsonName(f), v.Field(i), rules); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateField(name string, v reflect.Value, rules string) error {
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "required" {
+			required = true
+		}
+	}
+
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if required {
+				return &validationError{name, "is required"}
+			}
+			return nil
+		}
+		v = v.Elem()
+	} else if v.IsZero() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+		if required {
+			return &validationError{name, "is required"}
+		}
+		return nil
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+		case "min", "max":
+			limit, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("bad validate rule %q on %s", rule, name))
+			}
+			if err := checkBound(name, v, key, limit); err != nil {
+				return err
+			}
+		case "oneof":
+			allowed := strings.Fields(arg)
+			if !contains(allowed, v.String()) {
+				return &validationError{name, fmt.Sprintf("must be one of %s", strings.Join(quoteAll(allowed), ", "))}
+			}
+		case "format":
+			if arg == "date-time" {
+				if _, err := time.Parse(time.RFC3339, v.String()); err != nil {
+					return &validationError{name, "must be an RFC 3339 timestamp"}
+				}
+			}
+		default:
+			panic(fmt.Sprintf("unknown validate rule %q on %s", rule, name))
+		}
+	}
+	return nil
+}
+
+func checkBound(name string, v reflect.Value, key string, limit int64) error {
+	var n int64
+	var unit string
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		n = v.Int()
+	case reflect.String:
+		n, unit = int64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice:
+		n, unit = int64(v.Len()), " items"
+	default:
+		panic(fmt.Sprintf("min/max not supported on %s (%s)", name, v.Kind()))
+	}
+
+	if key == "min" && n < limit {
+		if unit != "" {
+			return &validationError{name, fmt.Sprintf("must have at least %d%s", limit, unit)}
+		}
+		return &validationError{name, fmt.Sprintf("must be at least %d", limit)}
+	}
+	if key == "max" && n > limit {
+		if unit != "" {
+			return &validationError{name, fmt.Sprintf("must have at most %d%s", limit, unit)}
+		}
+		return &validationError{name, fmt.Sprintf("must be at most %d", limit)}
+	}
+	return nil
+}
+
+// jsonName is the name a struct field has in JSON
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteAll(list []string) []string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = strconv.Quote(s)
+	}
+	return quoted
+}
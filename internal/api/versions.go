@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersion identifies a version of the API's request and response shapes
+type apiVersion int
+
+const (
+	apiV1 apiVersion = 1
+
+	latestAPIVersion = apiV1
+)
+
+// apiBasePath is where the latest API version is served
+const apiBasePath = "/compute/v1"
+
+// Every API route is served under each version's prefix. Handlers are shared
+// between versions; where a response shape changes, the response type implements
+// versionedResponse and writeJSON picks the shape for the request's version. To add
+// v2, add it here, then give the types that change a forVersion method, e.g.
+//
+//	func (c containerResponse) forVersion(v apiVersion) any {
+//		if v < apiV2 {
+//			return c
+//		}
+//		return containerResponseV2{...}
+//	}
+var apiVersions = []struct {
+	prefix  string
+	version apiVersion
+}{
+	{"/compute/v1", apiV1},
+}
+
+// The unversioned /compute/... paths predate versioning. They keep serving v1 so
+// existing scripts still work, but every response says they're going away.
+const (
+	unversionedPrefix = "/compute"
+	unversionedAPI    = apiV1
+)
+
+var (
+	unversionedDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	unversionedSunsetAt     = time.Date(2027, time.April, 18, 0, 0, 0, 0, time.UTC)
+)
+
+// versionedResponse is implemented by response types whose shape differs between
+// API versions
+type versionedResponse interface {
+	forVersion(v apiVersion) any
+}
+
+// versionWriter carries the API version a request was made against down to writeJSON
+type versionWriter struct {
+	http.ResponseWriter
+	version apiVersion
+}
+
+func (w *versionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *versionWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func withVersion(v apiVersion, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(&versionWriter{ResponseWriter: w, version: v}, r)
+	}
+}
+
+// deprecatedPath marks responses from an unversioned alias with Deprecation and
+// Sunset headers (RFC 9745, RFC 8594) and a link to the versioned path
+func deprecatedPath(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := apiBasePath + strings.TrimPrefix(r.URL.Path, unversionedPrefix)
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(unversionedDeprecatedAt.Unix(), 10))
+		w.Header().Set("Sunset", unversionedSunsetAt.Format(http.TimeFormat))
+		w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+		next(w, r)
+	}
+}
+
+// responseVersion finds the API version of the request being answered on w
+func responseVersion(w http.ResponseWriter) apiVersion {
+	for {
+		switch rw := w.(type) {
+		case *versionWriter:
+			return rw.version
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return latestAPIVersion
+		}
+	}
+}
+
+// shapeForVersion converts a response, or each item of a list response, to the
+// shape of the given API version
+func shapeForVersion(data any, v apiVersion) any {
+	if vr, ok := data.(versionedResponse); ok {
+		return vr.forVersion(v)
+	}
+
+	rv := reflect.ValueOf(data)
+	if rv.Kind() != reflect.Slice || !rv.Type().Elem().Implements(reflect.TypeOf((*versionedResponse)(nil)).Elem()) {
+		return data
+	}
+	items := make([]any, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface().(versionedResponse).forVersion(v)
+	}
+	return items
+}
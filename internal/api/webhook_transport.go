@@ -0,0 +1,95 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errWebhookAddress is returned when a webhook URL resolves to an address
+// deliveries may not be sent to
+var errWebhookAddress = errors.New("webhook url resolves to a non-public address")
+
+// nonPublicPrefixes are ranges the netip predicates don't cover: shared address
+// space, used for carrier-grade NAT and by some cluster networks for pod and
+// service addresses, and the NAT64 prefixes, which a NAT64 gateway translates to
+// whatever IPv4 address is embedded in them, internal ones included
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// publicWebhookAddr reports whether deliveries may be sent to addr. Loopback,
+// private, link-local (including the cloud metadata address), NAT64 and other
+// non-routable ranges are refused so webhooks can't reach the cluster network.
+func publicWebhookAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	switch {
+	case !addr.IsValid(),
+		addr.IsUnspecified(),
+		addr.IsLoopback(),
+		addr.IsPrivate(),
+		addr.IsLinkLocalUnicast(),
+		addr.IsLinkLocalMulticast(),
+		addr.IsInterfaceLocalMulticast(),
+		addr.IsMulticast():
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicWebhookHost rejects webhook hosts that are obviously internal when a
+// webhook is created: non-public IP literals, localhost, and single-label or
+// cluster DNS names such as "simple-file-share-backend" or "kubernetes.default.svc".
+// Names are checked again after resolution at delivery time, see webhookTransport.
+func publicWebhookHost(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return publicWebhookAddr(addr)
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if !strings.Contains(host, ".") {
+		return false
+	}
+	for _, suffix := range []string{".localhost", ".local", ".internal", ".svc"} {
+		if strings.HasSuffix(host, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookTransport dials webhook receivers, refusing connections to non-public
+// addresses. The check runs on the address actually being dialled, after DNS
+// resolution, so a public name can't be pointed at an internal address later.
+func webhookTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !publicWebhookAddr(addrPort.Addr()) {
+				return errWebhookAddress
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		// No proxy: it would do the dialling and bypass the address check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   webhookTimeout,
+		ResponseHeaderTimeout: webhookTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          10,
+	}
+}
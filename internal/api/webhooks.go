@@ -0,0 +1,539 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+const (
+	maxWebhooksPerUser     = 5
+	defaultDeliveryRows    = 50
+	maxDeliveryRows        = 200
+	webhookTimeout         = 10 * time.Second
+	webhookMaxAttempts     = 8
+	webhookBaseBackoff     = 30 * time.Second
+	webhookMaxBackoff      = time.Hour
+	webhookBatchSize       = 50
+	webhookDeliveryRetain  = 30 * 24 * time.Hour
+	webhookMaxResponseBody = 1024
+)
+
+// webhookEventTypes are the event types webhooks can subscribe to
+var webhookEventTypes = func() map[string]bool {
+	types := map[string]bool{
+		db.EventContainerDeleted:  true,
+		db.EventIPAssigned:        true,
+		db.EventDiskThreshold:     true,
+		db.EventContainerExpiring: true,
+		db.EventContainerExpired:  true,
+		db.EventContainerIdle:     true,
+		db.EventScheduleRun:       true,
+		db.EventScheduleFailed:    true,
+	}
+	for _, status := range []string{
+		db.StatusProvisioning, db.StatusStarting, db.StatusRunning, db.StatusStopping,
+		db.StatusStopped, db.StatusDeleting, db.StatusFailed,
+	} {
+		types[db.StatusEventType(status)] = true
+	}
+	return types
+}()
+
+type webhookRequest struct {
+	URL        string   `json:"url" validate:"required,max=2048"`
+	Secret     string   `json:"secret" validate:"min=16,max=256"`
+	EventTypes []string `json:"event_types" validate:"required,max=32"`
+}
+
+type webhookResponse struct {
+	ID         int64    `json:"id"`
+	URL        string   `json:"url"`
+	Secret     *string  `json:"secret,omitempty"` // Only returned on creation
+	EventTypes []string `json:"event_types"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+type deliveryResponse struct {
+	ID            int64   `json:"id"`
+	WebhookID     int64   `json:"webhook_id"`
+	EventID       int64   `json:"event_id"`
+	EventType     string  `json:"event_type"`
+	Status        string  `json:"status"`
+	Attempts      int     `json:"attempts"`
+	NextAttemptAt *string `json:"next_attempt_at,omitempty"`
+	ResponseCode  *int64  `json:"response_code,omitempty"`
+	LastError     *string `json:"last_error,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+	DeliveredAt   *string `json:"delivered_at,omitempty"`
+}
+
+// webhookPayload is the JSON body POSTed to webhook endpoints
+type webhookPayload struct {
+	eventResponse
+	Container containerResponse `json:"container"`
+}
+
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	webhooks, err := h.db.ListWebhooksByUser(userID)
+	if err != nil {
+		slog.Error("failed to list webhooks", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]webhookResponse, 0, len(webhooks))
+	for _, wh := range webhooks {
+		resp = append(resp, webhookToResponse(wh))
+	}
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req webhookRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		writeError(w, "url must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	if !publicWebhookHost(u.Hostname()) {
+		writeError(w, "url must point to a public host", http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[string]bool)
+	var eventTypes []string
+	for _, t := range req.EventTypes {
+		t = strings.TrimSpace(t)
+		if !webhookEventTypes[t] {
+			writeError(w, fmt.Sprintf("unknown event type %q", t), http.StatusBadRequest)
+			return
+		}
+		if !seen[t] {
+			seen[t] = true
+			eventTypes = append(eventTypes, t)
+		}
+	}
+
+	// Generate a secret if the caller didn't bring one
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			slog.Error("failed to generate webhook secret", "error", err)
+			writeError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		req.Secret = hex.EncodeToString(b)
+	}
+
+	webhook := &db.Webhook{
+		UserID:     userID,
+		URL:        u.String(),
+		Secret:     req.Secret,
+		EventTypes: eventTypes,
+	}
+	if err := h.db.CreateWebhook(webhook, maxWebhooksPerUser); err != nil {
+		if errors.Is(err, db.ErrLimitExceeded) {
+			writeLimitError(w, "webhook", maxWebhooksPerUser)
+			return
+		}
+		slog.Error("failed to create webhook", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	noteAuditTarget(r.Context(), strconv.FormatInt(webhook.ID, 10))
+
+	// Return response with the secret (only time it's shown)
+	resp := webhookToResponse(webhook)
+	resp.Secret = &webhook.Secret
+
+	writeJSON(w, resp)
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.DeleteWebhook(id, userID); err != nil {
+		writeDBError(w, err, "webhook")
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.getWebhookForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	limit, err := parseLimit(r, defaultDeliveryRows, maxDeliveryRows)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.db.ListWebhookDeliveries(webhook.ID, limit)
+	if err != nil {
+		slog.Error("failed to list webhook deliveries", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]deliveryResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		resp = append(resp, deliveryToResponse(d))
+	}
+
+	writeJSON(w, resp)
+}
+
+// RedeliverWebhook queues a fresh delivery of an earlier delivery's payload.
+// The original stays in the log untouched.
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := h.getWebhookForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(r.PathValue("deliveryId"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid delivery id", http.StatusBadRequest)
+		return
+	}
+
+	original, err := h.db.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		slog.Error("failed to get webhook delivery", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if original == nil || original.WebhookID != webhook.ID {
+		writeError(w, "delivery not found", http.StatusNotFound)
+		return
+	}
+
+	delivery := &db.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   original.EventID,
+		EventType: original.EventType,
+		Payload:   original.Payload,
+	}
+	if err := h.db.CreateWebhookDelivery(delivery); err != nil {
+		slog.Error("failed to create webhook delivery", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.wakeWebhooks()
+
+	writeJSON(w, deliveryToResponse(delivery))
+}
+
+// getWebhookForRequest loads the webhook named by the {id} path value, writing an
+// error response and returning false if it doesn't exist or isn't the caller's
+func (h *Handler) getWebhookForRequest(w http.ResponseWriter, r *http.Request) (*db.Webhook, bool) {
+	userID, _, ok := getUserFromContext(r.Context())
+	if !ok {
+		writeError(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "invalid id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	webhook, err := h.db.GetWebhook(id)
+	if err != nil {
+		slog.Error("failed to get webhook", "error", err)
+		writeError(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if webhook == nil || webhook.UserID != userID {
+		writeError(w, "webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	return webhook, true
+}
+
+// enqueueWebhooks queues a delivery of event to each webhook of the given users that
+// subscribes to it
+func (h *Handler) enqueueWebhooks(c *db.Container, event *db.ContainerEvent, userIDs []int64) {
+	var webhooks []*db.Webhook
+	for _, userID := range userIDs {
+		userWebhooks, err := h.db.ListWebhooksByUser(userID)
+		if err != nil {
+			slog.Error("failed to list webhooks", "user", userID, "error", err)
+			continue
+		}
+		webhooks = append(webhooks, userWebhooks...)
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+	queued := false
+	for _, wh := range webhooks {
+		if !wh.Subscribes(event.Type) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(webhookPayload{
+				eventResponse: eventToResponse(event),
+				Container:     containerToResponse(c),
+			})
+			if err != nil {
+				slog.Error("failed to encode webhook payload", "error", err)
+				return
+			}
+		}
+
+		delivery := &db.WebhookDelivery{
+			WebhookID: wh.ID,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   string(payload),
+		}
+		if err := h.db.CreateWebhookDelivery(delivery); err != nil {
+			slog.Error("failed to queue webhook delivery", "webhook", wh.ID, "error", err)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		h.wakeWebhooks()
+	}
+}
+
+func (h *Handler) wakeWebhooks() {
+	select {
+	case h.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunWebhookDeliverer sends queued webhook deliveries until ctx is cancelled.
+// It runs as soon as a delivery is queued and every interval to pick up retries.
+func (h *Handler) RunWebhookDeliverer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	client := &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: webhookTransport(),
+		// Receivers must answer directly; a redirect counts as a failed delivery
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.webhookWake:
+		}
+		h.sendDueDeliveries(ctx, client)
+	}
+}
+
+func (h *Handler) sendDueDeliveries(ctx context.Context, client *http.Client) {
+	if err := h.db.DeleteWebhookDeliveriesBefore(time.Now().Add(-webhookDeliveryRetain)); err != nil {
+		slog.Error("failed to prune webhook deliveries", "error", err)
+	}
+
+	for ctx.Err() == nil {
+		deliveries, err := h.db.ListDueWebhookDeliveries(time.Now(), webhookBatchSize)
+		if err != nil {
+			slog.Error("failed to list webhook deliveries", "error", err)
+			return
+		}
+
+		webhooks := make(map[int64]*db.Webhook)
+		for _, d := range deliveries {
+			if ctx.Err() != nil {
+				return
+			}
+			wh, ok := webhooks[d.WebhookID]
+			if !ok {
+				if wh, err = h.db.GetWebhook(d.WebhookID); err != nil {
+					slog.Error("failed to get webhook", "webhook", d.WebhookID, "error", err)
+					continue
+				}
+				webhooks[d.WebhookID] = wh
+			}
+			h.attemptDelivery(ctx, client, wh, d)
+		}
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// attemptDelivery POSTs a delivery once and schedules a retry with exponential
+// backoff if it fails, giving up after webhookMaxAttempts
+func (h *Handler) attemptDelivery(ctx context.Context, client *http.Client, wh *db.Webhook, d *db.WebhookDelivery) {
+	d.Attempts++
+	d.NextAttemptAt = sql.NullTime{}
+
+	var code int
+	var err error
+	if wh == nil {
+		err = fmt.Errorf("webhook deleted")
+		d.Attempts = webhookMaxAttempts
+	} else {
+		code, err = sendWebhook(ctx, client, wh, d)
+	}
+
+	if code != 0 {
+		d.ResponseCode = sql.NullInt64{Int64: int64(code), Valid: true}
+	}
+
+	switch {
+	case err == nil:
+		d.Status = db.DeliverySucceeded
+		d.LastError = sql.NullString{}
+		d.DeliveredAt = sql.NullTime{Time: time.Now(), Valid: true}
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status = db.DeliveryFailed
+		d.LastError = sql.NullString{String: err.Error(), Valid: true}
+	default:
+		d.Status = db.DeliveryPending
+		d.LastError = sql.NullString{String: err.Error(), Valid: true}
+		backoff := min(webhookBaseBackoff<<(d.Attempts-1), webhookMaxBackoff)
+		d.NextAttemptAt = sql.NullTime{Time: time.Now().Add(backoff), Valid: true}
+	}
+
+	if err != nil {
+		slog.Warn("webhook delivery failed", "webhook", d.WebhookID, "delivery", d.ID, "attempt", d.Attempts, "error", err)
+	}
+	if err := h.db.UpdateWebhookDeliveryAttempt(d); err != nil {
+		slog.Error("failed to update webhook delivery", "delivery", d.ID, "error", err)
+	}
+}
+
+// sendWebhook POSTs the delivery payload, signed with the webhook secret.
+// The signature is HMAC-SHA256 over "<timestamp>.<body>" so receivers can reject replays.
+func sendWebhook(ctx context.Context, client *http.Client, wh *db.Webhook, d *db.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(wh.Secret))
+	mac.Write([]byte(timestamp + "." + d.Payload))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "edd-compute-webhooks")
+	req.Header.Set("X-Compute-Event", d.EventType)
+	req.Header.Set("X-Compute-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Compute-Timestamp", timestamp)
+	req.Header.Set("X-Compute-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Don't reveal what an internal name resolved to
+		if errors.Is(err, errWebhookAddress) {
+			return 0, errWebhookAddress
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookMaxResponseBody))
+
+	// The response body isn't recorded; it's visible to the webhook owner and
+	// shouldn't become a way to read other services' responses
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func webhookToResponse(wh *db.Webhook) webhookResponse {
+	eventTypes := append([]string(nil), wh.EventTypes...)
+	sort.Strings(eventTypes)
+	return webhookResponse{
+		ID:         wh.ID,
+		URL:        wh.URL,
+		EventTypes: eventTypes,
+		CreatedAt:  wh.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func deliveryToResponse(d *db.WebhookDelivery) deliveryResponse {
+	resp := deliveryResponse{
+		ID:        d.ID,
+		WebhookID: d.WebhookID,
+		EventID:   d.EventID,
+		EventType: d.EventType,
+		Status:    d.Status,
+		Attempts:  d.Attempts,
+		CreatedAt: d.CreatedAt.Format(time.RFC3339),
+	}
+
+	if d.NextAttemptAt.Valid {
+		next := d.NextAttemptAt.Time.Format(time.RFC3339)
+		resp.NextAttemptAt = &next
+	}
+	if d.ResponseCode.Valid {
+		resp.ResponseCode = &d.ResponseCode.Int64
+	}
+	if d.LastError.Valid {
+		resp.LastError = &d.LastError.String
+	}
+	if d.DeliveredAt.Valid {
+		delivered := d.DeliveredAt.Time.Format(time.RFC3339)
+		resp.DeliveredAt = &delivered
+	}
+
+	return resp
+}
@@ -1,28 +1,135 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"net/http"
 	"strings"
 )
 
-// GenerateAPIKey generates a new random API key
-// Returns the plaintext key (to show user once) and its hash (to store)
-func GenerateAPIKey() (plaintext, hash string, err error) {
-	buf := make([]byte, 32)
+// API keys look like eddc_<key ID>_<secret>_<checksum>. The prefix lets secret
+// scanners spot leaked keys, the key ID finds the row without scanning hashes, and
+// the CRC-32 checksum rejects typos and truncated keys without touching the database.
+const (
+	APIKeyPrefix = "eddc"
+
+	apiKeyIDBytes     = 8
+	apiKeySecretBytes = 32
+	// legacyAPIKeyLen is the length of keys made before the prefixed format:
+	// 32 random bytes, unpadded base64url
+	legacyAPIKeyLen = 43
+)
+
+// ErrMalformedAPIKey means a token can't be an API key, so there's no need to look it up
+var ErrMalformedAPIKey = errors.New("malformed API key")
+
+// apiKeyEncoding is lowercase base32 without padding, which never contains the "_"
+// separator and survives being double-clicked in a terminal
+var apiKeyEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ParsedAPIKey is a token split into its parts. Legacy keys have no key ID and are
+// looked up by their unsalted SHA-256 hash instead.
+type ParsedAPIKey struct {
+	KeyID  string
+	Secret string
+	Legacy bool
+	// token is the whole key, which is what legacy hashes cover
+	token string
+}
+
+// ParseAPIKey checks a token's format and checksum
+func ParseAPIKey(token string) (*ParsedAPIKey, error) {
+	if !strings.HasPrefix(token, APIKeyPrefix+"_") {
+		if len(token) == legacyAPIKeyLen {
+			if _, err := base64.RawURLEncoding.DecodeString(token); err == nil {
+				return &ParsedAPIKey{Legacy: true, token: token}, nil
+			}
+		}
+		return nil, ErrMalformedAPIKey
+	}
+
+	parts := strings.Split(token, "_")
+	if len(parts) != 4 {
+		return nil, ErrMalformedAPIKey
+	}
+	keyID, secret, checksum := parts[1], parts[2], parts[3]
+	if len(keyID) != apiKeyEncoding.EncodedLen(apiKeyIDBytes) || len(secret) != apiKeyEncoding.EncodedLen(apiKeySecretBytes) {
+		return nil, ErrMalformedAPIKey
+	}
+	if _, err := apiKeyEncoding.DecodeString(keyID); err != nil {
+		return nil, ErrMalformedAPIKey
+	}
+	if _, err := apiKeyEncoding.DecodeString(secret); err != nil {
+		return nil, ErrMalformedAPIKey
+	}
+	body := strings.TrimSuffix(token, "_"+checksum)
+	if checksum != apiKeyChecksum(body) {
+		return nil, ErrMalformedAPIKey
+	}
+	return &ParsedAPIKey{KeyID: keyID, Secret: secret, token: token}, nil
+}
+
+// LegacyHash is the stored hash to find a legacy key by. Prefixed keys are
+// found by ID instead.
+func (k *ParsedAPIKey) LegacyHash() string {
+	return HashAPIKey(k.token)
+}
+
+func apiKeyChecksum(body string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(body)))
+}
+
+// APIKeyHasher makes API keys and checks them against stored hashes. Secrets are
+// hashed with HMAC-SHA256 under a server-side pepper, so a copy of the database
+// alone isn't enough to test guesses.
+type APIKeyHasher struct {
+	pepper []byte
+}
+
+func NewAPIKeyHasher(pepper []byte) *APIKeyHasher {
+	return &APIKeyHasher{pepper: pepper}
+}
+
+// Generate makes a new API key. It returns the plaintext key (to show the user
+// once), the key ID to look it up by and the hash to store.
+func (h *APIKeyHasher) Generate() (plaintext, keyID, hash string, err error) {
+	buf := make([]byte, apiKeyIDBytes+apiKeySecretBytes)
 	if _, err := rand.Read(buf); err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 
-	plaintext = base64.RawURLEncoding.EncodeToString(buf)
-	hash = HashAPIKey(plaintext)
-	return plaintext, hash, nil
+	keyID = apiKeyEncoding.EncodeToString(buf[:apiKeyIDBytes])
+	secret := apiKeyEncoding.EncodeToString(buf[apiKeyIDBytes:])
+	body := APIKeyPrefix + "_" + keyID + "_" + secret
+	return body + "_" + apiKeyChecksum(body), keyID, h.hash(keyID, secret), nil
+}
+
+// Verify compares a parsed key against its stored hash in constant time
+func (h *APIKeyHasher) Verify(key *ParsedAPIKey, storedHash string) bool {
+	want := key.LegacyHash()
+	if !key.Legacy {
+		want = h.hash(key.KeyID, key.Secret)
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(storedHash)) == 1
+}
+
+// hash binds the secret to its key ID, so a row's hash can't be reused under another ID
+func (h *APIKeyHasher) hash(keyID, secret string) string {
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(keyID + ":" + secret))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-// HashAPIKey creates a SHA-256 hash of an API key for storage
+// HashAPIKey creates a SHA-256 hash of an API key for storage. Only legacy keys
+// are stored this way.
 func HashAPIKey(key string) string {
 	h := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(h[:])
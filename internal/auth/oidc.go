@@ -0,0 +1,420 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig says which identity provider's tokens to accept
+type OIDCConfig struct {
+	// Issuer must match the token's iss claim exactly
+	Issuer string
+	// Audience must be one of the token's aud values
+	Audience string
+	// JWKSURL is where the signing keys are published. If empty it's discovered from
+	// the issuer's /.well-known/openid-configuration.
+	JWKSURL string
+	// Leeway allows for clock skew when checking exp, nbf and iat
+	Leeway time.Duration
+	// JWKSCacheTTL is how long signing keys are used before they're fetched again
+	JWKSCacheTTL time.Duration
+	// Timeout bounds each call to the identity provider
+	Timeout time.Duration
+}
+
+// Defaults for fields left zero in OIDCConfig
+const (
+	defaultJWTLeeway    = time.Minute
+	defaultJWKSCacheTTL = time.Hour
+	defaultOIDCTimeout  = 5 * time.Second
+	// minJWKSRefresh stops tokens with made-up key IDs from hammering the provider
+	minJWKSRefresh = time.Minute
+)
+
+// ErrInvalidToken means a bearer token is a JWT but isn't valid for this server.
+// Verify wraps it with the reason.
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenClaims is what edd-compute uses from a verified token. The issuer and
+// subject together identify the caller; usernames in tokens can change, so they
+// aren't used.
+type TokenClaims struct {
+	Issuer  string
+	Subject string
+	// Scopes is the token's space-separated scope claim, if it has one
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// OIDCVerifier checks JWT bearer tokens against an OIDC provider's published keys.
+// RS256/384/512 and ES256/384 signatures are supported.
+type OIDCVerifier struct {
+	cfg        OIDCConfig
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// lastAttempt is when the last fetch finished, whether or not it worked, and
+	// lastErr why it failed. Fetches back off from it so a provider that's down
+	// doesn't hold up every request.
+	lastAttempt time.Time
+	lastErr     error
+	// fetching is the key set fetch in progress, if any, which other callers wait
+	// for instead of starting their own
+	fetching *jwksFetch
+}
+
+// jwksFetch is one fetch of the provider's key set
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func NewOIDCVerifier(cfg OIDCConfig) *OIDCVerifier {
+	if cfg.Leeway == 0 {
+		cfg.Leeway = defaultJWTLeeway
+	}
+	if cfg.JWKSCacheTTL == 0 {
+		cfg.JWKSCacheTTL = defaultJWKSCacheTTL
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultOIDCTimeout
+	}
+	return &OIDCVerifier{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		jwksURL:    cfg.JWKSURL,
+	}
+}
+
+// LooksLikeJWT reports whether a bearer token has the three dot-separated parts of
+// a JWT. API keys never contain dots.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks a token's signature, issuer, audience and validity period. Errors
+// wrapping ErrInvalidToken mean the token was rejected; others mean the signing keys
+// couldn't be fetched.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+
+	key, err := v.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, hash, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	return v.checkClaims(claims, time.Now())
+}
+
+// jwtHashes maps the supported signing algorithms to their hash
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+}
+
+// ecCurves is the curve each EC algorithm signs with, and ecAlgorithms the reverse
+// by JWK curve name. An ES256 token must come from a P-256 key and so on.
+var (
+	ecCurves = map[string]elliptic.Curve{
+		"ES256": elliptic.P256(),
+		"ES384": elliptic.P384(),
+	}
+	ecAlgorithms = map[string]string{
+		"P-256": "ES256",
+		"P-384": "ES384",
+	}
+)
+
+func verifyJWTSignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed string, signature []byte) error {
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("%s token signed with an RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+			return fmt.Errorf("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		if ecCurves[alg] != key.Curve {
+			return fmt.Errorf("%s token signed with a %s key", alg, key.Curve.Params().Name)
+		}
+		// JWS EC signatures are r and s concatenated, each the size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("bad signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+func (v *OIDCVerifier) checkClaims(claims map[string]any, now time.Time) (*TokenClaims, error) {
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, iss)
+	}
+
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	if !slices.Contains(audiences, v.cfg.Audience) {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidToken, audiences)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	expiresAt := time.Unix(int64(exp), 0)
+	if now.After(expiresAt.Add(v.cfg.Leeway)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(v.cfg.Leeway).Before(time.Unix(int64(iat), 0)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, fmt.Errorf("%w: no sub claim", ErrInvalidToken)
+	}
+
+	scope, _ := claims["scope"].(string)
+	return &TokenClaims{
+		Issuer:    v.cfg.Issuer,
+		Subject:   sub,
+		Scopes:    strings.Fields(scope),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func decodeJWTPart(part string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// signingKey returns the key with the given ID, fetching the key set if it's stale
+// or doesn't have the key yet
+func (v *OIDCVerifier) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	now := time.Now()
+	key, ok := v.keys[kid]
+	stale := now.Sub(v.fetchedAt) > v.cfg.JWKSCacheTTL
+	// A new key ID usually means the provider rotated its keys
+	refresh := (stale || !ok) && now.Sub(v.lastAttempt) > minJWKSRefresh
+	haveKeys, err := v.keys != nil, v.lastErr
+	v.mu.Unlock()
+
+	if refresh {
+		err = v.refreshKeys(ctx)
+		v.mu.Lock()
+		haveKeys = v.keys != nil
+		key, ok = v.keys[kid]
+		v.mu.Unlock()
+	}
+	// Otherwise keep using the keys we have until the provider is back
+	if err != nil && !haveKeys {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// refreshKeys fetches the provider's key set without holding v.mu, so requests
+// with cached keys aren't held up by a slow provider. Concurrent callers share one
+// fetch.
+func (v *OIDCVerifier) refreshKeys(ctx context.Context) error {
+	v.mu.Lock()
+	if f := v.fetching; f != nil {
+		v.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	v.fetching = f
+	jwksURL := v.jwksURL
+	v.mu.Unlock()
+
+	// The fetch is shared, so one caller giving up mustn't cancel it for the rest;
+	// httpClient's timeout still bounds it
+	keys, jwksURL, err := v.fetchKeys(context.WithoutCancel(ctx), jwksURL)
+
+	v.mu.Lock()
+	v.lastAttempt, v.lastErr = time.Now(), err
+	if err == nil {
+		v.keys, v.fetchedAt, v.jwksURL = keys, v.lastAttempt, jwksURL
+	}
+	v.fetching = nil
+	v.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+	return err
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+// fetchKeys downloads the provider's signing keys from jwksURL, discovering the
+// URL from the issuer first if it's empty. It returns the URL it used.
+func (v *OIDCVerifier) fetchKeys(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, string, error) {
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, strings.TrimSuffix(v.cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, "", fmt.Errorf("oidc discovery: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, "", fmt.Errorf("oidc discovery: no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set jwks
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, "", fmt.Errorf("fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			alg, ok := ecAlgorithms[k.Crv]
+			if !ok || (k.Alg != "" && k.Alg != alg) {
+				continue
+			}
+			curve := ecCurves[alg]
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			// Rejects points that aren't on the curve
+			if _, err := key.ECDH(); err != nil {
+				continue
+			}
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, "", fmt.Errorf("fetch jwks: no usable signing keys")
+	}
+	return keys, jwksURL, nil
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "edd-compute"
+)
+
+// testProvider is an identity provider serving a JWKS with one RSA and one P-256 key
+type testProvider struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	fetches atomic.Int32
+
+	mu   sync.Mutex
+	kids []string
+	down bool
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{rsaKey: rsaKey, ecKey: ecKey, kids: []string{"rsa-1", "ec-1"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": p.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.fetches.Add(1)
+		p.mu.Lock()
+		down, kids := p.down, p.kids
+		p.mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{
+			map[string]string{
+				"kty": "RSA", "kid": kids[0], "use": "sig",
+				"n": b64(rsaKey.N.Bytes()),
+				"e": b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			map[string]string{
+				"kty": "EC", "kid": kids[1], "crv": "P-256",
+				"x": b64(ecKey.X.FillBytes(make([]byte, 32))),
+				"y": b64(ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+		}})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *testProvider) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+}
+
+func (p *testProvider) setKIDs(rsaKID, ecKID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.kids = []string{rsaKID, ecKID}
+}
+
+// sign makes a token for claims, filling in a valid iss, aud, sub and exp unless
+// claims sets them
+func (p *testProvider) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	full := map[string]any{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(full)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, p.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, p.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		t.Fatalf("can't sign %s", alg)
+	}
+	return signed + "." + b64(sig)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (p *testProvider) verifier(cfg OIDCConfig) *OIDCVerifier {
+	if cfg.Issuer == "" {
+		cfg.Issuer = testIssuer
+	}
+	cfg.Audience = testAudience
+	if cfg.JWKSURL == "" {
+		cfg.JWKSURL = p.server.URL + "/jwks"
+	}
+	return NewOIDCVerifier(cfg)
+}
+
+// expireFetch makes the verifier's last fetch look older than the refresh backoff
+func expireFetch(v *OIDCVerifier) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lastAttempt = v.lastAttempt.Add(-2 * minJWKSRefresh)
+	v.fetchedAt = v.fetchedAt.Add(-2 * minJWKSRefresh)
+}
+
+func TestVerify(t *testing.T) {
+	p := newTestProvider(t)
+	v := p.verifier(OIDCConfig{})
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"RS256", p.sign(t, "RS256", "rsa-1", nil), false},
+		{"ES256", p.sign(t, "ES256", "ec-1", nil), false},
+		{"audience list", p.sign(t, "RS256", "rsa-1", map[string]any{"aud": []string{"other", testAudience}}), false},
+		{"wrong issuer", p.sign(t, "RS256", "rsa-1", map[string]any{"iss": "https://evil.example.com"}), true},
+		{"wrong audience", p.sign(t, "RS256", "rsa-1", map[string]any{"aud": "other"}), true},
+		{"expired", p.sign(t, "RS256", "rsa-1", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}), true},
+		{"within leeway", p.sign(t, "RS256", "rsa-1", map[string]any{"exp": time.Now().Add(-30 * time.Second).Unix()}), false},
+		{"not valid yet", p.sign(t, "RS256", "rsa-1", map[string]any{"nbf": time.Now().Add(time.Hour).Unix()}), true},
+		{"no subject", p.sign(t, "RS256", "rsa-1", map[string]any{"sub": ""}), true},
+		{"ES256 claiming the RSA key", p.sign(t, "ES256", "rsa-1", nil), true},
+		{"RS256 claiming the EC key", p.sign(t, "RS256", "ec-1", nil), true},
+		{"unsigned", b64([]byte(`{"alg":"none"}`)) + "." + strings.Split(p.sign(t, "RS256", "rsa-1", nil), ".")[1] + ".", true},
+		{"not a JWT", "abc.def", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Verify(ctx, tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if claims.Issuer != testIssuer || claims.Subject != "user-1" {
+				t.Errorf("Verify() = %+v, want %s user-1", claims, testIssuer)
+			}
+		})
+	}
+}
+
+func TestVerifyBadSignature(t *testing.T) {
+	p := newTestProvider(t)
+	v := p.verifier(OIDCConfig{})
+
+	for _, alg := range []string{"RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			kid := map[string]string{"RS256": "rsa-1", "ES256": "ec-1"}[alg]
+			token := p.sign(t, alg, kid, nil)
+			// Swap in the claims of a token for someone else
+			other := strings.Split(p.sign(t, alg, kid, map[string]any{"sub": "admin"}), ".")
+			parts := strings.Split(token, ".")
+			forged := parts[0] + "." + other[1] + "." + parts[2]
+
+			if _, err := v.Verify(context.Background(), forged); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyDiscovery(t *testing.T) {
+	p := newTestProvider(t)
+	v := NewOIDCVerifier(OIDCConfig{Issuer: p.server.URL, Audience: testAudience})
+
+	token := p.sign(t, "RS256", "rsa-1", map[string]any{"iss": p.server.URL})
+	claims, err := v.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if claims.Issuer != p.server.URL {
+		t.Errorf("Issuer = %q, want %q", claims.Issuer, p.server.URL)
+	}
+}
+
+func TestVerifyUnknownKeyRefetches(t *testing.T) {
+	p := newTestProvider(t)
+	v := p.verifier(OIDCConfig{})
+	ctx := context.Background()
+
+	if _, err := v.Verify(ctx, p.sign(t, "RS256", "rsa-1", nil)); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	// The provider rotates its keys
+	p.setKIDs("rsa-2", "ec-2")
+	rotated := p.sign(t, "RS256", "rsa-2", nil)
+
+	// Too soon after the last fetch to try again
+	if _, err := v.Verify(ctx, rotated); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+	}
+	if n := p.fetches.Load(); n != 1 {
+		t.Fatalf("%d fetches, want 1", n)
+	}
+
+	expireFetch(v)
+	if _, err := v.Verify(ctx, rotated); err != nil {
+		t.Fatalf("Verify() after rotation error = %v", err)
+	}
+	if n := p.fetches.Load(); n != 2 {
+		t.Errorf("%d fetches, want 2", n)
+	}
+}
+
+func TestVerifyProviderDown(t *testing.T) {
+	p := newTestProvider(t)
+	v := p.verifier(OIDCConfig{JWKSCacheTTL: time.Minute})
+	ctx := context.Background()
+	token := p.sign(t, "RS256", "rsa-1", nil)
+
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	p.setDown(true)
+	expireFetch(v)
+
+	// The cached keys are stale, but still used while the provider is down
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatalf("Verify() with the provider down error = %v", err)
+	}
+	if n := p.fetches.Load(); n != 2 {
+		t.Fatalf("%d fetches, want 2", n)
+	}
+
+	// The failed fetch backs off instead of being retried on every request
+	for i := 0; i < 5; i++ {
+		if _, err := v.Verify(ctx, token); err != nil {
+			t.Fatalf("Verify() with the provider down error = %v", err)
+		}
+	}
+	if n := p.fetches.Load(); n != 2 {
+		t.Errorf("%d fetches, want 2", n)
+	}
+}
+
+func TestVerifyProviderDownWithoutKeys(t *testing.T) {
+	p := newTestProvider(t)
+	p.setDown(true)
+	v := p.verifier(OIDCConfig{})
+	ctx := context.Background()
+	token := p.sign(t, "RS256", "rsa-1", nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := v.Verify(ctx, token)
+		if err == nil || errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Verify() error = %v, want a fetch error", err)
+		}
+	}
+	if n := p.fetches.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+}
+
+func TestVerifyCoalescesFetches(t *testing.T) {
+	p := newTestProvider(t)
+	v := p.verifier(OIDCConfig{})
+	token := p.sign(t, "ES256", "ec-1", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.Verify(context.Background(), token); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := p.fetches.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+}
+
+func TestFetchKeysChecksECAlgorithm(t *testing.T) {
+	p := newTestProvider(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{
+			map[string]string{
+				"kty": "EC", "kid": "ec-1", "crv": "P-256", "alg": "ES384",
+				"x": b64(p.ecKey.X.FillBytes(make([]byte, 32))),
+				"y": b64(p.ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+		}})
+	}))
+	defer server.Close()
+
+	v := p.verifier(OIDCConfig{JWKSURL: server.URL})
+	if _, _, err := v.fetchKeys(context.Background(), server.URL); err == nil {
+		t.Error("fetchKeys() accepted a P-256 key marked ES384")
+	}
+}
@@ -1,45 +1,221 @@
 package auth
 
 import (
+	"container/list"
+	"crypto/sha256"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// SessionConfig says where to validate SFS sessions and how long to cache the answers
+type SessionConfig struct {
+	// URL is the SFS base URL, e.g. http://simple-file-share-backend
+	URL        string
+	CookieName string
+	// Timeout bounds each call to SFS
+	Timeout time.Duration
+	// CacheTTL is how long a valid session is trusted without asking SFS again
+	CacheTTL time.Duration
+	// NegativeTTL is how long an invalid session is remembered as invalid
+	NegativeTTL time.Duration
+	// MaxStale is how long past CacheTTL a valid session is still accepted while
+	// SFS is failing. Zero disables serving stale sessions.
+	MaxStale time.Duration
+	// CacheSize bounds the number of cached sessions; the least recently used go first
+	CacheSize int
+}
+
+// DefaultSessionConfig is how edd-compute talks to SFS inside the cluster
+var DefaultSessionConfig = SessionConfig{
+	URL:         "http://simple-file-share-backend",
+	CookieName:  "sfs_session",
+	Timeout:     5 * time.Second,
+	CacheTTL:    time.Minute,
+	NegativeTTL: 5 * time.Second,
+	MaxStale:    5 * time.Minute,
+	CacheSize:   10000,
+}
+
+// sessionMetrics are published at /debug/vars under "session_validator"
+var (
+	sessionMetrics          = expvar.NewMap("session_validator")
+	sessionCacheHits        = new(expvar.Int)
+	sessionCacheMisses      = new(expvar.Int)
+	sessionNegativeHits     = new(expvar.Int)
+	sessionStaleHits        = new(expvar.Int)
+	sessionSharedLookups    = new(expvar.Int)
+	sessionUpstreamRequests = new(expvar.Int)
+	sessionUpstreamErrors   = new(expvar.Int)
+	sessionUpstreamSeconds  = new(expvar.Float)
+)
+
+func init() {
+	sessionMetrics.Set("cache_hits", sessionCacheHits)
+	sessionMetrics.Set("cache_misses", sessionCacheMisses)
+	sessionMetrics.Set("negative_hits", sessionNegativeHits)
+	sessionMetrics.Set("stale_hits", sessionStaleHits)
+	sessionMetrics.Set("shared_lookups", sessionSharedLookups)
+	sessionMetrics.Set("upstream_requests", sessionUpstreamRequests)
+	sessionMetrics.Set("upstream_errors", sessionUpstreamErrors)
+	sessionMetrics.Set("upstream_seconds_total", sessionUpstreamSeconds)
+	sessionMetrics.Set("hit_rate", expvar.Func(func() any {
+		hits := sessionCacheHits.Value() + sessionNegativeHits.Value()
+		if total := hits + sessionCacheMisses.Value(); total > 0 {
+			return float64(hits) / float64(total)
+		}
+		return 0.0
+	}))
+}
+
+// SessionValidator checks SFS session cookies. Answers are cached, and concurrent
+// lookups of the same token share one call to SFS, so a slow SFS doesn't hold up
+// every request.
 type SessionValidator struct {
-	sfsURL     string
+	cfg        SessionConfig
 	httpClient *http.Client
+
+	mu      sync.Mutex
+	cache   map[[sha256.Size]byte]*list.Element
+	lru     *list.List // of *sessionEntry, most recently used first
+	pending map[[sha256.Size]byte]*sessionLookup
+}
+
+type sessionEntry struct {
+	key      [sha256.Size]byte
+	username string // empty for an invalid session
+	expires  time.Time
+}
+
+// sessionLookup is a call to SFS that other requests for the same token wait on
+type sessionLookup struct {
+	done     chan struct{}
+	username string
+	err      error
 }
 
 type sessionResponse struct {
 	Username string `json:"username"`
 }
 
-func NewSessionValidator(sfsURL string) *SessionValidator {
+func NewSessionValidator(cfg SessionConfig) *SessionValidator {
 	return &SessionValidator{
-		sfsURL: sfsURL,
+		cfg: cfg,
 		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
+			Timeout: cfg.Timeout,
 		},
+		cache:   make(map[[sha256.Size]byte]*list.Element),
+		lru:     list.New(),
+		pending: make(map[[sha256.Size]byte]*sessionLookup),
 	}
 }
 
 // ValidateSession validates a session cookie by calling SFS /api/session
 // Returns the username if valid, empty string if invalid
 func (v *SessionValidator) ValidateSession(sessionToken string) (string, error) {
-	req, err := http.NewRequest("GET", v.sfsURL+"/api/session", nil)
+	// Tokens are only held in memory hashed
+	key := sha256.Sum256([]byte(sessionToken))
+	now := time.Now()
+
+	v.mu.Lock()
+	var expired sessionEntry
+	if entry := v.cached(key); entry != nil {
+		if now.Before(entry.expires) {
+			username := entry.username
+			v.mu.Unlock()
+			if username == "" {
+				sessionNegativeHits.Add(1)
+			} else {
+				sessionCacheHits.Add(1)
+			}
+			return username, nil
+		}
+		expired = *entry
+	}
+	sessionCacheMisses.Add(1)
+
+	if lookup, ok := v.pending[key]; ok {
+		v.mu.Unlock()
+		sessionSharedLookups.Add(1)
+		<-lookup.done
+		return lookup.username, lookup.err
+	}
+	lookup := &sessionLookup{done: make(chan struct{})}
+	v.pending[key] = lookup
+	v.mu.Unlock()
+
+	lookup.username, lookup.err = v.fetchSession(sessionToken)
+
+	v.mu.Lock()
+	delete(v.pending, key)
+	switch {
+	case lookup.err == nil && lookup.username != "":
+		v.store(key, lookup.username, now.Add(v.cfg.CacheTTL))
+	case lookup.err == nil:
+		v.store(key, "", now.Add(v.cfg.NegativeTTL))
+	case expired.username != "" && now.Before(expired.expires.Add(v.cfg.MaxStale)):
+		// SFS is failing; a session it vouched for recently is better than an outage
+		sessionStaleHits.Add(1)
+		lookup.username, lookup.err = expired.username, nil
+	}
+	v.mu.Unlock()
+	close(lookup.done)
+
+	return lookup.username, lookup.err
+}
+
+// cached returns the entry for key, if any, marking it recently used. v.mu must be held.
+func (v *SessionValidator) cached(key [sha256.Size]byte) *sessionEntry {
+	elem, ok := v.cache[key]
+	if !ok {
+		return nil
+	}
+	v.lru.MoveToFront(elem)
+	return elem.Value.(*sessionEntry)
+}
+
+// store caches a lookup, evicting the least recently used entry if the cache is
+// full. v.mu must be held.
+func (v *SessionValidator) store(key [sha256.Size]byte, username string, expires time.Time) {
+	if elem, ok := v.cache[key]; ok {
+		entry := elem.Value.(*sessionEntry)
+		entry.username, entry.expires = username, expires
+		v.lru.MoveToFront(elem)
+		return
+	}
+	if v.cfg.CacheSize <= 0 {
+		return
+	}
+	for v.lru.Len() >= v.cfg.CacheSize {
+		oldest := v.lru.Back()
+		delete(v.cache, oldest.Value.(*sessionEntry).key)
+		v.lru.Remove(oldest)
+	}
+	v.cache[key] = v.lru.PushFront(&sessionEntry{key: key, username: username, expires: expires})
+}
+
+// fetchSession asks SFS who a session belongs to
+func (v *SessionValidator) fetchSession(sessionToken string) (string, error) {
+	req, err := http.NewRequest("GET", v.cfg.URL+"/api/session", nil)
 	if err != nil {
 		return "", fmt.Errorf("create request: %w", err)
 	}
 
 	req.AddCookie(&http.Cookie{
-		Name:  "sfs_session",
+		Name:  v.cfg.CookieName,
 		Value: sessionToken,
 	})
 
+	start := time.Now()
+	sessionUpstreamRequests.Add(1)
+	defer func() { sessionUpstreamSeconds.Add(time.Since(start).Seconds()) }()
+
 	resp, err := v.httpClient.Do(req)
 	if err != nil {
+		sessionUpstreamErrors.Add(1)
 		return "", fmt.Errorf("call sfs: %w", err)
 	}
 	defer resp.Body.Close()
@@ -48,20 +224,22 @@ func (v *SessionValidator) ValidateSession(sessionToken string) (string, error)
 		return "", nil
 	}
 	if resp.StatusCode != http.StatusOK {
+		sessionUpstreamErrors.Add(1)
 		return "", fmt.Errorf("sfs returned status %d", resp.StatusCode)
 	}
 
 	var session sessionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		sessionUpstreamErrors.Add(1)
 		return "", fmt.Errorf("decode response: %w", err)
 	}
 
 	return session.Username, nil
 }
 
-// GetSessionToken extracts the session token from the request cookie
-func GetSessionToken(r *http.Request) string {
-	cookie, err := r.Cookie("sfs_session")
+// SessionToken extracts the session token from the request cookie
+func (v *SessionValidator) SessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(v.cfg.CookieName)
 	if err != nil {
 		return ""
 	}
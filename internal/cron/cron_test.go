@@ -0,0 +1,248 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ""},
+		{"too few fields", "* * * *"},
+		{"too many fields", "* * * * * *"},
+		{"minute out of range", "60 * * * *"},
+		{"hour out of range", "0 24 * * *"},
+		{"day of month zero", "0 0 0 * *"},
+		{"day of month out of range", "0 0 32 * *"},
+		{"month out of range", "0 0 1 13 *"},
+		{"day of week out of range", "0 0 * * 8"},
+		{"negative value", "-1 * * * *"},
+		{"reversed range", "0 0 * * 5-1"},
+		{"zero step", "*/0 * * * *"},
+		{"non-numeric step", "*/x * * * *"},
+		{"unknown name", "0 0 * * fun"},
+		{"month name in day field", "0 0 * * jan"},
+		{"empty list item", "1,,2 * * * *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.expr); err == nil {
+				t.Errorf("Parse(%q) succeeded, want error", tt.expr)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			from: time.Date(2026, 1, 1, 10, 0, 30, 0, time.UTC),
+			want: time.Date(2026, 1, 1, 10, 1, 0, 0, time.UTC),
+		},
+		{
+			name: "strictly after an exact match",
+			expr: "0 8 * * *",
+			from: time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			from: time.Date(2026, 1, 1, 10, 16, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "step from a start value",
+			expr: "5/20 * * * *",
+			from: time.Date(2026, 1, 1, 10, 26, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 1, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "list",
+			expr: "0 9,17 * * *",
+			from: time.Date(2026, 1, 1, 9, 30, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 1, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekday names skip the weekend",
+			expr: "0 8 * * MON-FRI",
+			// Friday
+			from: time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "month names",
+			expr: "0 0 1 jun *",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 0",
+			expr: "0 12 * * 0",
+			// Monday
+			from: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 12 * * 7",
+			from: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "range ending at 7 includes sunday",
+			expr: "0 12 * * 6-7",
+			// Sunday, after noon
+			from: time.Date(2026, 1, 11, 13, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "restricted day fields match either",
+			expr: "0 0 15 * MON",
+			// Tuesday the 6th: Monday the 12th comes before the 15th
+			from: time.Date(2026, 1, 6, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month with unrestricted day of week",
+			expr: "0 0 15 * *",
+			from: time.Date(2026, 1, 6, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "skips months without the day",
+			expr: "0 0 31 * *",
+			from: time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "year rollover",
+			expr: "0 0 1 1 *",
+			from: time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC),
+			want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "never matches",
+			expr: "0 0 30 2 *",
+			from: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Time{},
+		},
+		{
+			name: "keeps the caller's location",
+			expr: "0 8 * * *",
+			from: time.Date(2026, 1, 1, 9, 0, 0, 0, newYork),
+			want: time.Date(2026, 1, 2, 8, 0, 0, 0, newYork),
+		},
+		{
+			// 02:00-03:00 doesn't exist on 2026-03-08 in New York
+			name: "skipped hour on spring forward",
+			expr: "30 2 * * *",
+			from: time.Date(2026, 3, 8, 0, 0, 0, 0, newYork),
+			want: time.Date(2026, 3, 9, 2, 30, 0, 0, newYork),
+		},
+		{
+			name: "hour after spring forward",
+			expr: "0 3 * * *",
+			from: time.Date(2026, 3, 8, 0, 0, 0, 0, newYork),
+			want: time.Date(2026, 3, 8, 3, 0, 0, 0, newYork),
+		},
+		{
+			name: "wall clock kept across spring forward",
+			expr: "0 8 * * *",
+			from: time.Date(2026, 3, 7, 9, 0, 0, 0, newYork),
+			want: time.Date(2026, 3, 8, 8, 0, 0, 0, newYork),
+		},
+		{
+			name: "wall clock kept across fall back",
+			expr: "0 8 * * *",
+			from: time.Date(2026, 10, 31, 9, 0, 0, 0, newYork),
+			want: time.Date(2026, 11, 1, 8, 0, 0, 0, newYork),
+		},
+		{
+			// 01:00-02:00 happens twice on 2026-11-01 in New York; time.Date
+			// picks the first (EDT) occurrence
+			name: "repeated hour on fall back",
+			expr: "30 1 * * *",
+			from: time.Date(2026, 11, 1, 0, 0, 0, 0, newYork),
+			want: time.Date(2026, 11, 1, 1, 30, 0, 0, newYork),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			got := s.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Errorf("Next(%s) returned location %s, want %s", tt.from, got.Location(), tt.from.Location())
+			}
+		})
+	}
+}
+
+func TestNextSkippedMidnight(t *testing.T) {
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	s, err := Parse("0 0 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chile springs forward at midnight, so 2026-09-06 starts at 01:00
+	from := time.Date(2026, 9, 5, 12, 0, 0, 0, santiago)
+	got := s.Next(from)
+	want := time.Date(2026, 9, 7, 0, 0, 0, 0, santiago)
+	if !got.Equal(want) {
+		t.Errorf("Next(%s) = %s, want %s", from, got, want)
+	}
+}
+
+func TestNextHourlyAcrossFallBack(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	s, err := Parse("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each run is an hour of real time apart, even through the repeated hour
+	at := time.Date(2026, 11, 1, 0, 0, 0, 0, newYork)
+	for i := 0; i < 4; i++ {
+		next := s.Next(at)
+		if d := next.Sub(at); d != time.Hour {
+			t.Fatalf("Next(%s) = %s, %s later; want 1h", at, next, d)
+		}
+		at = next
+	}
+}
@@ -3,41 +3,180 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// API key states, see APIKey.Status
+const (
+	APIKeyActive = "active"
+	// APIKeyRotated keys have a replacement and stay valid until their grace period ends
+	APIKeyRotated = "rotated"
+	APIKeyExpired = "expired"
+	APIKeyRevoked = "revoked"
+)
+
 type APIKey struct {
-	ID        int64
-	UserID    int64
-	KeyHash   string
-	Name      string
-	CreatedAt time.Time
-	LastUsed  sql.NullTime
+	ID int64
+	// UserID is the user who created the key. Keys an org owns (OrgID is set) act for
+	// the org, with no more access than their creator has in it.
+	UserID int64
+	OrgID  sql.NullInt64
+	// KeyID is the public part of the key it's looked up by. Keys from before the
+	// eddc_ format have none and are looked up by KeyHash.
+	KeyID   sql.NullString
+	KeyHash string
+	Name    string
+	// Scopes limits what the key can do; keys from before scopes existed have "*"
+	Scopes []string
+	// ContainerIDs restricts the key to these containers; nil means all of the user's containers
+	ContainerIDs []string
+	CreatedAt    time.Time
+	LastUsed     sql.NullTime
+	ExpiresAt    sql.NullTime
+	// RevokedAt is set instead of deleting the key, so there's a record of it
+	RevokedAt     sql.NullTime
+	RevokedReason sql.NullString
+	// ReplacedBy is the key issued when this one was rotated
+	ReplacedBy sql.NullInt64
+}
+
+// Status reports whether the key is active, rotated, expired or revoked at now
+func (k *APIKey) Status(now time.Time) string {
+	switch {
+	case k.RevokedAt.Valid:
+		return APIKeyRevoked
+	case k.ExpiresAt.Valid && !k.ExpiresAt.Time.After(now):
+		return APIKeyExpired
+	case k.ReplacedBy.Valid:
+		return APIKeyRotated
+	default:
+		return APIKeyActive
+	}
 }
 
-func (db *DB) CreateAPIKey(key *APIKey) error {
+// Usable reports whether the key can authenticate requests at now
+func (k *APIKey) Usable(now time.Time) bool {
+	status := k.Status(now)
+	return status == APIKeyActive || status == APIKeyRotated
+}
+
+const apiKeyColumns = `id, user_id, key_id, key_hash, name, scopes, container_ids, created_at, last_used, expires_at, revoked_at, revoked_reason, replaced_by, org_id`
+
+func scanAPIKey(s scanner) (*APIKey, error) {
+	key := &APIKey{}
+	var scopes string
+	var containerIDs sql.NullString
+	if err := s.Scan(&key.ID, &key.UserID, &key.KeyID, &key.KeyHash, &key.Name, &scopes, &containerIDs, &key.CreatedAt, &key.LastUsed,
+		&key.ExpiresAt, &key.RevokedAt, &key.RevokedReason, &key.ReplacedBy, &key.OrgID); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	if containerIDs.Valid {
+		key.ContainerIDs = []string{}
+		if containerIDs.String != "" {
+			key.ContainerIDs = strings.Split(containerIDs.String, ",")
+		}
+	}
+	return key, nil
+}
+
+// Owner is the org the key belongs to, or else the user who created it
+func (k *APIKey) Owner() Owner {
+	return ownerOf(k.UserID, k.OrgID)
+}
+
+// liveAPIKeyCondition matches keys that count towards the owner's limit: not revoked,
+// expired or replaced by a rotation
+const liveAPIKeyCondition = `revoked_at IS NULL AND replaced_by IS NULL AND (expires_at IS NULL OR expires_at > ?)`
+
+// CreateAPIKey inserts a key, or returns ErrLimitExceeded if the user creating it
+// already has limit live keys, counting those they made for their orgs
+func (db *DB) CreateAPIKey(key *APIKey, limit int) error {
+	cond, condArgs := quotaCondition(key.UserID)
+	args := []any{key.UserID, key.OrgID, key.KeyID, key.KeyHash, key.Name, strings.Join(key.Scopes, ","), apiKeyContainerIDs(key), nullTime(key.ExpiresAt)}
 	result, err := db.Exec(`
-		INSERT INTO api_keys (user_id, key_hash, name)
-		VALUES (?, ?, ?)`,
-		key.UserID, key.KeyHash, key.Name,
+		INSERT INTO api_keys (user_id, org_id, key_id, key_hash, name, scopes, container_ids, expires_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM api_keys WHERE `+cond+` AND `+liveAPIKeyCondition+`) < ?`,
+		append(append(args, condArgs...), sqlTime(time.Now()), limit)...,
 	)
 	if err != nil {
 		return fmt.Errorf("insert api key: %w", err)
 	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("api keys: %w", ErrLimitExceeded)
+	}
 	id, err := result.LastInsertId()
 	if err != nil {
 		return fmt.Errorf("get last insert id: %w", err)
 	}
 	key.ID = id
+	key.CreatedAt = time.Now().UTC()
 	return nil
 }
 
-func (db *DB) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
-	key := &APIKey{}
-	err := db.QueryRow(`
-		SELECT id, user_id, key_hash, name, created_at, last_used
-		FROM api_keys WHERE key_hash = ?`, keyHash,
-	).Scan(&key.ID, &key.UserID, &key.KeyHash, &key.Name, &key.CreatedAt, &key.LastUsed)
+// RotateAPIKey inserts replacement for old and has old expire at graceUntil, or
+// sooner if it was already due to. Returns ErrConflict if old has been revoked or
+// rotated in the meantime. The replacement doesn't count against the key limit,
+// since it takes old's place.
+func (db *DB) RotateAPIKey(old, replacement *APIKey, graceUntil time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO api_keys (user_id, org_id, key_id, key_hash, name, scopes, container_ids, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		replacement.UserID, replacement.OrgID, replacement.KeyID, replacement.KeyHash, replacement.Name, strings.Join(replacement.Scopes, ","),
+		apiKeyContainerIDs(replacement), nullTime(replacement.ExpiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("insert api key: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+
+	grace := sqlTime(graceUntil)
+	result, err = tx.Exec(`
+		UPDATE api_keys SET replaced_by = ?, expires_at = MIN(COALESCE(expires_at, ?), ?)
+		WHERE id = ? AND revoked_at IS NULL AND replaced_by IS NULL`,
+		id, grace, grace, old.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update rotated api key: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("api key %d was revoked or rotated: %w", old.ID, ErrConflict)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	replacement.ID = id
+	old.ReplacedBy = sql.NullInt64{Int64: id, Valid: true}
+	if !old.ExpiresAt.Valid || old.ExpiresAt.Time.After(graceUntil) {
+		old.ExpiresAt = sql.NullTime{Time: graceUntil.UTC(), Valid: true}
+	}
+	return nil
+}
+
+func apiKeyContainerIDs(key *APIKey) sql.NullString {
+	if key.ContainerIDs == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strings.Join(key.ContainerIDs, ","), Valid: true}
+}
+
+// GetAPIKey returns the owner's key with the given ID, or nil if there isn't one
+func (db *DB) GetAPIKey(id int64, owner Owner) (*APIKey, error) {
+	cond, args := owner.condition()
+	key, err := scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ? AND `+cond, append([]any{id}, args...)...))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -47,25 +186,58 @@ func (db *DB) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
 	return key, nil
 }
 
-func (db *DB) ListAPIKeysByUser(userID int64) ([]*APIKey, error) {
-	rows, err := db.Query(`
-		SELECT id, user_id, key_hash, name, created_at, last_used
-		FROM api_keys WHERE user_id = ? ORDER BY created_at DESC`, userID,
-	)
+// GetAPIKeyByKeyID returns the key with the given public key ID, or nil if there isn't one
+func (db *DB) GetAPIKeyByKeyID(keyID string) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_id = ?`, keyID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("query api keys: %w", err)
+		return nil, fmt.Errorf("query api key: %w", err)
+	}
+	return key, nil
+}
+
+// GetLegacyAPIKeyByHash returns the key from before the eddc_ format with the given
+// SHA-256 hash, or nil if there isn't one
+func (db *DB) GetLegacyAPIKeyByHash(keyHash string) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ? AND key_id IS NULL`, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query api key: %w", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeysByOwner returns a page of the owner's API keys and the cursor for the next page, if any
+func (db *DB) ListAPIKeysByOwner(owner Owner, opts ListOptions) ([]*APIKey, *Cursor, error) {
+	cond, condArgs := owner.condition()
+	clauses, args, err := opts.listQuery([]string{cond}, condArgs, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Query(`SELECT `+apiKeyColumns+` FROM api_keys`+clauses, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query api keys: %w", err)
 	}
 	defer rows.Close()
 
 	var keys []*APIKey
 	for rows.Next() {
-		key := &APIKey{}
-		if err := rows.Scan(&key.ID, &key.UserID, &key.KeyHash, &key.Name, &key.CreatedAt, &key.LastUsed); err != nil {
-			return nil, fmt.Errorf("scan api key: %w", err)
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scan api key: %w", err)
 		}
 		keys = append(keys, key)
 	}
-	return keys, nil
+
+	keys, next := page(&opts, keys, func(k *APIKey) (string, string, time.Time) {
+		return strconv.FormatInt(k.ID, 10), k.Name, k.CreatedAt
+	})
+	return keys, next, nil
 }
 
 func (db *DB) UpdateAPIKeyLastUsed(id int64) error {
@@ -76,23 +248,21 @@ func (db *DB) UpdateAPIKeyLastUsed(id int64) error {
 	return nil
 }
 
-func (db *DB) DeleteAPIKey(id int64, userID int64) error {
-	result, err := db.Exec(`DELETE FROM api_keys WHERE id = ? AND user_id = ?`, id, userID)
+// RevokeAPIKey stops a key from working and records why. The row is kept as a record
+// of the key. Returns ErrNotFound if the owner has no such key, or it's already revoked.
+func (db *DB) RevokeAPIKey(id int64, owner Owner, reason string) error {
+	cond, args := owner.condition()
+	result, err := db.Exec(`
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = ?
+		WHERE id = ? AND `+cond+` AND revoked_at IS NULL`,
+		append([]any{sql.NullString{String: reason, Valid: reason != ""}, id}, args...)...,
+	)
 	if err != nil {
-		return fmt.Errorf("delete api key: %w", err)
+		return fmt.Errorf("revoke api key: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("api key not found or not owned by user")
+		return fmt.Errorf("api key %d: %w", id, ErrNotFound)
 	}
 	return nil
 }
-
-func (db *DB) CountAPIKeysByUser(userID int64) (int, error) {
-	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM api_keys WHERE user_id = ?`, userID).Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("count api keys: %w", err)
-	}
-	return count, nil
-}
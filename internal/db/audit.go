@@ -0,0 +1,132 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit entry results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEntry records a mutating API request: who made it, from where, what it
+// did and how it went. Entries are never changed once written.
+type AuditEntry struct {
+	ID int64
+	// UserID is unset if the request was turned away before the caller was known
+	UserID     sql.NullInt64
+	Username   string
+	AuthMethod string
+	APIKeyID   sql.NullInt64
+	SourceIP   string
+	// Action is the route that handled the request, e.g. "DELETE /containers/{id}"
+	Action     string
+	TargetType string
+	TargetID   string
+	// Summary is the request's query and body with secrets removed, as JSON
+	Summary    string
+	StatusCode int
+	Result     string
+	RequestID  string
+	CreatedAt  time.Time
+}
+
+// AuditFilter narrows ListAuditEntries
+type AuditFilter struct {
+	// UserID limits the list to one user's requests unless zero
+	UserID     int64
+	Username   string
+	Action     string
+	TargetType string
+	TargetID   string
+	// BeforeID starts the list after this entry, from a previous page
+	BeforeID int64
+	Limit    int
+}
+
+func (db *DB) CreateAuditEntry(e *AuditEntry) error {
+	result, err := db.Exec(`
+		INSERT INTO audit_log (user_id, username, auth_method, api_key_id, source_ip, action,
+			target_type, target_id, summary, status_code, result, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.UserID, e.Username, e.AuthMethod, e.APIKeyID, e.SourceIP, e.Action,
+		e.TargetType, e.TargetID, e.Summary, e.StatusCode, e.Result, e.RequestID,
+	)
+	if err != nil {
+		return fmt.Errorf("insert audit entry: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+	e.ID = id
+	e.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+// ListAuditEntries returns audit entries matching f, newest first
+func (db *DB) ListAuditEntries(f AuditFilter) ([]*AuditEntry, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Username != "" {
+		where = append(where, "username = ?")
+		args = append(args, f.Username)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.TargetType != "" {
+		where = append(where, "target_type = ?")
+		args = append(args, f.TargetType)
+	}
+	if f.TargetID != "" {
+		where = append(where, "target_id = ?")
+		args = append(args, f.TargetID)
+	}
+	if f.BeforeID != 0 {
+		where = append(where, "id < ?")
+		args = append(args, f.BeforeID)
+	}
+
+	query := `
+		SELECT id, user_id, username, auth_method, api_key_id, source_ip, action,
+			target_type, target_id, summary, status_code, result, request_id, created_at
+		FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		e := &AuditEntry{}
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.AuthMethod, &e.APIKeyID, &e.SourceIP, &e.Action,
+			&e.TargetType, &e.TargetID, &e.Summary, &e.StatusCode, &e.Result, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Container lifecycle states
+const (
+	StatusProvisioning = "provisioning"
+	StatusStarting     = "starting"
+	StatusRunning      = "running"
+	StatusStopping     = "stopping"
+	StatusStopped      = "stopped"
+	StatusDeleting     = "deleting"
+	StatusFailed       = "failed"
+)
+
+// containerTransitions lists, for each state, the states a container may move to next.
+// This is the only place container status rules are defined; every status change goes
+// through TransitionContainer.
+var containerTransitions = map[string][]string{
+	StatusProvisioning: {StatusStarting, StatusFailed, StatusDeleting},
+	StatusStarting:     {StatusRunning, StatusStopping, StatusFailed, StatusDeleting},
+	StatusRunning:      {StatusStopping, StatusFailed, StatusDeleting},
+	StatusStopping:     {StatusStopped, StatusFailed, StatusDeleting},
+	StatusStopped:      {StatusStarting, StatusDeleting},
+	StatusFailed:       {StatusStarting, StatusStopping, StatusDeleting},
+	StatusDeleting:     {StatusFailed},
+}
+
+// TransitionError is returned when a container can't move to the requested state
+type TransitionError struct {
+	ContainerID string
+	Current     string
+	Requested   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("container %s cannot go from %s to %s", e.ContainerID, e.Current, e.Requested)
+}
+
+// Unwrap makes a TransitionError match ErrConflict
+func (e *TransitionError) Unwrap() error {
+	return ErrConflict
+}
+
+// CanTransition reports whether a container in state from may move to state to
+func CanTransition(from, to string) bool {
+	for _, next := range containerTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusEventType is the lifecycle event type recorded when a container enters a state
+func StatusEventType(status string) string {
+	return "container." + status
+}
+
+// TransitionContainer atomically moves a container to a new state, returning the state it
+// left. Returns a *TransitionError if the move isn't allowed from the current state.
+func (db *DB) TransitionContainer(id, to string) (string, error) {
+	query := `UPDATE containers SET status = ? WHERE id = ? AND status = ?`
+	if to == StatusStopped {
+		query = `UPDATE containers SET status = ?, stopped_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`
+	}
+
+	// Compare-and-set on the status we read, retrying if another writer got there first
+	for attempt := 0; attempt < 3; attempt++ {
+		var from string
+		err := db.QueryRow(`SELECT status FROM containers WHERE id = ?`, id).Scan(&from)
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("container %s: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return "", fmt.Errorf("query container status: %w", err)
+		}
+
+		if !CanTransition(from, to) {
+			return from, &TransitionError{ContainerID: id, Current: from, Requested: to}
+		}
+
+		result, err := db.Exec(query, to, id, from)
+		if err != nil {
+			return "", fmt.Errorf("update container status: %w", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 1 {
+			return from, nil
+		}
+	}
+	return "", fmt.Errorf("update container status: concurrent modification")
+}
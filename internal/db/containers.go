@@ -3,41 +3,81 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 )
 
 type Container struct {
-	ID         string
-	UserID     int64
-	Name       string
-	Namespace  string
-	Status     string
-	ExternalIP sql.NullString
-	MemoryMB   int
-	StorageGB  int
-	Image      string
-	CreatedAt  time.Time
-	StoppedAt  sql.NullTime
-}
-
-func (db *DB) CreateContainer(c *Container) error {
-	_, err := db.Exec(`
-		INSERT INTO containers (id, user_id, name, namespace, status, memory_mb, storage_gb, image)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		c.ID, c.UserID, c.Name, c.Namespace, c.Status, c.MemoryMB, c.StorageGB, c.Image,
+	ID string
+	// UserID is the user who created the container, and its owner unless OrgID is set
+	UserID           int64
+	OrgID            sql.NullInt64
+	Name             string
+	Namespace        string
+	Status           string
+	ExternalIP       sql.NullString
+	MemoryMB         int
+	StorageGB        int
+	Image            string
+	CreatedAt        time.Time
+	StoppedAt        sql.NullTime
+	StorageUsedBytes sql.NullInt64
+	ExpiresAt        sql.NullTime
+	ExpireAction     string
+	ExpiryWarnedAt   sql.NullTime
+	// IdleTimeoutMinutes overrides the user's idle timeout when set; 0 disables idle stops
+	IdleTimeoutMinutes sql.NullInt64
+}
+
+// Actions the reaper can take once a container's TTL runs out
+const (
+	ExpireActionDelete = "delete"
+	ExpireActionStop   = "stop"
+)
+
+const containerColumns = `id, user_id, name, namespace, status, external_ip, memory_mb, storage_gb, image, created_at, stopped_at, storage_used_bytes, expires_at, expire_action, expiry_warned_at, idle_timeout_minutes, org_id`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanContainer(s scanner) (*Container, error) {
+	c := &Container{}
+	err := s.Scan(&c.ID, &c.UserID, &c.Name, &c.Namespace, &c.Status, &c.ExternalIP, &c.MemoryMB, &c.StorageGB, &c.Image, &c.CreatedAt, &c.StoppedAt, &c.StorageUsedBytes, &c.ExpiresAt, &c.ExpireAction, &c.ExpiryWarnedAt, &c.IdleTimeoutMinutes, &c.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Owner is the org the container belongs to, or else the user who created it
+func (c *Container) Owner() Owner {
+	return ownerOf(c.UserID, c.OrgID)
+}
+
+// CreateContainer inserts a container, or returns ErrLimitExceeded if the user creating
+// it already has limit containers, counting those they made for their orgs
+func (db *DB) CreateContainer(c *Container, limit int) error {
+	cond, condArgs := quotaCondition(c.UserID)
+	args := []any{c.ID, c.UserID, c.OrgID, c.Name, c.Namespace, c.Status, c.MemoryMB, c.StorageGB, c.Image, nullTime(c.ExpiresAt), c.ExpireAction, c.IdleTimeoutMinutes}
+	result, err := db.Exec(`
+		INSERT INTO containers (id, user_id, org_id, name, namespace, status, memory_mb, storage_gb, image, expires_at, expire_action, idle_timeout_minutes)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM containers WHERE `+cond+`) < ?`,
+		append(append(args, condArgs...), limit)...,
 	)
 	if err != nil {
 		return fmt.Errorf("insert container: %w", err)
 	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("containers: %w", ErrLimitExceeded)
+	}
+	c.CreatedAt = time.Now().UTC()
 	return nil
 }
 
 func (db *DB) GetContainer(id string) (*Container, error) {
-	c := &Container{}
-	err := db.QueryRow(`
-		SELECT id, user_id, name, namespace, status, external_ip, memory_mb, storage_gb, image, created_at, stopped_at
-		FROM containers WHERE id = ?`, id,
-	).Scan(&c.ID, &c.UserID, &c.Name, &c.Namespace, &c.Status, &c.ExternalIP, &c.MemoryMB, &c.StorageGB, &c.Image, &c.CreatedAt, &c.StoppedAt)
+	c, err := scanContainer(db.QueryRow(`SELECT `+containerColumns+` FROM containers WHERE id = ?`, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -47,11 +87,64 @@ func (db *DB) GetContainer(id string) (*Container, error) {
 	return c, nil
 }
 
-func (db *DB) ListContainersByUser(userID int64) ([]*Container, error) {
-	rows, err := db.Query(`
-		SELECT id, user_id, name, namespace, status, external_ip, memory_mb, storage_gb, image, created_at, stopped_at
-		FROM containers WHERE user_id = ? ORDER BY created_at DESC`, userID,
-	)
+// ListContainersByOwner returns a page of the owner's containers and the cursor for the next page, if any
+func (db *DB) ListContainersByOwner(owner Owner, opts ContainerListOptions) ([]*Container, *Cursor, error) {
+	cond, args := owner.condition()
+	return db.listContainers([]string{cond}, args, opts)
+}
+
+// ListContainersSharedWith returns a page of the containers shared with the user
+// and the cursor for the next page, if any
+func (db *DB) ListContainersSharedWith(userID int64, opts ContainerListOptions) ([]*Container, *Cursor, error) {
+	return db.listContainers([]string{"id IN (SELECT container_id FROM container_grants WHERE user_id = ?)"}, []any{userID}, opts)
+}
+
+func (db *DB) listContainers(conds []string, args []any, opts ContainerListOptions) ([]*Container, *Cursor, error) {
+	if opts.Status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, opts.Status)
+	}
+	if opts.Image != "" {
+		conds = append(conds, "image = ?")
+		args = append(args, opts.Image)
+	}
+	if opts.IDs != nil {
+		placeholders := make([]string, len(opts.IDs))
+		for i, id := range opts.IDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		// An empty IN () matches nothing, which is what an empty list means
+		conds = append(conds, "id IN ("+strings.Join(placeholders, ",")+")")
+	}
+
+	clauses, args, err := opts.listQuery(conds, args, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	containers, err := db.queryContainers(`SELECT `+containerColumns+` FROM containers`+clauses, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	containers, next := page(&opts.ListOptions, containers, func(c *Container) (string, string, time.Time) {
+		return c.ID, c.Name, c.CreatedAt
+	})
+	return containers, next, nil
+}
+
+// ListContainersByStatus returns every container in the given status, across all users
+func (db *DB) ListContainersByStatus(status string) ([]*Container, error) {
+	return db.queryContainers(`SELECT `+containerColumns+` FROM containers WHERE status = ? ORDER BY created_at`, status)
+}
+
+// ListContainersExpiringBefore returns containers with a TTL that ends before the given time
+func (db *DB) ListContainersExpiringBefore(before time.Time) ([]*Container, error) {
+	return db.queryContainers(`SELECT `+containerColumns+` FROM containers WHERE expires_at IS NOT NULL AND expires_at <= ? ORDER BY expires_at`, sqlTime(before))
+}
+
+func (db *DB) queryContainers(query string, args ...any) ([]*Container, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query containers: %w", err)
 	}
@@ -59,8 +152,8 @@ func (db *DB) ListContainersByUser(userID int64) ([]*Container, error) {
 
 	var containers []*Container
 	for rows.Next() {
-		c := &Container{}
-		if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.Namespace, &c.Status, &c.ExternalIP, &c.MemoryMB, &c.StorageGB, &c.Image, &c.CreatedAt, &c.StoppedAt); err != nil {
+		c, err := scanContainer(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan container: %w", err)
 		}
 		containers = append(containers, c)
@@ -68,43 +161,62 @@ func (db *DB) ListContainersByUser(userID int64) ([]*Container, error) {
 	return containers, nil
 }
 
-func (db *DB) UpdateContainerStatus(id, status string) error {
-	_, err := db.Exec(`UPDATE containers SET status = ? WHERE id = ?`, status, id)
+func (db *DB) UpdateContainerIP(id, ip string) error {
+	_, err := db.Exec(`UPDATE containers SET external_ip = ? WHERE id = ?`, ip, id)
 	if err != nil {
-		return fmt.Errorf("update container status: %w", err)
+		return fmt.Errorf("update container ip: %w", err)
 	}
 	return nil
 }
 
-func (db *DB) UpdateContainerIP(id, ip string) error {
-	_, err := db.Exec(`UPDATE containers SET external_ip = ? WHERE id = ?`, ip, id)
+func (db *DB) UpdateContainerStorageUsed(id string, usedBytes int64) error {
+	_, err := db.Exec(`UPDATE containers SET storage_used_bytes = ? WHERE id = ?`, usedBytes, id)
 	if err != nil {
-		return fmt.Errorf("update container ip: %w", err)
+		return fmt.Errorf("update container storage used: %w", err)
 	}
 	return nil
 }
 
-func (db *DB) UpdateContainerStopped(id string) error {
-	_, err := db.Exec(`UPDATE containers SET status = 'stopped', stopped_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
+// UpdateContainerExpiry sets or clears a container's expiry and re-arms the expiry warning
+func (db *DB) UpdateContainerExpiry(id string, expiresAt sql.NullTime) error {
+	_, err := db.Exec(`UPDATE containers SET expires_at = ?, expiry_warned_at = NULL WHERE id = ?`, nullTime(expiresAt), id)
 	if err != nil {
-		return fmt.Errorf("update container stopped: %w", err)
+		return fmt.Errorf("update container expiry: %w", err)
 	}
 	return nil
 }
 
-func (db *DB) DeleteContainer(id string) error {
-	_, err := db.Exec(`DELETE FROM containers WHERE id = ?`, id)
+func (db *DB) UpdateContainerExpiryWarned(id string) error {
+	_, err := db.Exec(`UPDATE containers SET expiry_warned_at = CURRENT_TIMESTAMP WHERE id = ?`, id)
 	if err != nil {
-		return fmt.Errorf("delete container: %w", err)
+		return fmt.Errorf("update container expiry warned: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) UpdateContainerIdleTimeout(id string, minutes sql.NullInt64) error {
+	_, err := db.Exec(`UPDATE containers SET idle_timeout_minutes = ? WHERE id = ?`, minutes, id)
+	if err != nil {
+		return fmt.Errorf("update container idle timeout: %w", err)
 	}
 	return nil
 }
 
-func (db *DB) CountContainersByUser(userID int64) (int, error) {
-	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM containers WHERE user_id = ? AND status != 'deleted'`, userID).Scan(&count)
+func (db *DB) DeleteContainer(id string) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return 0, fmt.Errorf("count containers: %w", err)
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM container_schedules WHERE container_id = ?`, id); err != nil {
+		return fmt.Errorf("delete container schedules: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM container_grants WHERE container_id = ?`, id); err != nil {
+		return fmt.Errorf("delete container grants: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM containers WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete container: %w", err)
 	}
-	return count, nil
+	return tx.Commit()
 }
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -66,6 +67,156 @@ func (db *DB) migrate() error {
 		`CREATE INDEX IF NOT EXISTS idx_containers_user_id ON containers(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_ssh_keys_user_id ON ssh_keys(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id)`,
+		`CREATE TABLE IF NOT EXISTS container_disk_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			container_id TEXT NOT NULL,
+			used_bytes INTEGER NOT NULL,
+			capacity_bytes INTEGER NOT NULL,
+			recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_disk_usage_container_id ON container_disk_usage(container_id, recorded_at)`,
+		`CREATE TABLE IF NOT EXISTS container_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			container_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			message TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_events_container_id ON container_events(container_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_events_user_id ON container_events(user_id)`,
+		`CREATE TABLE IF NOT EXISTS container_schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			container_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			cron TEXT NOT NULL,
+			timezone TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			next_run_at DATETIME,
+			last_run_at DATETIME,
+			last_error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_schedules_container_id ON container_schedules(container_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_schedules_next_run_at ON container_schedules(enabled, next_run_at)`,
+		`CREATE TABLE IF NOT EXISTS operations (
+			id TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			container_id TEXT NOT NULL,
+			type TEXT NOT NULL,
+			status TEXT NOT NULL,
+			progress INTEGER NOT NULL DEFAULT 0,
+			message TEXT NOT NULL DEFAULT '',
+			result TEXT,
+			error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			done_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_operations_user_id ON operations(user_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS user_settings (
+			user_id INTEGER PRIMARY KEY,
+			idle_timeout_minutes INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			event_types TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks(user_id)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event_id INTEGER NOT NULL,
+			event_type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME,
+			response_code INTEGER,
+			last_error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt_at ON webhook_deliveries(status, next_attempt_at)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			status_code INTEGER,
+			content_type TEXT,
+			location TEXT,
+			body BLOB,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// Every session user shared ID 1 before the users table existed. Reserve it so
+		// nobody inherits that data by logging in first; see ClaimLegacyUser.
+		`INSERT OR IGNORE INTO users (id, username) VALUES (1, '')`,
+		// SSO identities, keyed on the token's issuer and subject. The subject is the
+		// identity; usernames in tokens can change and aren't SFS usernames.
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (issuer, subject)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id)`,
+		`CREATE TABLE IF NOT EXISTS orgs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS org_members (
+			org_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			role TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (org_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id)`,
+		`CREATE TABLE IF NOT EXISTS container_grants (
+			container_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			granted_by INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (container_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_container_grants_user_id ON container_grants(user_id)`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			username TEXT NOT NULL DEFAULT '',
+			auth_method TEXT NOT NULL DEFAULT '',
+			api_key_id INTEGER,
+			source_ip TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id TEXT NOT NULL DEFAULT '',
+			summary TEXT NOT NULL DEFAULT '',
+			status_code INTEGER NOT NULL,
+			result TEXT NOT NULL,
+			request_id TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log(user_id, id)`,
+		// The audit log is append-only; entries can't be edited or removed through SQL
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
 	}
 
 	for _, m := range migrations {
@@ -74,5 +225,116 @@ func (db *DB) migrate() error {
 		}
 	}
 
+	// Columns added after the initial schema
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"containers", "storage_used_bytes", "INTEGER"},
+		{"containers", "expires_at", "DATETIME"},
+		{"containers", "expire_action", "TEXT NOT NULL DEFAULT 'delete'"},
+		{"containers", "expiry_warned_at", "DATETIME"},
+		{"containers", "idle_timeout_minutes", "INTEGER"},
+		{"api_keys", "scopes", "TEXT NOT NULL DEFAULT '*'"},
+		{"api_keys", "container_ids", "TEXT"},
+		{"api_keys", "expires_at", "DATETIME"},
+		{"api_keys", "revoked_at", "DATETIME"},
+		{"api_keys", "revoked_reason", "TEXT"},
+		{"api_keys", "replaced_by", "INTEGER"},
+		{"api_keys", "key_id", "TEXT"},
+		{"containers", "org_id", "INTEGER"},
+		{"ssh_keys", "org_id", "INTEGER"},
+		{"api_keys", "org_id", "INTEGER"},
+		{"containers", "authorized_keys", "TEXT"},
+		{"container_events", "org_id", "INTEGER"},
+		{"operations", "org_id", "INTEGER"},
+	}
+
+	for _, c := range columns {
+		if err := db.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// Indexes on added columns
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_id ON api_keys(key_id)`); err != nil {
+		return fmt.Errorf("create api key id index: %w", err)
+	}
+	for _, table := range []string{"containers", "ssh_keys", "api_keys", "container_events", "operations"} {
+		if _, err := db.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_%[1]s_org_id ON %[1]s(org_id)`, table)); err != nil {
+			return fmt.Errorf("create %s org index: %w", table, err)
+		}
+	}
+
+	// Events and operations on org containers from before they recorded the org
+	for _, table := range []string{"container_events", "operations"} {
+		_, err := db.Exec(fmt.Sprintf(`
+			UPDATE %[1]s SET org_id = (SELECT org_id FROM containers WHERE containers.id = %[1]s.container_id)
+			WHERE org_id IS NULL AND container_id IN (SELECT id FROM containers WHERE org_id IS NOT NULL)`, table))
+		if err != nil {
+			return fmt.Errorf("backfill %s org: %w", table, err)
+		}
+	}
+
+	// Map statuses from before the container state machine onto its states
+	legacyStatuses := map[string]string{
+		"pending":   StatusStarting,
+		"not_found": StatusStopped,
+		"unknown":   StatusFailed,
+	}
+	for from, to := range legacyStatuses {
+		if _, err := db.Exec(`UPDATE containers SET status = ? WHERE status = ?`, to, from); err != nil {
+			return fmt.Errorf("migrate container status %s: %w", from, err)
+		}
+	}
+
 	return nil
 }
+
+// addColumnIfMissing adds a column to an existing table, since SQLite has no
+// ALTER TABLE ... ADD COLUMN IF NOT EXISTS
+func (db *DB) addColumnIfMissing(table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("table info %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("scan table info %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("table info %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// sqlTime formats a time the way SQLite's CURRENT_TIMESTAMP does, so stored
+// values compare correctly as text
+func sqlTime(t time.Time) string {
+	return t.UTC().Format(time.DateTime)
+}
+
+func nullTime(t sql.NullTime) any {
+	if !t.Valid {
+		return nil
+	}
+	return sqlTime(t.Time)
+}
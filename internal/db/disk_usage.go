@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type DiskUsage struct {
+	ID            int64
+	ContainerID   string
+	UsedBytes     int64
+	CapacityBytes int64
+	RecordedAt    time.Time
+}
+
+func (db *DB) CreateDiskUsage(u *DiskUsage) error {
+	result, err := db.Exec(`
+		INSERT INTO container_disk_usage (container_id, used_bytes, capacity_bytes)
+		VALUES (?, ?, ?)`,
+		u.ContainerID, u.UsedBytes, u.CapacityBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("insert disk usage: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+	u.ID = id
+	return nil
+}
+
+// GetLatestDiskUsage returns the most recent sample for a container, or nil if none exist
+func (db *DB) GetLatestDiskUsage(containerID string) (*DiskUsage, error) {
+	u := &DiskUsage{}
+	err := db.QueryRow(`
+		SELECT id, container_id, used_bytes, capacity_bytes, recorded_at
+		FROM container_disk_usage WHERE container_id = ? ORDER BY id DESC LIMIT 1`, containerID,
+	).Scan(&u.ID, &u.ContainerID, &u.UsedBytes, &u.CapacityBytes, &u.RecordedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query disk usage: %w", err)
+	}
+	return u, nil
+}
+
+func (db *DB) ListDiskUsage(containerID string, limit int) ([]*DiskUsage, error) {
+	rows, err := db.Query(`
+		SELECT id, container_id, used_bytes, capacity_bytes, recorded_at
+		FROM container_disk_usage WHERE container_id = ? ORDER BY id DESC LIMIT ?`, containerID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query disk usage: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []*DiskUsage
+	for rows.Next() {
+		u := &DiskUsage{}
+		if err := rows.Scan(&u.ID, &u.ContainerID, &u.UsedBytes, &u.CapacityBytes, &u.RecordedAt); err != nil {
+			return nil, fmt.Errorf("scan disk usage: %w", err)
+		}
+		samples = append(samples, u)
+	}
+	return samples, nil
+}
+
+// DeleteDiskUsageBefore prunes samples older than the given time
+func (db *DB) DeleteDiskUsageBefore(before time.Time) error {
+	_, err := db.Exec(`DELETE FROM container_disk_usage WHERE recorded_at < ?`, sqlTime(before))
+	if err != nil {
+		return fmt.Errorf("delete disk usage: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors callers can test for with errors.Is. Functions wrap them with detail
+// about what was missing, conflicting or over the limit.
+var (
+	// ErrNotFound means the row doesn't exist, or isn't owned by the given user
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the row isn't in a state that allows the change
+	ErrConflict = errors.New("conflict")
+	// ErrLastOwner means the change would leave an org without an owner. It
+	// matches ErrConflict.
+	ErrLastOwner = fmt.Errorf("org would have no owner: %w", ErrConflict)
+	// ErrLimitExceeded means the insert would take the owner over its quota
+	ErrLimitExceeded = errors.New("limit exceeded")
+)
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Container lifecycle event types
+// Status changes are recorded as "container.<status>", see StatusEventType
+const (
+	EventContainerDeleted  = "container.deleted"
+	EventIPAssigned        = "ip.assigned"
+	EventDiskThreshold     = "disk.threshold"
+	EventContainerExpiring = "container.expiring"
+	EventContainerExpired  = "container.expired"
+	EventContainerIdle     = "container.idle_stopped"
+	EventScheduleRun       = "schedule.run"
+	EventScheduleFailed    = "schedule.failed"
+)
+
+type ContainerEvent struct {
+	ID          int64
+	ContainerID string
+	UserID      int64
+	OrgID       sql.NullInt64
+	Type        string
+	Message     string
+	CreatedAt   time.Time
+}
+
+func (db *DB) CreateContainerEvent(e *ContainerEvent) error {
+	result, err := db.Exec(`
+		INSERT INTO container_events (container_id, user_id, org_id, type, message)
+		VALUES (?, ?, ?, ?, ?)`,
+		e.ContainerID, e.UserID, e.OrgID, e.Type, e.Message,
+	)
+	if err != nil {
+		return fmt.Errorf("insert container event: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+	e.ID = id
+	e.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+const eventColumns = `id, container_id, user_id, org_id, type, message, created_at`
+
+func (db *DB) ListContainerEvents(containerID string, limit int) ([]*ContainerEvent, error) {
+	return db.queryEvents(`SELECT `+eventColumns+` FROM container_events WHERE container_id = ? ORDER BY id DESC LIMIT ?`, containerID, limit)
+}
+
+// ListEventsAfter returns the events owner can see with IDs greater than afterID,
+// oldest first
+func (db *DB) ListEventsAfter(owner Owner, afterID int64, limit int) ([]*ContainerEvent, error) {
+	cond, args := owner.activityCondition()
+	return db.queryEvents(`SELECT `+eventColumns+` FROM container_events WHERE (`+cond+`) AND id > ? ORDER BY id LIMIT ?`,
+		append(args, afterID, limit)...)
+}
+
+// GetLatestEventID returns the ID of the newest event, or 0 if there are none
+func (db *DB) GetLatestEventID() (int64, error) {
+	var id int64
+	err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM container_events`).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("query latest event id: %w", err)
+	}
+	return id, nil
+}
+
+func (db *DB) queryEvents(query string, args ...any) ([]*ContainerEvent, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query container events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*ContainerEvent
+	for rows.Next() {
+		e := &ContainerEvent{}
+		if err := rows.Scan(&e.ID, &e.ContainerID, &e.UserID, &e.OrgID, &e.Type, &e.Message, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan container event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ContainerGrant gives a user other than a container's owner access to it
+type ContainerGrant struct {
+	ContainerID string
+	UserID      int64
+	Username    string
+	// GrantedBy is the user who shared the container
+	GrantedBy         int64
+	GrantedByUsername string
+	CreatedAt         time.Time
+}
+
+const containerGrantQuery = `
+	SELECT g.container_id, g.user_id, u.username, g.granted_by, COALESCE(b.username, ''), g.created_at
+	FROM container_grants g
+	JOIN users u ON u.id = g.user_id
+	LEFT JOIN users b ON b.id = g.granted_by`
+
+// CreateContainerGrant shares a container with a user, or returns ErrLimitExceeded if
+// it's already shared with limit users. Granting to someone who already has access
+// changes nothing.
+func (db *DB) CreateContainerGrant(g *ContainerGrant, limit int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM container_grants WHERE container_id = ? AND user_id = ?)`,
+		g.ContainerID, g.UserID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("query container grant: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO container_grants (container_id, user_id, granted_by)
+		SELECT ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM container_grants WHERE container_id = ?) < ?`,
+		g.ContainerID, g.UserID, g.GrantedBy, g.ContainerID, limit,
+	)
+	if err != nil {
+		return fmt.Errorf("insert container grant: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("container grants: %w", ErrLimitExceeded)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// GetContainerGrant returns the user's grant to the container, or nil if they don't have one
+func (db *DB) GetContainerGrant(containerID string, userID int64) (*ContainerGrant, error) {
+	g, err := scanContainerGrant(db.QueryRow(containerGrantQuery+` WHERE g.container_id = ? AND g.user_id = ?`, containerID, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query container grant: %w", err)
+	}
+	return g, nil
+}
+
+// ListContainerGrants returns who a container is shared with, oldest grant first
+func (db *DB) ListContainerGrants(containerID string) ([]*ContainerGrant, error) {
+	return db.queryContainerGrants(containerGrantQuery+` WHERE g.container_id = ? ORDER BY g.created_at, u.username`, containerID)
+}
+
+// ListContainerGrantsByUser returns the grants a user holds
+func (db *DB) ListContainerGrantsByUser(userID int64) ([]*ContainerGrant, error) {
+	return db.queryContainerGrants(containerGrantQuery+` WHERE g.user_id = ? ORDER BY g.created_at`, userID)
+}
+
+// DeleteContainerGrant takes away a user's access to a container, or returns
+// ErrNotFound if they didn't have a grant
+func (db *DB) DeleteContainerGrant(containerID string, userID int64) error {
+	result, err := db.Exec(`DELETE FROM container_grants WHERE container_id = ? AND user_id = ?`, containerID, userID)
+	if err != nil {
+		return fmt.Errorf("delete container grant: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("container grant: %w", ErrNotFound)
+	}
+	return nil
+}
+
+// ListSSHKeysByContainerGrants returns the personal SSH keys of everyone a container
+// is shared with
+func (db *DB) ListSSHKeysByContainerGrants(containerID string) ([]*SSHKey, error) {
+	rows, err := db.Query(`
+		SELECT `+sshKeyColumns+` FROM ssh_keys
+		WHERE org_id IS NULL AND user_id IN (SELECT user_id FROM container_grants WHERE container_id = ?)
+		ORDER BY id`, containerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query grantee ssh keys: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSSHKeys(rows)
+}
+
+// GetContainerAuthorizedKeys returns the authorized_keys a container was created
+// with, before any grantees' keys were added. ok is false for containers created
+// before it was recorded.
+func (db *DB) GetContainerAuthorizedKeys(id string) (keys string, ok bool, err error) {
+	var s sql.NullString
+	if err := db.QueryRow(`SELECT authorized_keys FROM containers WHERE id = ?`, id).Scan(&s); err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, fmt.Errorf("container %s: %w", id, ErrNotFound)
+		}
+		return "", false, fmt.Errorf("query container authorized keys: %w", err)
+	}
+	return s.String, s.Valid, nil
+}
+
+func (db *DB) SetContainerAuthorizedKeys(id, keys string) error {
+	if _, err := db.Exec(`UPDATE containers SET authorized_keys = ? WHERE id = ?`, keys, id); err != nil {
+		return fmt.Errorf("update container authorized keys: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) queryContainerGrants(query string, args ...any) ([]*ContainerGrant, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query container grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []*ContainerGrant
+	for rows.Next() {
+		g, err := scanContainerGrant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan container grant: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	return grants, nil
+}
+
+func scanContainerGrant(s scanner) (*ContainerGrant, error) {
+	g := &ContainerGrant{}
+	if err := s.Scan(&g.ContainerID, &g.UserID, &g.Username, &g.GrantedBy, &g.GrantedByUsername, &g.CreatedAt); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKey is a client-supplied key for a create request and, once the
+// request has finished, the response to replay for retries. StatusCode is unset
+// while the first request is still being handled.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	Fingerprint string
+	StatusCode  sql.NullInt64
+	ContentType string
+	Location    string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// ReserveIdempotencyKey claims a key for a new request. It returns nil if the key
+// was free, or the existing record if another request already used it.
+// Records created before expiredBefore, and unfinished ones created before
+// abandonedBefore, no longer count and are replaced.
+func (db *DB) ReserveIdempotencyKey(userID int64, key, fingerprint string, expiredBefore, abandonedBefore time.Time) (*IdempotencyKey, error) {
+	_, err := db.Exec(`
+		DELETE FROM idempotency_keys
+		WHERE user_id = ? AND key = ? AND (created_at < ? OR (status_code IS NULL AND created_at < ?))`,
+		userID, key, sqlTime(expiredBefore), sqlTime(abandonedBefore),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("delete expired idempotency key: %w", err)
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO idempotency_keys (user_id, key, fingerprint) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, key) DO NOTHING`,
+		userID, key, fingerprint,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert idempotency key: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 1 {
+		return nil, nil
+	}
+
+	k := &IdempotencyKey{}
+	var contentType, location sql.NullString
+	err = db.QueryRow(`
+		SELECT user_id, key, fingerprint, status_code, content_type, location, body, created_at
+		FROM idempotency_keys WHERE user_id = ? AND key = ?`, userID, key,
+	).Scan(&k.UserID, &k.Key, &k.Fingerprint, &k.StatusCode, &contentType, &location, &k.Body, &k.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("idempotency key released concurrently")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query idempotency key: %w", err)
+	}
+	k.ContentType = contentType.String
+	k.Location = location.String
+	return k, nil
+}
+
+// CompleteIdempotencyKey stores the response for a reserved key
+func (db *DB) CompleteIdempotencyKey(k *IdempotencyKey) error {
+	_, err := db.Exec(`
+		UPDATE idempotency_keys SET status_code = ?, content_type = ?, location = ?, body = ?
+		WHERE user_id = ? AND key = ?`,
+		k.StatusCode, k.ContentType, k.Location, k.Body, k.UserID, k.Key,
+	)
+	if err != nil {
+		return fmt.Errorf("update idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey frees a reserved key so the request can be retried
+func (db *DB) ReleaseIdempotencyKey(userID int64, key string) error {
+	_, err := db.Exec(`DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?`, userID, key)
+	if err != nil {
+		return fmt.Errorf("delete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteIdempotencyKeysBefore prunes keys created before t
+func (db *DB) DeleteIdempotencyKeysBefore(t time.Time) error {
+	_, err := db.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, sqlTime(t))
+	if err != nil {
+		return fmt.Errorf("delete idempotency keys: %w", err)
+	}
+	return nil
+}
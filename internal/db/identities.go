@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IdentityUsernamePrefix starts the usernames of users created for SSO identities
+// that aren't linked to an SFS account. SFS usernames never contain a colon, so
+// these can't be mistaken for (or taken over by) an SFS user.
+const IdentityUsernamePrefix = "oidc:"
+
+// UserIdentity links an SSO identity, a token issuer and subject, to a user
+type UserIdentity struct {
+	Issuer    string
+	Subject   string
+	UserID    int64
+	CreatedAt time.Time
+}
+
+// IsIdentityUser reports whether a user was created for an unlinked SSO identity
+func (u *User) IsIdentityUser() bool {
+	return strings.HasPrefix(u.Username, IdentityUsernamePrefix)
+}
+
+// GetUserByIdentity returns the user an SSO identity belongs to, or nil if it has
+// never been seen
+func (db *DB) GetUserByIdentity(issuer, subject string) (*User, error) {
+	u := &User{}
+	err := db.QueryRow(`
+		SELECT u.id, u.username, u.created_at
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = ? AND i.subject = ?`, issuer, subject,
+	).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query identity: %w", err)
+	}
+	return u, nil
+}
+
+// GetOrCreateIdentityUser returns the user an SSO identity belongs to, creating a
+// user of its own the first time it's seen. That user is never an SFS user; an SFS
+// user has to link the identity explicitly to act as it, see LinkIdentity.
+func (db *DB) GetOrCreateIdentityUser(issuer, subject string) (*User, error) {
+	u, err := db.GetUserByIdentity(issuer, subject)
+	if err != nil || u != nil {
+		return u, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO users (username) VALUES (?) ON CONFLICT(username) DO NOTHING`,
+		IdentityUsernamePrefix+subject)
+	if err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// Either another request for the same identity got here first, or the same
+		// subject was seen from another issuer
+		tx.Rollback()
+		if u, err := db.GetUserByIdentity(issuer, subject); err != nil || u != nil {
+			return u, err
+		}
+		return nil, fmt.Errorf("username for identity %q is taken: %w", subject, ErrConflict)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("get last insert id: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)`, issuer, subject, id); err != nil {
+		return nil, fmt.Errorf("insert identity: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return db.GetUser(id)
+}
+
+// LinkIdentity makes an SSO identity act as userID. An identity that already has a
+// user of its own (see GetOrCreateIdentityUser) is moved over; its containers and
+// keys stay with that user. Returns ErrConflict if it's linked to another SFS user.
+func (db *DB) LinkIdentity(issuer, subject string, userID int64) error {
+	current, err := db.GetUserByIdentity(issuer, subject)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.ID == userID {
+		return nil
+	}
+	if current != nil && !current.IsIdentityUser() {
+		return fmt.Errorf("identity is linked to another user: %w", ErrConflict)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)
+		ON CONFLICT(issuer, subject) DO UPDATE SET user_id = excluded.user_id, created_at = CURRENT_TIMESTAMP`,
+		issuer, subject, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("link identity: %w", err)
+	}
+	return nil
+}
+
+// ListUserIdentities returns the SSO identities linked to a user, oldest first
+func (db *DB) ListUserIdentities(userID int64) ([]*UserIdentity, error) {
+	rows, err := db.Query(`
+		SELECT issuer, subject, user_id, created_at FROM user_identities
+		WHERE user_id = ? ORDER BY created_at, subject`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query identities: %w", err)
+	}
+	defer rows.Close()
+
+	var identities []*UserIdentity
+	for rows.Next() {
+		i := &UserIdentity{}
+		if err := rows.Scan(&i.Issuer, &i.Subject, &i.UserID, &i.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan identity: %w", err)
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields list queries can sort by
+const (
+	SortCreatedAt = "created_at"
+	SortName      = "name"
+)
+
+// ListOptions pages, filters and orders a list query.
+// The zero value returns every row, newest first.
+type ListOptions struct {
+	Limit         int     // 0 means no limit
+	Cursor        *Cursor // Start after this row, from a previous page
+	Sort          string  // SortCreatedAt (default) or SortName
+	Ascending     bool
+	NamePrefix    string
+	CreatedBefore time.Time
+	CreatedAfter  time.Time
+}
+
+// ContainerListOptions adds container-only filters to ListOptions
+type ContainerListOptions struct {
+	ListOptions
+	Status string
+	Image  string
+	// IDs limits the list to these containers unless nil
+	IDs []string
+}
+
+// Cursor marks the last row of a page; the next page starts after it.
+// It carries the sort it was made for so it can't be replayed against another.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// String encodes the cursor as an opaque URL-safe token
+func (c *Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a token made by Cursor.String
+func ParseCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	c := &Cursor{}
+	if err := json.Unmarshal(b, c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// sortKey identifies a sort field and direction, e.g. "name" or "-created_at"
+func (o *ListOptions) sortKey() string {
+	if o.Ascending {
+		return o.sortField()
+	}
+	return "-" + o.sortField()
+}
+
+func (o *ListOptions) sortField() string {
+	if o.Sort == "" {
+		return SortCreatedAt
+	}
+	return o.Sort
+}
+
+// Validate checks the sort field and that the cursor was made for the same sort
+func (o *ListOptions) Validate() error {
+	if field := o.sortField(); field != SortCreatedAt && field != SortName {
+		return fmt.Errorf("invalid sort field %q", o.Sort)
+	}
+	if o.Cursor != nil && o.Cursor.Sort != o.sortKey() {
+		return fmt.Errorf("cursor does not match sort order")
+	}
+	return nil
+}
+
+// listQuery builds the WHERE, ORDER BY and LIMIT clauses for opts on top of the
+// given conditions. intID says whether the table's id column is an integer.
+// One extra row is requested so the caller can tell whether there's another page.
+func (o *ListOptions) listQuery(conds []string, args []any, intID bool) (string, []any, error) {
+	if err := o.Validate(); err != nil {
+		return "", nil, err
+	}
+	field := o.sortField()
+
+	if o.NamePrefix != "" {
+		conds = append(conds, `name LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(o.NamePrefix)+"%")
+	}
+	if !o.CreatedBefore.IsZero() {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, sqlTime(o.CreatedBefore))
+	}
+	if !o.CreatedAfter.IsZero() {
+		conds = append(conds, `created_at > ?`)
+		args = append(args, sqlTime(o.CreatedAfter))
+	}
+
+	op, dir := "<", "DESC"
+	if o.Ascending {
+		op, dir = ">", "ASC"
+	}
+
+	if o.Cursor != nil {
+		var id any = o.Cursor.ID
+		if intID {
+			n, err := strconv.ParseInt(o.Cursor.ID, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid cursor")
+			}
+			id = n
+		}
+		// Keyset pagination: rows after the cursor in (sort field, id) order
+		conds = append(conds, fmt.Sprintf(`(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))`, field, op))
+		args = append(args, o.Cursor.Value, o.Cursor.Value, id)
+	}
+
+	var b strings.Builder
+	if len(conds) > 0 {
+		b.WriteString(" WHERE ")
+		b.WriteString(strings.Join(conds, " AND "))
+	}
+	fmt.Fprintf(&b, " ORDER BY %[1]s %[2]s, id %[2]s", field, dir)
+	if o.Limit > 0 {
+		b.WriteString(" LIMIT ?")
+		args = append(args, o.Limit+1)
+	}
+	return b.String(), args, nil
+}
+
+// page trims rows fetched by listQuery to the requested limit, returning a cursor
+// for the next page if there is one. key returns the sortable fields of a row.
+func page[T any](o *ListOptions, rows []T, key func(T) (id, name string, createdAt time.Time)) ([]T, *Cursor) {
+	if o.Limit <= 0 || len(rows) <= o.Limit {
+		return rows, nil
+	}
+	rows = rows[:o.Limit]
+
+	id, name, createdAt := key(rows[len(rows)-1])
+	next := &Cursor{Sort: o.sortKey(), ID: id, Value: sqlTime(createdAt)}
+	if o.sortField() == SortName {
+		next.Value = name
+	}
+	return rows, next
+}
+
+// escapeLike escapes LIKE wildcards so s matches literally
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Operation types
+const (
+	OperationCreateContainer = "container.create"
+	OperationDeleteContainer = "container.delete"
+	OperationStartContainer  = "container.start"
+	OperationStopContainer   = "container.stop"
+)
+
+// Operation statuses
+const (
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// Operation tracks an asynchronous action on a container
+type Operation struct {
+	ID          string
+	UserID      int64
+	OrgID       sql.NullInt64
+	ContainerID string
+	Type        string
+	Status      string
+	Progress    int
+	Message     string
+	Result      sql.NullString // JSON
+	Error       sql.NullString
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DoneAt      sql.NullTime
+}
+
+// Done reports whether the operation has finished, successfully or not
+func (o *Operation) Done() bool {
+	return o.Status == OperationSucceeded || o.Status == OperationFailed
+}
+
+// Owner is who the operation's container belonged to when it ran, as far as the
+// operation knows: the org for org containers, otherwise whoever started it
+func (o *Operation) Owner() Owner {
+	return ownerOf(o.UserID, o.OrgID)
+}
+
+const operationColumns = `id, user_id, org_id, container_id, type, status, progress, message, result, error, created_at, updated_at, done_at`
+
+func scanOperation(s scanner) (*Operation, error) {
+	o := &Operation{}
+	err := s.Scan(&o.ID, &o.UserID, &o.OrgID, &o.ContainerID, &o.Type, &o.Status, &o.Progress, &o.Message, &o.Result, &o.Error, &o.CreatedAt, &o.UpdatedAt, &o.DoneAt)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (db *DB) CreateOperation(o *Operation) error {
+	_, err := db.Exec(`
+		INSERT INTO operations (id, user_id, org_id, container_id, type, status, progress, message)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		o.ID, o.UserID, o.OrgID, o.ContainerID, o.Type, o.Status, o.Progress, o.Message,
+	)
+	if err != nil {
+		return fmt.Errorf("insert operation: %w", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	o.CreatedAt = now
+	o.UpdatedAt = now
+	return nil
+}
+
+func (db *DB) GetOperation(id string) (*Operation, error) {
+	o, err := scanOperation(db.QueryRow(`SELECT `+operationColumns+` FROM operations WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query operation: %w", err)
+	}
+	return o, nil
+}
+
+// ListOperationsByOwner returns the newest operations owner can see
+func (db *DB) ListOperationsByOwner(owner Owner, limit int) ([]*Operation, error) {
+	cond, args := owner.activityCondition()
+	rows, err := db.Query(`SELECT `+operationColumns+` FROM operations WHERE (`+cond+`) ORDER BY created_at DESC, rowid DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("query operations: %w", err)
+	}
+	defer rows.Close()
+
+	var ops []*Operation
+	for rows.Next() {
+		o, err := scanOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan operation: %w", err)
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
+
+func (db *DB) UpdateOperationProgress(id string, progress int, message string) error {
+	_, err := db.Exec(`
+		UPDATE operations SET status = ?, progress = ?, message = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		OperationRunning, progress, message, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update operation progress: %w", err)
+	}
+	return nil
+}
+
+// CompleteOperation marks an operation as succeeded with an optional JSON result
+func (db *DB) CompleteOperation(id string, result sql.NullString) error {
+	_, err := db.Exec(`
+		UPDATE operations SET status = ?, progress = 100, result = ?,
+			updated_at = CURRENT_TIMESTAMP, done_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		OperationSucceeded, result, id,
+	)
+	if err != nil {
+		return fmt.Errorf("complete operation: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) FailOperation(id string, message string) error {
+	_, err := db.Exec(`
+		UPDATE operations SET status = ?, error = ?,
+			updated_at = CURRENT_TIMESTAMP, done_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		OperationFailed, message, id,
+	)
+	if err != nil {
+		return fmt.Errorf("fail operation: %w", err)
+	}
+	return nil
+}
+
+// FailInterruptedOperations marks operations that were in flight when the service
+// last stopped as failed, since nothing is left running to finish them, and returns
+// them so the containers they were changing can be dealt with.
+func (db *DB) FailInterruptedOperations() ([]*Operation, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT `+operationColumns+` FROM operations WHERE status IN (?, ?) ORDER BY created_at`,
+		OperationPending, OperationRunning)
+	if err != nil {
+		return nil, fmt.Errorf("query interrupted operations: %w", err)
+	}
+	var ops []*Operation
+	for rows.Next() {
+		o, err := scanOperation(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan operation: %w", err)
+		}
+		ops = append(ops, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query interrupted operations: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE operations SET status = ?, error = 'interrupted by service restart',
+			updated_at = CURRENT_TIMESTAMP, done_at = CURRENT_TIMESTAMP
+		WHERE status IN (?, ?)`,
+		OperationFailed, OperationPending, OperationRunning,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fail interrupted operations: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+
+	for _, o := range ops {
+		o.Status = OperationFailed
+		o.Error = sql.NullString{String: "interrupted by service restart", Valid: true}
+	}
+	return ops, nil
+}
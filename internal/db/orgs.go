@@ -0,0 +1,323 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Org membership roles, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// Roles lists the org roles, most privileged first
+var Roles = []string{RoleOwner, RoleAdmin, RoleMember, RoleViewer}
+
+type Org struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+// OrgMember is a user's membership of an org
+type OrgMember struct {
+	OrgID     int64
+	UserID    int64
+	Username  string
+	Role      string
+	CreatedAt time.Time
+}
+
+// OrgMembership is an org along with the role a user holds in it
+type OrgMembership struct {
+	Org
+	Role string
+}
+
+// Owner is who a container, SSH key or API key belongs to: an org if OrgID is set,
+// otherwise the user. Resources an org owns still record the user who made them.
+type Owner struct {
+	UserID int64
+	OrgID  int64
+}
+
+// UserOwner is the owner of a user's personal resources
+func UserOwner(userID int64) Owner {
+	return Owner{UserID: userID}
+}
+
+// OrgOwner is the owner of an org's resources
+func OrgOwner(orgID int64) Owner {
+	return Owner{OrgID: orgID}
+}
+
+// IsOrg reports whether an org, rather than a user, is the owner
+func (o Owner) IsOrg() bool {
+	return o.OrgID != 0
+}
+
+// condition matches the rows o owns. Personal resources are those without an org,
+// so a user's own list doesn't include what they made for their orgs.
+func (o Owner) condition() (string, []any) {
+	if o.IsOrg() {
+		return "org_id = ?", []any{o.OrgID}
+	}
+	return "user_id = ? AND org_id IS NULL", []any{o.UserID}
+}
+
+// activityCondition matches the events and operations o can see. An org sees those
+// on its containers. A user sees those on their personal containers, including
+// ones others ran on them, and those on containers shared with them.
+func (o Owner) activityCondition() (string, []any) {
+	if o.IsOrg() {
+		return "org_id = ?", []any{o.OrgID}
+	}
+	return `(user_id = ? AND org_id IS NULL) OR
+		container_id IN (SELECT id FROM containers WHERE user_id = ? AND org_id IS NULL) OR
+		container_id IN (SELECT container_id FROM container_grants WHERE user_id = ?)`,
+		[]any{o.UserID, o.UserID, o.UserID}
+}
+
+// quotaCondition matches the rows that count toward a user's limits: what they made
+// for themselves and for the orgs they still belong to. Making more orgs doesn't
+// raise the limits, and leaving one frees what was made for it.
+func quotaCondition(userID int64) (string, []any) {
+	return "user_id = ? AND (org_id IS NULL OR org_id IN (SELECT org_id FROM org_members WHERE user_id = ?))",
+		[]any{userID, userID}
+}
+
+func ownerOf(userID int64, orgID sql.NullInt64) Owner {
+	if orgID.Valid {
+		return OrgOwner(orgID.Int64)
+	}
+	return UserOwner(userID)
+}
+
+// CreateOrg inserts an org with creatorID as its owner. Returns ErrConflict if the
+// name is taken.
+func (db *DB) CreateOrg(org *Org, creatorID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO orgs (name) VALUES (?) ON CONFLICT(name) DO NOTHING`, org.Name)
+	if err != nil {
+		return fmt.Errorf("insert org: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("org %q: %w", org.Name, ErrConflict)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+
+	if _, err := tx.Exec(`INSERT INTO org_members (org_id, user_id, role) VALUES (?, ?, ?)`, id, creatorID, RoleOwner); err != nil {
+		return fmt.Errorf("insert org owner: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	org.ID = id
+	org.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+func (db *DB) GetOrg(id int64) (*Org, error) {
+	org := &Org{}
+	err := db.QueryRow(`SELECT id, name, created_at FROM orgs WHERE id = ?`, id).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query org: %w", err)
+	}
+	return org, nil
+}
+
+// ListOrgsByUser returns the orgs the user belongs to and their role in each, by name
+func (db *DB) ListOrgsByUser(userID int64) ([]*OrgMembership, error) {
+	rows, err := db.Query(`
+		SELECT o.id, o.name, o.created_at, m.role
+		FROM orgs o JOIN org_members m ON m.org_id = o.id
+		WHERE m.user_id = ? ORDER BY o.name`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query orgs: %w", err)
+	}
+	defer rows.Close()
+
+	var orgs []*OrgMembership
+	for rows.Next() {
+		m := &OrgMembership{}
+		if err := rows.Scan(&m.ID, &m.Name, &m.CreatedAt, &m.Role); err != nil {
+			return nil, fmt.Errorf("scan org: %w", err)
+		}
+		orgs = append(orgs, m)
+	}
+	return orgs, nil
+}
+
+// GetOrgRole returns the user's role in the org, or "" if they aren't a member
+func (db *DB) GetOrgRole(orgID, userID int64) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM org_members WHERE org_id = ? AND user_id = ?`, orgID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("query org role: %w", err)
+	}
+	return role, nil
+}
+
+// ListOrgMembers returns the org's members, most privileged first
+func (db *DB) ListOrgMembers(orgID int64) ([]*OrgMember, error) {
+	rows, err := db.Query(`
+		SELECT m.org_id, m.user_id, u.username, m.role, m.created_at
+		FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ?
+		ORDER BY CASE m.role WHEN ? THEN 0 WHEN ? THEN 1 WHEN ? THEN 2 ELSE 3 END, u.username`,
+		orgID, RoleOwner, RoleAdmin, RoleMember,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query org members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []*OrgMember
+	for rows.Next() {
+		m := &OrgMember{}
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan org member: %w", err)
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// GetOrgMember returns the user's membership of the org, or nil if they aren't a member
+func (db *DB) GetOrgMember(orgID, userID int64) (*OrgMember, error) {
+	m := &OrgMember{}
+	err := db.QueryRow(`
+		SELECT m.org_id, m.user_id, u.username, m.role, m.created_at
+		FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ? AND m.user_id = ?`, orgID, userID,
+	).Scan(&m.OrgID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query org member: %w", err)
+	}
+	return m, nil
+}
+
+// SetOrgMember adds the user to the org with role, or changes their role if they're
+// already a member. Viewers can't SSH into the org's containers, so making someone a
+// viewer deletes the SSH keys they added to it. Returns ErrLastOwner if it would leave
+// the org without an owner.
+func (db *DB) SetOrgMember(orgID, userID int64, role string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if role != RoleOwner {
+		if err := checkNotLastOwner(tx, orgID, userID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO org_members (org_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(org_id, user_id) DO UPDATE SET role = excluded.role`,
+		orgID, userID, role,
+	)
+	if err != nil {
+		return fmt.Errorf("set org member: %w", err)
+	}
+	if role == RoleViewer {
+		if _, err := tx.Exec(`DELETE FROM ssh_keys WHERE org_id = ? AND user_id = ?`, orgID, userID); err != nil {
+			return fmt.Errorf("delete org member ssh keys: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// RemoveOrgMember takes the user out of the org, deleting the SSH keys they added to
+// it and revoking the API keys they made for it. Returns ErrNotFound if they aren't
+// a member, or ErrLastOwner if they're its last owner.
+func (db *DB) RemoveOrgMember(orgID, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkNotLastOwner(tx, orgID, userID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM org_members WHERE org_id = ? AND user_id = ?`, orgID, userID)
+	if err != nil {
+		return fmt.Errorf("delete org member: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("org member %d: %w", userID, ErrNotFound)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM ssh_keys WHERE org_id = ? AND user_id = ?`, orgID, userID); err != nil {
+		return fmt.Errorf("delete org member ssh keys: %w", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = 'removed from org'
+		WHERE org_id = ? AND user_id = ? AND revoked_at IS NULL`,
+		orgID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke org member api keys: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// checkNotLastOwner returns ErrLastOwner if the user is the org's only owner
+func checkNotLastOwner(tx *sql.Tx, orgID, userID int64) error {
+	var isOwner bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM org_members WHERE org_id = ? AND user_id = ? AND role = ?)`,
+		orgID, userID, RoleOwner).Scan(&isOwner)
+	if err != nil {
+		return fmt.Errorf("query org owner: %w", err)
+	}
+	if !isOwner {
+		return nil
+	}
+
+	var others int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM org_members WHERE org_id = ? AND role = ? AND user_id != ?`,
+		orgID, RoleOwner, userID).Scan(&others)
+	if err != nil {
+		return fmt.Errorf("count org owners: %w", err)
+	}
+	if others == 0 {
+		return fmt.Errorf("org %d: %w", orgID, ErrLastOwner)
+	}
+	return nil
+}
+
+// ListContainersByOrg returns every container the org owns
+func (db *DB) ListContainersByOrg(orgID int64) ([]*Container, error) {
+	return db.queryContainers(`SELECT `+containerColumns+` FROM containers WHERE org_id = ? ORDER BY created_at`, orgID)
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Actions a schedule can perform
+const (
+	ScheduleActionStart = "start"
+	ScheduleActionStop  = "stop"
+)
+
+type Schedule struct {
+	ID          int64
+	ContainerID string
+	UserID      int64
+	Action      string
+	Cron        string
+	Timezone    string
+	Enabled     bool
+	NextRunAt   sql.NullTime
+	LastRunAt   sql.NullTime
+	LastError   sql.NullString
+	CreatedAt   time.Time
+}
+
+const scheduleColumns = `id, container_id, user_id, action, cron, timezone, enabled, next_run_at, last_run_at, last_error, created_at`
+
+func scanSchedule(s scanner) (*Schedule, error) {
+	sc := &Schedule{}
+	err := s.Scan(&sc.ID, &sc.ContainerID, &sc.UserID, &sc.Action, &sc.Cron, &sc.Timezone, &sc.Enabled, &sc.NextRunAt, &sc.LastRunAt, &sc.LastError, &sc.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// CreateSchedule inserts a schedule, or returns ErrLimitExceeded if the container already has limit schedules
+func (db *DB) CreateSchedule(s *Schedule, limit int) error {
+	result, err := db.Exec(`
+		INSERT INTO container_schedules (container_id, user_id, action, cron, timezone, enabled, next_run_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM container_schedules WHERE container_id = ?) < ?`,
+		s.ContainerID, s.UserID, s.Action, s.Cron, s.Timezone, s.Enabled, nullTime(s.NextRunAt), s.ContainerID, limit,
+	)
+	if err != nil {
+		return fmt.Errorf("insert schedule: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("schedules: %w", ErrLimitExceeded)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+	s.ID = id
+	s.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+func (db *DB) GetSchedule(id int64) (*Schedule, error) {
+	s, err := scanSchedule(db.QueryRow(`SELECT `+scheduleColumns+` FROM container_schedules WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query schedule: %w", err)
+	}
+	return s, nil
+}
+
+func (db *DB) ListSchedulesByContainer(containerID string) ([]*Schedule, error) {
+	return db.querySchedules(`SELECT `+scheduleColumns+` FROM container_schedules WHERE container_id = ? ORDER BY id`, containerID)
+}
+
+// ListDueSchedules returns enabled schedules whose next run is at or before the given time,
+// oldest first so that a start and stop missed during downtime are replayed in order
+func (db *DB) ListDueSchedules(now time.Time) ([]*Schedule, error) {
+	return db.querySchedules(`SELECT `+scheduleColumns+` FROM container_schedules
+		WHERE enabled = 1 AND next_run_at IS NOT NULL AND next_run_at <= ? ORDER BY next_run_at, id`, sqlTime(now))
+}
+
+func (db *DB) querySchedules(query string, args ...any) ([]*Schedule, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []*Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan schedule: %w", err)
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+func (db *DB) UpdateSchedule(s *Schedule) error {
+	_, err := db.Exec(`
+		UPDATE container_schedules SET action = ?, cron = ?, timezone = ?, enabled = ?, next_run_at = ?
+		WHERE id = ?`,
+		s.Action, s.Cron, s.Timezone, s.Enabled, nullTime(s.NextRunAt), s.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update schedule: %w", err)
+	}
+	return nil
+}
+
+// UpdateScheduleRun records the outcome of a run and when the schedule should fire next
+func (db *DB) UpdateScheduleRun(id int64, nextRunAt sql.NullTime, runErr error) error {
+	var lastError sql.NullString
+	if runErr != nil {
+		lastError = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+	_, err := db.Exec(`
+		UPDATE container_schedules SET last_run_at = CURRENT_TIMESTAMP, next_run_at = ?, last_error = ?
+		WHERE id = ?`,
+		nullTime(nextRunAt), lastError, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update schedule run: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteSchedule(id int64, containerID string) error {
+	result, err := db.Exec(`DELETE FROM container_schedules WHERE id = ? AND container_id = ?`, id, containerID)
+	if err != nil {
+		return fmt.Errorf("delete schedule: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("schedule %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
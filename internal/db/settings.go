@@ -0,0 +1,34 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type UserSettings struct {
+	UserID int64
+	// IdleTimeoutMinutes is the default for the user's containers; 0 disables idle stops
+	IdleTimeoutMinutes sql.NullInt64
+}
+
+// GetUserSettings returns the user's settings, or empty settings if none have been saved
+func (db *DB) GetUserSettings(userID int64) (*UserSettings, error) {
+	s := &UserSettings{UserID: userID}
+	err := db.QueryRow(`SELECT idle_timeout_minutes FROM user_settings WHERE user_id = ?`, userID).Scan(&s.IdleTimeoutMinutes)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("query user settings: %w", err)
+	}
+	return s, nil
+}
+
+func (db *DB) UpsertUserSettings(s *UserSettings) error {
+	_, err := db.Exec(`
+		INSERT INTO user_settings (user_id, idle_timeout_minutes) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET idle_timeout_minutes = excluded.idle_timeout_minutes`,
+		s.UserID, s.IdleTimeoutMinutes,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert user settings: %w", err)
+	}
+	return nil
+}
@@ -3,41 +3,64 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
 	"time"
 )
 
 type SSHKey struct {
-	ID          int64
+	ID int64
+	// UserID is the user who added the key, and its owner unless OrgID is set
 	UserID      int64
+	OrgID       sql.NullInt64
 	Name        string
 	PublicKey   string
 	Fingerprint string
 	CreatedAt   time.Time
 }
 
-func (db *DB) CreateSSHKey(key *SSHKey) error {
+const sshKeyColumns = `id, user_id, org_id, name, public_key, fingerprint, created_at`
+
+func scanSSHKey(s scanner) (*SSHKey, error) {
+	key := &SSHKey{}
+	if err := s.Scan(&key.ID, &key.UserID, &key.OrgID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.CreatedAt); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Owner is the org the key belongs to, or else the user who added it
+func (k *SSHKey) Owner() Owner {
+	return ownerOf(k.UserID, k.OrgID)
+}
+
+// CreateSSHKey inserts a key, or returns ErrLimitExceeded if the user adding it already
+// has limit keys, counting those they added to their orgs
+func (db *DB) CreateSSHKey(key *SSHKey, limit int) error {
+	cond, condArgs := quotaCondition(key.UserID)
+	args := []any{key.UserID, key.OrgID, key.Name, key.PublicKey, key.Fingerprint}
 	result, err := db.Exec(`
-		INSERT INTO ssh_keys (user_id, name, public_key, fingerprint)
-		VALUES (?, ?, ?, ?)`,
-		key.UserID, key.Name, key.PublicKey, key.Fingerprint,
+		INSERT INTO ssh_keys (user_id, org_id, name, public_key, fingerprint)
+		SELECT ?, ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM ssh_keys WHERE `+cond+`) < ?`,
+		append(append(args, condArgs...), limit)...,
 	)
 	if err != nil {
 		return fmt.Errorf("insert ssh key: %w", err)
 	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("ssh keys: %w", ErrLimitExceeded)
+	}
 	id, err := result.LastInsertId()
 	if err != nil {
 		return fmt.Errorf("get last insert id: %w", err)
 	}
 	key.ID = id
+	key.CreatedAt = time.Now().UTC()
 	return nil
 }
 
 func (db *DB) GetSSHKey(id int64) (*SSHKey, error) {
-	key := &SSHKey{}
-	err := db.QueryRow(`
-		SELECT id, user_id, name, public_key, fingerprint, created_at
-		FROM ssh_keys WHERE id = ?`, id,
-	).Scan(&key.ID, &key.UserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.CreatedAt)
+	key, err := scanSSHKey(db.QueryRow(`SELECT `+sshKeyColumns+` FROM ssh_keys WHERE id = ?`, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -47,36 +70,44 @@ func (db *DB) GetSSHKey(id int64) (*SSHKey, error) {
 	return key, nil
 }
 
-func (db *DB) ListSSHKeysByUser(userID int64) ([]*SSHKey, error) {
-	rows, err := db.Query(`
-		SELECT id, user_id, name, public_key, fingerprint, created_at
-		FROM ssh_keys WHERE user_id = ? ORDER BY created_at DESC`, userID,
-	)
+// ListSSHKeysByOwner returns a page of the owner's SSH keys and the cursor for the next page, if any
+func (db *DB) ListSSHKeysByOwner(owner Owner, opts ListOptions) ([]*SSHKey, *Cursor, error) {
+	cond, condArgs := owner.condition()
+	clauses, args, err := opts.listQuery([]string{cond}, condArgs, true)
 	if err != nil {
-		return nil, fmt.Errorf("query ssh keys: %w", err)
+		return nil, nil, err
+	}
+
+	rows, err := db.Query(`SELECT `+sshKeyColumns+` FROM ssh_keys`+clauses, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query ssh keys: %w", err)
 	}
 	defer rows.Close()
 
 	var keys []*SSHKey
 	for rows.Next() {
-		key := &SSHKey{}
-		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.CreatedAt); err != nil {
-			return nil, fmt.Errorf("scan ssh key: %w", err)
+		key, err := scanSSHKey(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scan ssh key: %w", err)
 		}
 		keys = append(keys, key)
 	}
-	return keys, nil
+
+	keys, next := page(&opts, keys, func(k *SSHKey) (string, string, time.Time) {
+		return strconv.FormatInt(k.ID, 10), k.Name, k.CreatedAt
+	})
+	return keys, next, nil
 }
 
-func (db *DB) GetSSHKeysByIDs(userID int64, ids []int64) ([]*SSHKey, error) {
+// GetSSHKeysByIDs returns those of the given keys that the owner has
+func (db *DB) GetSSHKeysByIDs(owner Owner, ids []int64) ([]*SSHKey, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
 
 	// Build query with placeholders
-	query := `SELECT id, user_id, name, public_key, fingerprint, created_at
-		FROM ssh_keys WHERE user_id = ? AND id IN (`
-	args := []any{userID}
+	cond, args := owner.condition()
+	query := `SELECT ` + sshKeyColumns + ` FROM ssh_keys WHERE ` + cond + ` AND id IN (`
 	for i, id := range ids {
 		if i > 0 {
 			query += ","
@@ -92,10 +123,25 @@ func (db *DB) GetSSHKeysByIDs(userID int64, ids []int64) ([]*SSHKey, error) {
 	}
 	defer rows.Close()
 
+	return scanSSHKeys(rows)
+}
+
+// ListSSHKeysByOrg returns every SSH key the org has, oldest first
+func (db *DB) ListSSHKeysByOrg(orgID int64) ([]*SSHKey, error) {
+	rows, err := db.Query(`SELECT `+sshKeyColumns+` FROM ssh_keys WHERE org_id = ? ORDER BY id`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("query org ssh keys: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSSHKeys(rows)
+}
+
+func scanSSHKeys(rows *sql.Rows) ([]*SSHKey, error) {
 	var keys []*SSHKey
 	for rows.Next() {
-		key := &SSHKey{}
-		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.CreatedAt); err != nil {
+		key, err := scanSSHKey(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan ssh key: %w", err)
 		}
 		keys = append(keys, key)
@@ -103,23 +149,15 @@ func (db *DB) GetSSHKeysByIDs(userID int64, ids []int64) ([]*SSHKey, error) {
 	return keys, nil
 }
 
-func (db *DB) DeleteSSHKey(id int64, userID int64) error {
-	result, err := db.Exec(`DELETE FROM ssh_keys WHERE id = ? AND user_id = ?`, id, userID)
+func (db *DB) DeleteSSHKey(id int64, owner Owner) error {
+	cond, args := owner.condition()
+	result, err := db.Exec(`DELETE FROM ssh_keys WHERE id = ? AND `+cond, append([]any{id}, args...)...)
 	if err != nil {
 		return fmt.Errorf("delete ssh key: %w", err)
 	}
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("ssh key not found or not owned by user")
+		return fmt.Errorf("ssh key %d: %w", id, ErrNotFound)
 	}
 	return nil
 }
-
-func (db *DB) CountSSHKeysByUser(userID int64) (int, error) {
-	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM ssh_keys WHERE user_id = ?`, userID).Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("count ssh keys: %w", err)
-	}
-	return count, nil
-}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LegacyUserID owns everything created by session users before they had their own IDs
+const LegacyUserID = 1
+
+type User struct {
+	ID        int64
+	Username  string // Empty for the legacy user until it's claimed
+	CreatedAt time.Time
+}
+
+// GetOrCreateUser returns the user with the given SFS username, creating it on first login
+func (db *DB) GetOrCreateUser(username string) (*User, error) {
+	if _, err := db.Exec(`INSERT INTO users (username) VALUES (?) ON CONFLICT(username) DO NOTHING`, username); err != nil {
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+
+	u := &User{}
+	err := db.QueryRow(`SELECT id, username, created_at FROM users WHERE username = ?`, username).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+	return u, nil
+}
+
+func (db *DB) GetUser(id int64) (*User, error) {
+	u := &User{}
+	err := db.QueryRow(`SELECT id, username, created_at FROM users WHERE id = ?`, id).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+	return u, nil
+}
+
+// GetUserByUsername returns the user with the given SFS username, or nil if they've
+// never signed in
+func (db *DB) GetUserByUsername(username string) (*User, error) {
+	u := &User{}
+	err := db.QueryRow(`SELECT id, username, created_at FROM users WHERE username = ?`, username).Scan(&u.ID, &u.Username, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+	return u, nil
+}
+
+// ClaimLegacyUser gives the legacy user ID to username, so that user keeps the
+// containers and keys created before users were told apart. It fails if the legacy
+// user was already claimed by someone else, or username already has its own ID.
+func (db *DB) ClaimLegacyUser(username string) error {
+	u, err := db.GetUser(LegacyUserID)
+	if err != nil {
+		return err
+	}
+	if u != nil && u.Username == username {
+		return nil
+	}
+	if u != nil && u.Username != "" {
+		return fmt.Errorf("legacy user already claimed by %q: %w", u.Username, ErrConflict)
+	}
+
+	var id int64
+	err = db.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&id)
+	if err == nil {
+		return fmt.Errorf("user %q already has id %d: %w", username, id, ErrConflict)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("query user: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO users (id, username) VALUES (?, ?)
+		ON CONFLICT(id) DO UPDATE SET username = excluded.username WHERE users.username = ''`,
+		LegacyUserID, username,
+	)
+	if err != nil {
+		return fmt.Errorf("claim legacy user: %w", err)
+	}
+	return nil
+}
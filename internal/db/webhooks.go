@@ -0,0 +1,239 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID         int64
+	UserID     int64
+	URL        string
+	Secret     string
+	EventTypes []string
+	CreatedAt  time.Time
+}
+
+// Subscribes reports whether the webhook wants events of the given type
+func (w *Webhook) Subscribes(eventType string) bool {
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	EventID       int64
+	EventType     string
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt sql.NullTime
+	ResponseCode  sql.NullInt64
+	LastError     sql.NullString
+	CreatedAt     time.Time
+	DeliveredAt   sql.NullTime
+}
+
+// CreateWebhook inserts a webhook, or returns ErrLimitExceeded if the user already has limit webhooks
+func (db *DB) CreateWebhook(w *Webhook, limit int) error {
+	result, err := db.Exec(`
+		INSERT INTO webhooks (user_id, url, secret, event_types)
+		SELECT ?, ?, ?, ?
+		WHERE (SELECT COUNT(*) FROM webhooks WHERE user_id = ?) < ?`,
+		w.UserID, w.URL, w.Secret, strings.Join(w.EventTypes, ","), w.UserID, limit,
+	)
+	if err != nil {
+		return fmt.Errorf("insert webhook: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("webhooks: %w", ErrLimitExceeded)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+	w.ID = id
+	w.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+func (db *DB) GetWebhook(id int64) (*Webhook, error) {
+	w := &Webhook{}
+	var eventTypes string
+	err := db.QueryRow(`
+		SELECT id, user_id, url, secret, event_types, created_at
+		FROM webhooks WHERE id = ?`, id,
+	).Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &eventTypes, &w.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query webhook: %w", err)
+	}
+	w.EventTypes = strings.Split(eventTypes, ",")
+	return w, nil
+}
+
+func (db *DB) ListWebhooksByUser(userID int64) ([]*Webhook, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, url, secret, event_types, created_at
+		FROM webhooks WHERE user_id = ? ORDER BY id`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		w := &Webhook{}
+		var eventTypes string
+		if err := rows.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &eventTypes, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan webhook: %w", err)
+		}
+		w.EventTypes = strings.Split(eventTypes, ",")
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func (db *DB) DeleteWebhook(id, userID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("webhook %d: %w", id, ErrNotFound)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return fmt.Errorf("delete webhook deliveries: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts,
+	next_attempt_at, response_code, last_error, created_at, delivered_at`
+
+func scanDelivery(s scanner) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	err := s.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.ResponseCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	return d, err
+}
+
+// CreateWebhookDelivery queues a delivery for immediate sending
+func (db *DB) CreateWebhookDelivery(d *WebhookDelivery) error {
+	d.Status = DeliveryPending
+	d.NextAttemptAt = sql.NullTime{Time: time.Now(), Valid: true}
+	result, err := db.Exec(`
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		d.WebhookID, d.EventID, d.EventType, d.Payload, d.Status, nullTime(d.NextAttemptAt),
+	)
+	if err != nil {
+		return fmt.Errorf("insert webhook delivery: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("get last insert id: %w", err)
+	}
+	d.ID = id
+	d.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+func (db *DB) GetWebhookDelivery(id int64) (*WebhookDelivery, error) {
+	d, err := scanDelivery(db.QueryRow(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query webhook delivery: %w", err)
+	}
+	return d, nil
+}
+
+// ListWebhookDeliveries returns a webhook's most recent deliveries, newest first
+func (db *DB) ListWebhookDeliveries(webhookID int64, limit int) ([]*WebhookDelivery, error) {
+	return db.queryDeliveries(`
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, webhookID, limit,
+	)
+}
+
+// ListDueWebhookDeliveries returns pending deliveries whose next attempt is at or before now
+func (db *DB) ListDueWebhookDeliveries(now time.Time, limit int) ([]*WebhookDelivery, error) {
+	return db.queryDeliveries(`
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ?`,
+		DeliveryPending, sqlTime(now), limit,
+	)
+}
+
+func (db *DB) queryDeliveries(query string, args ...any) ([]*WebhookDelivery, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDeliveryAttempt stores the outcome of a delivery attempt
+func (db *DB) UpdateWebhookDeliveryAttempt(d *WebhookDelivery) error {
+	_, err := db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, next_attempt_at = ?, response_code = ?, last_error = ?, delivered_at = ?
+		WHERE id = ?`,
+		d.Status, d.Attempts, nullTime(d.NextAttemptAt), d.ResponseCode, d.LastError, nullTime(d.DeliveredAt), d.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhookDeliveriesBefore prunes finished deliveries created before t
+func (db *DB) DeleteWebhookDeliveriesBefore(t time.Time) error {
+	_, err := db.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`, DeliveryPending, sqlTime(t))
+	if err != nil {
+		return fmt.Errorf("delete webhook deliveries: %w", err)
+	}
+	return nil
+}
@@ -79,6 +79,39 @@ func (c *Client) CreateSSHSecret(ctx context.Context, namespace string, authoriz
 	return nil
 }
 
+// GetSSHSecret returns the authorized_keys in a container's SSH secret
+func (c *Client) GetSSHSecret(ctx context.Context, namespace string) (string, error) {
+	secret, err := c.clientset.CoreV1().Secrets(namespace).Get(ctx, "ssh-keys", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get ssh secret: %w", err)
+	}
+	return string(secret.Data["authorized_keys"]), nil
+}
+
+// UpdateSSHSecret replaces the authorized_keys in a container's SSH secret, creating
+// the secret if it's missing. Running pods see the change without restarting.
+func (c *Client) UpdateSSHSecret(ctx context.Context, namespace string, authorizedKeys string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ssh-keys",
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			"authorized_keys": authorizedKeys,
+		},
+	}
+
+	_, err := c.clientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if errors.IsNotFound(err) {
+		_, err = c.clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("update ssh secret: %w", err)
+	}
+	return nil
+}
+
 // CreatePVC creates a persistent volume claim for container storage
 func (c *Client) CreatePVC(ctx context.Context, namespace string, storageGB int) error {
 	storageClassName := "local-path"
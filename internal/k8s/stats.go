@@ -0,0 +1,114 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrStatsForbidden is returned when the service account can't read kubelet stats.
+// The stats summary is served through the node proxy, so the account needs a
+// ClusterRole granting get on nodes/proxy.
+var ErrStatsForbidden = stderrors.New("reading kubelet stats needs get on nodes/proxy")
+
+// VolumeStats holds usage for a single pod volume as reported by the kubelet
+type VolumeStats struct {
+	UsedBytes     uint64
+	CapacityBytes uint64
+}
+
+// PodStats is the subset of the kubelet stats summary we care about
+type PodStats struct {
+	// CPUNanoCores is the recent average CPU usage in billionths of a core
+	CPUNanoCores uint64
+	// NetworkRxBytes and NetworkTxBytes are cumulative counters for the pod's default interface
+	NetworkRxBytes uint64
+	NetworkTxBytes uint64
+	Volumes        map[string]VolumeStats
+}
+
+// summary mirrors the parts of the kubelet /stats/summary response we read
+type summary struct {
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		CPU *struct {
+			UsageNanoCores *uint64 `json:"usageNanoCores"`
+		} `json:"cpu"`
+		Network *struct {
+			RxBytes *uint64 `json:"rxBytes"`
+			TxBytes *uint64 `json:"txBytes"`
+		} `json:"network"`
+		Volume []struct {
+			Name          string  `json:"name"`
+			UsedBytes     *uint64 `json:"usedBytes"`
+			CapacityBytes *uint64 `json:"capacityBytes"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+// GetPodStats reads the container pod's stats from its node's kubelet summary
+// Returns nil if the pod is not scheduled or the kubelet has no stats for it yet
+func (c *Client) GetPodStats(ctx context.Context, namespace string) (*PodStats, error) {
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, "container", metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get pod: %w", err)
+	}
+	if pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+
+	raw, err := c.clientset.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", pod.Spec.NodeName, "proxy", "stats", "summary").
+		DoRaw(ctx)
+	if err != nil {
+		if errors.IsForbidden(err) {
+			return nil, fmt.Errorf("%w: %v", ErrStatsForbidden, err)
+		}
+		return nil, fmt.Errorf("get stats summary: %w", err)
+	}
+
+	var s summary
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("decode stats summary: %w", err)
+	}
+
+	for _, p := range s.Pods {
+		if p.PodRef.Namespace != namespace || p.PodRef.Name != "container" {
+			continue
+		}
+		stats := &PodStats{Volumes: make(map[string]VolumeStats)}
+		if p.CPU != nil && p.CPU.UsageNanoCores != nil {
+			stats.CPUNanoCores = *p.CPU.UsageNanoCores
+		}
+		if p.Network != nil {
+			if p.Network.RxBytes != nil {
+				stats.NetworkRxBytes = *p.Network.RxBytes
+			}
+			if p.Network.TxBytes != nil {
+				stats.NetworkTxBytes = *p.Network.TxBytes
+			}
+		}
+		for _, v := range p.Volume {
+			var vs VolumeStats
+			if v.UsedBytes != nil {
+				vs.UsedBytes = *v.UsedBytes
+			}
+			if v.CapacityBytes != nil {
+				vs.CapacityBytes = *v.CapacityBytes
+			}
+			stats.Volumes[v.Name] = vs
+		}
+		return stats, nil
+	}
+	return nil, nil
+}
@@ -1,25 +1,93 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
+	_ "time/tzdata" // schedules use IANA timezones and the runtime image has no zoneinfo
 
 	"eddisonso.com/edd-compute/internal/api"
+	"eddisonso.com/edd-compute/internal/auth"
 	"eddisonso.com/edd-compute/internal/db"
 	"eddisonso.com/edd-compute/internal/k8s"
 	"eddisonso.com/go-gfs/pkg/gfslog"
 )
 
+// minAPIKeyPepperBytes is the shortest API key pepper the server accepts
+const minAPIKeyPepperBytes = 32
+
 func main() {
 	addr := flag.String("addr", ":8080", "HTTP listen address")
+	debugAddr := flag.String("debug-addr", "localhost:8081", "Listen address for /debug/vars metrics, kept off the API listener (empty disables)")
 	dbPath := flag.String("db", "/data/compute.db", "SQLite database path")
 	logService := flag.String("log-service", "", "Log service address")
+	diskPollInterval := flag.Duration("disk-poll-interval", 5*time.Minute, "How often to sample container disk usage")
+	diskThresholds := flag.String("disk-alert-thresholds", "80,95", "Comma-separated disk usage percentages that raise an event")
+	reaperInterval := flag.Duration("reaper-interval", time.Minute, "How often to check for expired containers")
+	expiryWarning := flag.Duration("expiry-warning", time.Hour, "How long before expiry to warn about a container")
+	scheduleInterval := flag.Duration("schedule-interval", 30*time.Second, "How often to run due container schedules")
+	idleInterval := flag.Duration("idle-check-interval", time.Minute, "How often to check containers for activity")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Default idle period before a container is stopped (0 disables)")
+	idleCPU := flag.Uint64("idle-cpu-millicores", 50, "CPU usage below which a container counts as idle")
+	idleNetwork := flag.Uint64("idle-network-bytes", 1024, "Network bytes per second below which a container counts as idle")
+	legacyUser := flag.String("legacy-user", "", "SFS username that owns containers and keys created before users had their own IDs")
+	apiKeyMaxLifetime := flag.Duration("api-key-max-lifetime", 0, "Longest an API key can be valid; keys created without an expiry get this one (0 allows keys that never expire)")
+	apiKeyPepperFile := flag.String("api-key-pepper-file", "", "File holding the secret API keys are hashed with, at least 32 bytes; changing it invalidates existing eddc_ keys. Without one, keys are hashed with no server-side secret.")
+	sfsURL := flag.String("sfs-url", auth.DefaultSessionConfig.URL, "SFS base URL that session cookies are validated against")
+	sessionCookie := flag.String("session-cookie", auth.DefaultSessionConfig.CookieName, "Name of the SFS session cookie")
+	sessionTimeout := flag.Duration("session-timeout", auth.DefaultSessionConfig.Timeout, "Timeout for each session check against SFS")
+	sessionCacheTTL := flag.Duration("session-cache-ttl", auth.DefaultSessionConfig.CacheTTL, "How long a valid session is cached")
+	sessionNegativeTTL := flag.Duration("session-negative-ttl", auth.DefaultSessionConfig.NegativeTTL, "How long an invalid session is cached")
+	sessionMaxStale := flag.Duration("session-max-stale", auth.DefaultSessionConfig.MaxStale, "How long past its TTL a cached session is still accepted while SFS is failing (0 disables)")
+	sessionCacheSize := flag.Int("session-cache-size", auth.DefaultSessionConfig.CacheSize, "Maximum number of cached sessions")
+	oidcIssuer := flag.String("oidc-issuer", "", "OIDC issuer whose JWT bearer tokens are accepted (empty disables JWT authentication)")
+	oidcAudience := flag.String("oidc-audience", "edd-compute", "Audience JWTs must be issued for")
+	oidcJWKSURL := flag.String("oidc-jwks-url", "", "URL of the issuer's signing keys (default: discovered from the issuer)")
+	readRate := flag.Float64("rate-limit-read", 600, "Read requests a minute each user or API key may make (0 disables)")
+	readBurst := flag.Int("rate-limit-read-burst", 120, "Read requests allowed in a burst")
+	writeRate := flag.Float64("rate-limit-write", 120, "Mutating requests a minute each user or API key may make (0 disables)")
+	writeBurst := flag.Int("rate-limit-write-burst", 30, "Mutating requests allowed in a burst")
+	expensiveRate := flag.Float64("rate-limit-expensive", 10, "Container creates and deletes a minute each user or API key may make (0 disables)")
+	expensiveBurst := flag.Int("rate-limit-expensive-burst", 5, "Container creates and deletes allowed in a burst")
+	adminUsers := flag.String("admin-users", "", "Comma-separated SFS usernames that can see every user's audit log")
+	trustForwardedFor := flag.Bool("trust-forwarded-for", false, "Record the client address from X-Forwarded-For in the audit log; only safe behind a proxy that sets it")
+	forwardAudit := flag.Bool("audit-log-forward", true, "Also send audit log entries to the log service")
+	webhookInterval := flag.Duration("webhook-retry-interval", 15*time.Second, "How often to retry failed webhook deliveries")
 	flag.Parse()
 
+	thresholds, err := parseThresholds(*diskThresholds)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -disk-alert-thresholds: %v\n", err)
+		os.Exit(2)
+	}
+	rateLimits := api.RateLimits{
+		Read:      api.RateBudget{PerMinute: *readRate, Burst: *readBurst},
+		Write:     api.RateBudget{PerMinute: *writeRate, Burst: *writeBurst},
+		Expensive: api.RateBudget{PerMinute: *expensiveRate, Burst: *expensiveBurst},
+	}
+	for _, b := range []struct {
+		flag   string
+		budget api.RateBudget
+	}{
+		{"-rate-limit-read", rateLimits.Read},
+		{"-rate-limit-write", rateLimits.Write},
+		{"-rate-limit-expensive", rateLimits.Expensive},
+	} {
+		if err := b.budget.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid %s: %v\n", b.flag, err)
+			os.Exit(2)
+		}
+	}
+
 	// Logger setup
 	logger := gfslog.NewLogger(gfslog.Config{
 		Source:         "edd-compute",
@@ -29,6 +97,34 @@ func main() {
 	slog.SetDefault(logger.Logger)
 	defer logger.Close()
 
+	// The pepper is what keeps a copy of the database from being enough to check keys.
+	// Deployments that don't mount one yet keep the unpeppered hashing they had, so
+	// their keys go on working; eddc_ keys made before one is added stop working then.
+	var apiKeyPepper []byte
+	if *apiKeyPepperFile != "" {
+		b, err := os.ReadFile(*apiKeyPepperFile)
+		if err != nil {
+			slog.Error("failed to read api key pepper", "error", err)
+			os.Exit(1)
+		}
+		apiKeyPepper = []byte(strings.TrimSpace(string(b)))
+		if len(apiKeyPepper) < minAPIKeyPepperBytes {
+			slog.Error("api key pepper is too short", "bytes", len(apiKeyPepper), "min", minAPIKeyPepperBytes)
+			os.Exit(1)
+		}
+	} else {
+		slog.Warn("no -api-key-pepper-file set; API keys are hashed without a server-side secret")
+	}
+
+	var oidc *auth.OIDCConfig
+	if *oidcIssuer != "" {
+		oidc = &auth.OIDCConfig{
+			Issuer:   *oidcIssuer,
+			Audience: *oidcAudience,
+			JWKSURL:  *oidcJWKSURL,
+		}
+	}
+
 	// Database
 	database, err := db.Open(*dbPath)
 	if err != nil {
@@ -37,6 +133,13 @@ func main() {
 	}
 	defer database.Close()
 
+	if *legacyUser != "" {
+		if err := database.ClaimLegacyUser(*legacyUser); err != nil {
+			slog.Error("failed to claim legacy user", "username", *legacyUser, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// K8s client (in-cluster config)
 	k8sClient, err := k8s.NewClient()
 	if err != nil {
@@ -45,15 +148,73 @@ func main() {
 	}
 
 	// HTTP server
-	handler := api.NewHandler(database, k8sClient)
+	handler := api.NewHandler(database, k8sClient, api.Config{
+		APIKeyMaxLifetime: *apiKeyMaxLifetime,
+		APIKeyPepper:      apiKeyPepper,
+		Session: auth.SessionConfig{
+			URL:         *sfsURL,
+			CookieName:  *sessionCookie,
+			Timeout:     *sessionTimeout,
+			CacheTTL:    *sessionCacheTTL,
+			NegativeTTL: *sessionNegativeTTL,
+			MaxStale:    *sessionMaxStale,
+			CacheSize:   *sessionCacheSize,
+		},
+		OIDC:              oidc,
+		RateLimits:        rateLimits,
+		AdminUsers:        splitList(*adminUsers),
+		TrustForwardedFor: *trustForwardedFor,
+		ForwardAudit:      *forwardAudit,
+	})
 	server := &http.Server{Addr: *addr, Handler: handler}
 
+	// Nothing survives a restart to finish operations that were in flight, and
+	// containers they left mid-change are marked failed
+	if n, err := handler.FailInterruptedOperations(); err != nil {
+		slog.Error("failed to clean up interrupted operations", "error", err)
+	} else if n > 0 {
+		slog.Warn("marked interrupted operations as failed", "count", n)
+	}
+
+	// Metrics expose process details, so they're served apart from the API and
+	// its ingress, for internal monitoring only
+	var debugServer *http.Server
+	if *debugAddr != "" {
+		debugMux := http.NewServeMux()
+		debugMux.Handle("GET /debug/vars", expvar.Handler())
+		debugServer = &http.Server{Addr: *debugAddr, Handler: debugMux}
+		go func() {
+			slog.Info("debug listener started", "addr", *debugAddr)
+			if err := debugServer.ListenAndServe(); err != http.ErrServerClosed {
+				slog.Error("debug server error", "error", err)
+			}
+		}()
+	}
+
+	// Background workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handler.RunDiskMonitor(ctx, *diskPollInterval, thresholds)
+	go handler.RunReaper(ctx, *reaperInterval, *expiryWarning)
+	go handler.RunScheduler(ctx, *scheduleInterval)
+	go handler.RunIdleMonitor(ctx, *idleInterval, api.IdleConfig{
+		DefaultTimeout:     *idleTimeout,
+		CPUMillicores:      *idleCPU,
+		NetworkBytesPerSec: *idleNetwork,
+	})
+	go handler.RunWebhookDeliverer(ctx, *webhookInterval)
+	go handler.RunIdempotencyCleanup(ctx, time.Hour)
+
 	// Graceful shutdown
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
 		slog.Info("shutting down")
+		cancel()
+		if debugServer != nil {
+			debugServer.Close()
+		}
 		server.Close()
 	}()
 
@@ -63,3 +224,30 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func parseThresholds(s string) ([]int, error) {
+	var thresholds []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t, err := strconv.Atoi(part)
+		if err != nil || t <= 0 || t > 100 {
+			return nil, fmt.Errorf("%q is not a percentage between 1 and 100", part)
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			items = append(items, part)
+		}
+	}
+	return items
+}
@@ -0,0 +1,23 @@
+package client
+
+import "context"
+
+// ListAuditLog returns a page of the caller's audit log, newest first, and the
+// cursor for the next page, which is empty on the last page
+func (c *Client) ListAuditLog(ctx context.Context, opts AuditListOptions) ([]AuditEntry, string, error) {
+	return c.listAudit(ctx, "/audit", opts)
+}
+
+// ListAllAuditLog is ListAuditLog across every user. Only admins may call it.
+func (c *Client) ListAllAuditLog(ctx context.Context, opts AuditListOptions) ([]AuditEntry, string, error) {
+	return c.listAudit(ctx, "/admin/audit", opts)
+}
+
+func (c *Client) listAudit(ctx context.Context, path string, opts AuditListOptions) ([]AuditEntry, string, error) {
+	var entries []AuditEntry
+	resp, err := c.get(ctx, path, opts.values(), &entries)
+	if err != nil {
+		return nil, "", err
+	}
+	return entries, resp.Header.Get("X-Next-Cursor"), nil
+}
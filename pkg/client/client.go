@@ -0,0 +1,303 @@
+// Package client is a Go client for the edd-compute API.
+//
+//	c, err := client.New(client.Config{
+//		BaseURL: "https://cloud.eddisonso.com",
+//		APIKey:  os.Getenv("EDD_COMPUTE_API_KEY"),
+//	})
+//	op, err := c.CreateContainer(ctx, client.CreateContainerRequest{Name: "dev", SSHKeyIDs: []int64{1}})
+//	container, err := c.WaitForRunning(ctx, op.ContainerID)
+//
+// Failed requests return an *Error carrying the API's error code. Reads, updates and
+// deletes are retried on network errors, 429s and 502-504s; creates are retried
+// too, with an Idempotency-Key so a retry can't create a second resource.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the API version this package speaks
+const apiPrefix = "/compute/v1"
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryWait        = 30 * time.Second
+)
+
+// Config configures a Client. Set one of APIKey or SessionToken.
+type Config struct {
+	// BaseURL is the server's root URL, e.g. "https://cloud.eddisonso.com"
+	BaseURL string
+	// APIKey authenticates as the key's owner
+	APIKey string
+	// BearerToken is an access token from the server's OIDC identity provider
+	BearerToken string
+	// SessionToken is the value of a web session's sfs_session cookie
+	SessionToken string
+
+	// HTTPClient sends requests; http.DefaultClient if nil
+	HTTPClient *http.Client
+	// UserAgent is sent with every request, if set
+	UserAgent string
+	// MaxRetries is how many times a retryable request is retried; 3 if 0, none if negative
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubling after each; 500ms if 0
+	RetryBackoff time.Duration
+}
+
+type Client struct {
+	baseURL      *url.URL
+	apiKey       string
+	bearerToken  string
+	sessionToken string
+	httpClient   *http.Client
+	userAgent    string
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("base url must be http or https: %q", cfg.BaseURL)
+	}
+	credentials := 0
+	for _, c := range []string{cfg.APIKey, cfg.BearerToken, cfg.SessionToken} {
+		if c != "" {
+			credentials++
+		}
+	}
+	if credentials > 1 {
+		return nil, fmt.Errorf("set only one of APIKey, BearerToken and SessionToken")
+	}
+
+	c := &Client{
+		baseURL:      base,
+		apiKey:       cfg.APIKey,
+		bearerToken:  cfg.BearerToken,
+		sessionToken: cfg.SessionToken,
+		httpClient:   cfg.HTTPClient,
+		userAgent:    cfg.UserAgent,
+		maxRetries:   cfg.MaxRetries,
+		retryBackoff: cfg.RetryBackoff,
+	}
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	if c.retryBackoff <= 0 {
+		c.retryBackoff = defaultRetryBackoff
+	}
+	return c, nil
+}
+
+// request describes one API call
+type request struct {
+	method string
+	path   string // Relative to the API prefix
+	query  url.Values
+	body   any
+	// idempotent requests are safe to retry. Creates become idempotent by sending an
+	// Idempotency-Key, which the server uses to replay the first response.
+	idempotent     bool
+	idempotencyKey string
+	// stream leaves the response body open for the caller
+	stream bool
+}
+
+// do sends a request, retrying it if that's safe, and decodes a JSON response into
+// out (if non-nil). The response is returned for its headers.
+func (c *Client) do(ctx context.Context, req request, out any) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	attempts := 1
+	if req.idempotent {
+		attempts += c.maxRetries
+	}
+
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, req, body)
+		if err == nil && resp.StatusCode >= 400 {
+			err = decodeError(resp)
+			resp.Body.Close()
+		}
+		if err == nil {
+			return resp, c.decode(resp, req, out)
+		}
+
+		var apiErr *Error
+		wait := backoff
+		retryable := ctx.Err() == nil
+		if errors.As(err, &apiErr) {
+			retryable = apiErr.retryable()
+			if apiErr.RetryAfter > 0 {
+				wait = apiErr.RetryAfter
+			}
+		}
+		if !retryable || attempt == attempts {
+			return nil, err
+		}
+		backoff *= 2
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(wait, maxRetryWait)):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	u := *c.baseURL
+	u.Path += apiPrefix + req.path
+	u.RawQuery = req.query.Encode()
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), r)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if req.stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	} else {
+		httpReq.Header.Set("Accept", "application/json")
+	}
+	if req.idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", req.idempotencyKey)
+	}
+	if c.userAgent != "" {
+		httpReq.Header.Set("User-Agent", c.userAgent)
+	}
+	switch {
+	case c.apiKey != "":
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	case c.bearerToken != "":
+		httpReq.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	case c.sessionToken != "":
+		httpReq.AddCookie(&http.Cookie{Name: "sfs_session", Value: c.sessionToken})
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", req.method, req.path, err)
+	}
+	return resp, nil
+}
+
+// decode decodes a successful response into out, or discards it if out is nil
+func (c *Client) decode(resp *http.Response, req request, out any) error {
+	if req.stream {
+		return nil
+	}
+
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s %s response: %w", req.method, req.path, err)
+	}
+	return nil
+}
+
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter reads a Retry-After header given in seconds or as an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	s := resp.Header.Get("Retry-After")
+	if s == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(s); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// newIdempotencyKey returns a random key for a create request and its retries
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// get, put and del are shorthands for requests that are always safe to retry
+func (c *Client) get(ctx context.Context, path string, query url.Values, out any) (*http.Response, error) {
+	return c.do(ctx, request{method: http.MethodGet, path: path, query: query, idempotent: true}, out)
+}
+
+func (c *Client) put(ctx context.Context, path string, body, out any) error {
+	_, err := c.do(ctx, request{method: http.MethodPut, path: path, body: body, idempotent: true}, out)
+	return err
+}
+
+func (c *Client) del(ctx context.Context, path string, out any) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: path, idempotent: true}, out)
+	return err
+}
+
+// create sends a POST that creates a resource. With idempotencyKey set it's retried,
+// and the same key makes every retry return the first attempt's result.
+func (c *Client) create(ctx context.Context, path, idempotencyKey string, body, out any) error {
+	_, err := c.do(ctx, request{
+		method:         http.MethodPost,
+		path:           path,
+		body:           body,
+		idempotent:     idempotencyKey != "",
+		idempotencyKey: idempotencyKey,
+	}, out)
+	return err
+}
+
+// post sends a POST that isn't safe to retry
+func (c *Client) post(ctx context.Context, path string, body, out any) error {
+	_, err := c.do(ctx, request{method: http.MethodPost, path: path, body: body}, out)
+	return err
+}
+
+func pathID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
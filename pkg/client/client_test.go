@@ -0,0 +1,448 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for srv that retries without waiting long
+func newTestClient(t *testing.T, srv *httptest.Server, cfg Config) *Client {
+	t.Helper()
+	cfg.BaseURL = srv.URL
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Millisecond
+	}
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(`{"error":{"code":"` + code + `","message":"` + message + `","request_id":"req-1"}}`))
+}
+
+func TestNewConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"api key", Config{BaseURL: "https://example.com", APIKey: "k"}, false},
+		{"no credentials", Config{BaseURL: "http://localhost:8080/"}, false},
+		{"two credentials", Config{BaseURL: "https://example.com", APIKey: "k", SessionToken: "s"}, true},
+		{"unsupported scheme", Config{BaseURL: "ftp://example.com"}, true},
+		{"relative url", Config{BaseURL: "example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantAuth   string
+		wantCookie string
+	}{
+		{name: "api key", cfg: Config{APIKey: "eddc_abc"}, wantAuth: "Bearer eddc_abc"},
+		{name: "bearer token", cfg: Config{BearerToken: "jwt"}, wantAuth: "Bearer jwt"},
+		{name: "session", cfg: Config{SessionToken: "sess"}, wantCookie: "sess"},
+		{name: "anonymous", cfg: Config{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				w.Write([]byte(`[]`))
+			}))
+			defer srv.Close()
+
+			tt.cfg.UserAgent = "eddc-test"
+			c := newTestClient(t, srv, tt.cfg)
+			if _, _, err := c.ListSSHKeys(context.Background(), ListOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.URL.Path != "/compute/v1/ssh-keys" {
+				t.Errorf("path = %q, want /compute/v1/ssh-keys", got.URL.Path)
+			}
+			if auth := got.Header.Get("Authorization"); auth != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", auth, tt.wantAuth)
+			}
+			var cookie string
+			if ck, err := got.Cookie("sfs_session"); err == nil {
+				cookie = ck.Value
+			}
+			if cookie != tt.wantCookie {
+				t.Errorf("sfs_session cookie = %q, want %q", cookie, tt.wantCookie)
+			}
+			if ua := got.Header.Get("User-Agent"); ua != "eddc-test" {
+				t.Errorf("User-Agent = %q, want eddc-test", ua)
+			}
+			if accept := got.Header.Get("Accept"); accept != "application/json" {
+				t.Errorf("Accept = %q, want application/json", accept)
+			}
+		})
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		wantStatus    int
+		wantCode      string
+		wantMessage   string
+		wantRequestID string
+	}{
+		{
+			name: "error envelope",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeAPIError(w, http.StatusNotFound, CodeNotFound, "container not found")
+			},
+			wantStatus:    http.StatusNotFound,
+			wantCode:      CodeNotFound,
+			wantMessage:   "container not found",
+			wantRequestID: "req-1",
+		},
+		{
+			name: "request id from header",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-ID", "hdr-1")
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"error":{"code":"invalid_state","message":"container is running"}}`))
+			},
+			wantStatus:    http.StatusConflict,
+			wantCode:      CodeInvalidState,
+			wantMessage:   "container is running",
+			wantRequestID: "hdr-1",
+		},
+		{
+			name: "proxy error page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`<html>oops</html>`))
+			},
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    CodeInternal,
+			wantMessage: "Internal Server Error",
+		},
+		{
+			name: "client error without envelope",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			},
+			wantStatus:  http.StatusMethodNotAllowed,
+			wantCode:    CodeInvalidRequest,
+			wantMessage: "Method Not Allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			c := newTestClient(t, srv, Config{})
+			_, err := c.GetContainer(context.Background(), "c1")
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want *Error", err)
+			}
+			if apiErr.StatusCode != tt.wantStatus || apiErr.Code != tt.wantCode || apiErr.Message != tt.wantMessage {
+				t.Errorf("error = %d %q %q, want %d %q %q",
+					apiErr.StatusCode, apiErr.Code, apiErr.Message, tt.wantStatus, tt.wantCode, tt.wantMessage)
+			}
+			if apiErr.RequestID != tt.wantRequestID {
+				t.Errorf("RequestID = %q, want %q", apiErr.RequestID, tt.wantRequestID)
+			}
+		})
+	}
+}
+
+func TestErrorHelpers(t *testing.T) {
+	err := error(&Error{StatusCode: http.StatusNotFound, Code: CodeNotFound})
+	if !IsNotFound(err) || IsInvalidState(err) || IsLimitExceeded(err) {
+		t.Errorf("helpers misclassify %v", err)
+	}
+	if IsNotFound(errors.New("not found")) {
+		t.Error("IsNotFound matched a non-API error")
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	pages := map[string]struct {
+		body string
+		next string
+	}{
+		"":   {`[{"id":"c1","name":"web-1"},{"id":"c2","name":"web-2"}]`, "p2"},
+		"p2": {`[{"id":"c3","name":"web-3"}]`, "p3"},
+		"p3": {`[{"id":"c4","name":"web"}]`, ""},
+	}
+	var cursors []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if !strings.HasPrefix(q.Get("name_prefix"), "web") || q.Get("sort") != SortName || q.Get("limit") != "200" {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		cursor := q.Get("cursor")
+		cursors = append(cursors, cursor)
+		page, ok := pages[cursor]
+		if !ok {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid cursor")
+			return
+		}
+		if page.next != "" {
+			w.Header().Set("X-Next-Cursor", page.next)
+		}
+		w.Write([]byte(page.body))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{})
+	container, err := c.FindContainer(context.Background(), "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.ID != "c4" {
+		t.Errorf("found %q, want c4", container.ID)
+	}
+	if len(cursors) != 3 || cursors[1] != "p2" || cursors[2] != "p3" {
+		t.Errorf("cursors sent = %q, want [\"\" p2 p3]", cursors)
+	}
+
+	if _, err := c.FindContainer(context.Background(), "webx"); !IsNotFound(err) {
+		t.Errorf("FindContainer of a missing name = %v, want not_found", err)
+	}
+}
+
+func TestListReturnsNextCursor(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("cursor") != "abc" || q.Get("status") != "running" || q.Get("org_id") != "7" {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		w.Header().Set("X-Next-Cursor", "def")
+		w.Write([]byte(`[{"id":"c1"}]`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{})
+	opts := ContainerListOptions{ListOptions: ListOptions{Cursor: "abc", OrgID: 7}, Status: "running"}
+	containers, next, err := c.ListContainers(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || next != "def" {
+		t.Errorf("got %d containers and cursor %q, want 1 and def", len(containers), next)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures are the statuses returned before the request succeeds
+		failures   []int
+		call       func(*Client) error
+		maxRetries int
+		wantCalls  int32
+		wantErr    bool
+	}{
+		{
+			name:      "429 on a read",
+			failures:  []int{http.StatusTooManyRequests},
+			call:      getContainer,
+			wantCalls: 2,
+		},
+		{
+			name:      "gateway errors",
+			failures:  []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+			call:      getContainer,
+			wantCalls: 4,
+		},
+		{
+			name:       "gives up after max retries",
+			failures:   []int{503, 503, 503},
+			call:       getContainer,
+			maxRetries: 2,
+			wantCalls:  3,
+			wantErr:    true,
+		},
+		{
+			name:       "retries disabled",
+			failures:   []int{http.StatusTooManyRequests},
+			call:       getContainer,
+			maxRetries: -1,
+			wantCalls:  1,
+			wantErr:    true,
+		},
+		{
+			name:      "client errors aren't retried",
+			failures:  []int{http.StatusBadRequest},
+			call:      getContainer,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "non-idempotent posts aren't retried",
+			failures:  []int{http.StatusTooManyRequests},
+			call:      startContainer,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				if n <= len(tt.failures) {
+					w.Header().Set("Retry-After", "0")
+					writeAPIError(w, tt.failures[n-1], CodeInternal, "try again")
+					return
+				}
+				w.Write([]byte(`{"id":"c1"}`))
+			}))
+			defer srv.Close()
+
+			c := newTestClient(t, srv, Config{MaxRetries: tt.maxRetries})
+			err := tt.call(c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("server saw %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func getContainer(c *Client) error {
+	_, err := c.GetContainer(context.Background(), "c1")
+	return err
+}
+
+func startContainer(c *Client) error {
+	_, err := c.StartContainer(context.Background(), "c1")
+	return err
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	var first time.Time
+	var waited time.Duration
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			writeAPIError(w, http.StatusTooManyRequests, CodeRateLimited, "slow down")
+			return
+		}
+		waited = time.Since(first)
+		w.Write([]byte(`{"id":"c1"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{})
+	if err := getContainer(c); err != nil {
+		t.Fatal(err)
+	}
+	if waited < time.Second {
+		t.Errorf("retried after %s, want at least the 1s from Retry-After", waited)
+	}
+}
+
+func TestRateLimitedErrorCarriesRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		writeAPIError(w, http.StatusTooManyRequests, CodeRateLimited, "slow down")
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{MaxRetries: -1})
+	err := getContainer(c)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Code != CodeRateLimited {
+		t.Fatalf("error = %v, want rate_limited", err)
+	}
+	if apiErr.RetryAfter != 7*time.Second {
+		t.Errorf("RetryAfter = %s, want 7s", apiErr.RetryAfter)
+	}
+}
+
+func TestCreateRetriesWithSameIdempotencyKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			writeAPIError(w, http.StatusServiceUnavailable, CodeInternal, "unavailable")
+			return
+		}
+		if len(keys) == 2 {
+			writeAPIError(w, http.StatusConflict, CodeIdempotencyBusy, "still in progress")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"op1","container_id":"c1"}`))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{})
+	op, err := c.CreateContainer(context.Background(), CreateContainerRequest{Name: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.ContainerID != "c1" {
+		t.Errorf("ContainerID = %q, want c1", op.ContainerID)
+	}
+	if len(keys) != 3 || keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("Idempotency-Key per attempt = %q, want one key repeated 3 times", keys)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.value != "" {
+			resp.Header.Set("Retry-After", tt.value)
+		}
+		got, ok := retryAfter(resp)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	resp := &http.Response{Header: http.Header{"Retry-After": {future}}}
+	if got, ok := retryAfter(resp); !ok || got <= 0 || got > time.Minute {
+		t.Errorf("retryAfter(%q) = %s, %v; want up to a minute", future, got, ok)
+	}
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// containerPollInterval is how often WaitForRunning checks on a container
+const containerPollInterval = 2 * time.Second
+
+// ListContainers returns a page of containers and the cursor for the next page,
+// which is empty on the last page
+func (c *Client) ListContainers(ctx context.Context, opts ContainerListOptions) ([]Container, string, error) {
+	var containers []Container
+	next, err := c.listContainers(ctx, "/containers", opts, &containers)
+	if err != nil {
+		return nil, "", err
+	}
+	return containers, next, nil
+}
+
+func (c *Client) listContainers(ctx context.Context, path string, opts ContainerListOptions, out any) (string, error) {
+	q := opts.values()
+	if opts.Status != "" {
+		q.Set("status", opts.Status)
+	}
+	if opts.Image != "" {
+		q.Set("image", opts.Image)
+	}
+
+	resp, err := c.get(ctx, path, q, out)
+	if err != nil {
+		return "", err
+	}
+	return resp.Header.Get("X-Next-Cursor"), nil
+}
+
+func (c *Client) GetContainer(ctx context.Context, id string) (*Container, error) {
+	var container Container
+	if _, err := c.get(ctx, "/containers/"+id, nil, &container); err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// FindContainer returns the container with the given name
+func (c *Client) FindContainer(ctx context.Context, name string) (*Container, error) {
+	opts := ContainerListOptions{ListOptions: ListOptions{NamePrefix: name, Sort: SortName, Limit: 200}}
+	for {
+		containers, next, err := c.ListContainers(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range containers {
+			if containers[i].Name == name {
+				return &containers[i], nil
+			}
+		}
+		if next == "" {
+			return nil, &Error{StatusCode: 404, Code: CodeNotFound, Message: fmt.Sprintf("container %q not found", name)}
+		}
+		opts.Cursor = next
+	}
+}
+
+// CreateContainer starts creating a container. The returned operation's ContainerID
+// identifies the new container; see WaitOperation and WaitForRunning.
+func (c *Client) CreateContainer(ctx context.Context, req CreateContainerRequest) (*Operation, error) {
+	var op Operation
+	if err := c.create(ctx, "/containers", newIdempotencyKey(), req, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// DeleteContainer starts deleting a container
+func (c *Client) DeleteContainer(ctx context.Context, id string) (*Operation, error) {
+	var op Operation
+	if err := c.del(ctx, "/containers/"+id, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// StartContainer starts a stopped container
+func (c *Client) StartContainer(ctx context.Context, id string) (*Operation, error) {
+	var op Operation
+	if err := c.post(ctx, "/containers/"+id+"/start", nil, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// StopContainer stops a running container, keeping its storage
+func (c *Client) StopContainer(ctx context.Context, id string) (*Operation, error) {
+	var op Operation
+	if err := c.post(ctx, "/containers/"+id+"/stop", nil, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// ExtendContainer sets a new expiry for a container
+func (c *Client) ExtendContainer(ctx context.Context, id string, req ExtendRequest) (*Container, error) {
+	var container Container
+	if err := c.post(ctx, "/containers/"+id+"/extend", req, &container); err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// SetContainerIdleTimeout overrides the account's idle timeout for one container.
+// nil clears the override; 0 disables idle stops.
+func (c *Client) SetContainerIdleTimeout(ctx context.Context, id string, minutes *int64) (*Container, error) {
+	var container Container
+	body := map[string]*int64{"idle_timeout_minutes": minutes}
+	if err := c.put(ctx, "/containers/"+id+"/idle-timeout", body, &container); err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+// GetContainerDiskUsage returns recent disk usage samples, newest first
+func (c *Client) GetContainerDiskUsage(ctx context.Context, id string, limit int) ([]DiskUsage, error) {
+	var usage []DiskUsage
+	if _, err := c.get(ctx, "/containers/"+id+"/disk-usage", limitQuery(limit), &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// WaitForRunning waits until a container is running and reachable over SSH, and
+// returns it. It fails if the container fails, stops or is deleted first.
+func (c *Client) WaitForRunning(ctx context.Context, id string) (*Container, error) {
+	ticker := time.NewTicker(containerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		container, err := c.GetContainer(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		switch container.Status {
+		case StatusRunning:
+			if container.ExternalIP != nil {
+				return container, nil
+			}
+		case StatusFailed, StatusStopped, StatusStopping, StatusDeleting:
+			return container, fmt.Errorf("container %s is %s", id, container.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Error codes the API returns in Error.Code. Match on these rather than on
+// messages, which may change.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeInvalidState     = "invalid_state"
+	CodeLimitExceeded    = "limit_exceeded"
+	CodeIdempotencyReuse = "idempotency_key_reused"
+	CodeIdempotencyBusy  = "idempotency_key_in_progress"
+	CodeIdempotencyDone  = "idempotency_key_completed"
+	CodeUnprocessable    = "unprocessable"
+	CodeRateLimited      = "rate_limited"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeInternal         = "internal"
+)
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	// Details holds extra context for some codes, e.g. "field" for invalid_request
+	// or "limit" for limit_exceeded
+	Details map[string]any
+	// RequestID matches the request to the server's logs
+	RequestID string
+	// RetryAfter is how long the server asked us to wait before retrying, if it said
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("edd-compute: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+	if e.RequestID != "" {
+		msg += ", request id " + e.RequestID
+	}
+	return msg
+}
+
+func (e *Error) retryable() bool {
+	return retryableStatus(e.StatusCode) || e.Code == CodeIdempotencyBusy
+}
+
+// IsNotFound reports whether err is a not_found error from the API
+func IsNotFound(err error) bool {
+	return hasCode(err, CodeNotFound)
+}
+
+// IsInvalidState reports whether err says a container can't make the requested
+// state change, e.g. starting a container that's already running
+func IsInvalidState(err error) bool {
+	return hasCode(err, CodeInvalidState)
+}
+
+// IsLimitExceeded reports whether err says a quota, such as containers per user, is full
+func IsLimitExceeded(err error) bool {
+	return hasCode(err, CodeLimitExceeded)
+}
+
+func hasCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// decodeError reads an error response. Responses that didn't come from the API, such
+// as a proxy's error page, still produce an *Error with the status.
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	if d, ok := retryAfter(resp); ok {
+		apiErr.RetryAfter = d
+	}
+
+	var body struct {
+		Error struct {
+			Code      string         `json:"code"`
+			Message   string         `json:"message"`
+			Details   map[string]any `json:"details"`
+			RequestID string         `json:"request_id"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(data, &body); err == nil && body.Error.Code != "" {
+		apiErr.Code = body.Error.Code
+		apiErr.Message = body.Error.Message
+		apiErr.Details = body.Error.Details
+		if body.Error.RequestID != "" {
+			apiErr.RequestID = body.Error.RequestID
+		}
+		return apiErr
+	}
+
+	apiErr.Code = CodeInternal
+	if resp.StatusCode < 500 {
+		apiErr.Code = CodeInvalidRequest
+	}
+	apiErr.Message = http.StatusText(resp.StatusCode)
+	return apiErr
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ListContainerEvents returns a container's lifecycle events, newest first
+func (c *Client) ListContainerEvents(ctx context.Context, id string, limit int) ([]Event, error) {
+	var events []Event
+	if _, err := c.get(ctx, "/containers/"+id+"/events", limitQuery(limit), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// StreamEvents calls fn for each of the account's events as they happen, until ctx
+// is cancelled, the connection drops or fn returns an error. Events after
+// lastEventID are replayed first; pass 0 to start from now. To resume after a
+// dropped connection, call again with the last event's ID.
+func (c *Client) StreamEvents(ctx context.Context, lastEventID int64, fn func(Event) error) error {
+	q := url.Values{}
+	if lastEventID > 0 {
+		q.Set("last_event_id", strconv.FormatInt(lastEventID, 10))
+	}
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "/events/stream", query: q, idempotent: true, stream: true}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line ends an event
+			if data.Len() == 0 {
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(data.String()), &e); err != nil {
+				return fmt.Errorf("decode event: %w", err)
+			}
+			data.Reset()
+			if err := fn(e); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// id, event and retry fields are implied by the JSON, and ":" lines are heartbeats
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read event stream: %w", err)
+	}
+	return fmt.Errorf("event stream closed")
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"net/url"
+)
+
+// ListSharedContainers returns a page of the containers others have shared with
+// you and the cursor for the next page, which is empty on the last page
+func (c *Client) ListSharedContainers(ctx context.Context, opts ContainerListOptions) ([]SharedContainer, string, error) {
+	var containers []SharedContainer
+	next, err := c.listContainers(ctx, "/shared-containers", opts, &containers)
+	if err != nil {
+		return nil, "", err
+	}
+	return containers, next, nil
+}
+
+// ListContainerGrants returns who a container is shared with
+func (c *Client) ListContainerGrants(ctx context.Context, id string) ([]ContainerGrant, error) {
+	var grants []ContainerGrant
+	if _, err := c.get(ctx, "/containers/"+id+"/grants", nil, &grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// GrantContainerAccess shares a container with a user, who must have signed in at
+// least once. Their SSH keys are added to the container.
+func (c *Client) GrantContainerAccess(ctx context.Context, id, username string) (*ContainerGrant, error) {
+	var grant ContainerGrant
+	if err := c.put(ctx, "/containers/"+id+"/grants/"+url.PathEscape(username), nil, &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// RevokeContainerAccess stops sharing a container with a user and removes their
+// SSH keys from it. You can always revoke your own access.
+func (c *Client) RevokeContainerAccess(ctx context.Context, id, username string) error {
+	return c.del(ctx, "/containers/"+id+"/grants/"+url.PathEscape(username), nil)
+}
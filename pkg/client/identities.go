@@ -0,0 +1,23 @@
+package client
+
+import "context"
+
+// ListIdentities returns the SSO identities linked to your account
+func (c *Client) ListIdentities(ctx context.Context) ([]Identity, error) {
+	var identities []Identity
+	if _, err := c.get(ctx, "/identities", nil, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// LinkIdentity makes SSO tokens for the identity in token act as you. The client
+// must be using a SessionToken: a web session is what proves who you are on SFS.
+// Until an identity is linked, its tokens act as a separate account of its own.
+func (c *Client) LinkIdentity(ctx context.Context, token string) (*Identity, error) {
+	var identity Identity
+	if err := c.post(ctx, "/identities", map[string]string{"token": token}, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
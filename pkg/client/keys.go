@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ListSSHKeys returns a page of SSH keys and the cursor for the next page, which
+// is empty on the last page
+func (c *Client) ListSSHKeys(ctx context.Context, opts ListOptions) ([]SSHKey, string, error) {
+	var keys []SSHKey
+	resp, err := c.get(ctx, "/ssh-keys", opts.values(), &keys)
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, resp.Header.Get("X-Next-Cursor"), nil
+}
+
+// AddSSHKey registers a public key, in authorized_keys format, for new containers
+func (c *Client) AddSSHKey(ctx context.Context, name, publicKey string) (*SSHKey, error) {
+	return c.AddOrgSSHKey(ctx, 0, name, publicKey)
+}
+
+// AddOrgSSHKey registers a public key with an org. It's added to all of the org's
+// containers, including running ones. With orgID 0 it's AddSSHKey.
+func (c *Client) AddOrgSSHKey(ctx context.Context, orgID int64, name, publicKey string) (*SSHKey, error) {
+	var key SSHKey
+	body := map[string]any{"name": name, "public_key": publicKey}
+	if orgID != 0 {
+		body["org_id"] = orgID
+	}
+	if err := c.create(ctx, "/ssh-keys", newIdempotencyKey(), body, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (c *Client) DeleteSSHKey(ctx context.Context, id int64) error {
+	return c.del(ctx, "/ssh-keys/"+pathID(id), nil)
+}
+
+// ListAPIKeys returns a page of API keys and the cursor for the next page, which
+// is empty on the last page. Keys themselves aren't included.
+func (c *Client) ListAPIKeys(ctx context.Context, opts ListOptions) ([]APIKey, string, error) {
+	var keys []APIKey
+	resp, err := c.get(ctx, "/api-keys", opts.values(), &keys)
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, resp.Header.Get("X-Next-Cursor"), nil
+}
+
+// CreateAPIKey creates an API key. The key is in the result's Key field and can't
+// be retrieved again. A key can't be given scopes or containers its creator lacks.
+func (c *Client) CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest) (*APIKey, error) {
+	var key APIKey
+	if err := c.create(ctx, "/api-keys", newIdempotencyKey(), req, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RotateAPIKey issues a replacement for a key, with the same name, scopes and
+// containers. The new key is in the result's Key field; the old one keeps working
+// for the grace period.
+func (c *Client) RotateAPIKey(ctx context.Context, id int64, req RotateAPIKeyRequest) (*APIKey, error) {
+	return c.RotateOrgAPIKey(ctx, 0, id, req)
+}
+
+// RotateOrgAPIKey is RotateAPIKey for one of an org's keys
+func (c *Client) RotateOrgAPIKey(ctx context.Context, orgID, id int64, req RotateAPIKeyRequest) (*APIKey, error) {
+	var key APIKey
+	_, err := c.do(ctx, request{
+		method:         http.MethodPost,
+		path:           "/api-keys/" + pathID(id) + "/rotate",
+		query:          orgQuery(orgID),
+		body:           req,
+		idempotent:     true,
+		idempotencyKey: newIdempotencyKey(),
+	}, &key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeAPIKey stops a key from working. The key stays in the list, with the
+// reason if one is given.
+func (c *Client) RevokeAPIKey(ctx context.Context, id int64, reason string) error {
+	return c.RevokeOrgAPIKey(ctx, 0, id, reason)
+}
+
+// RevokeOrgAPIKey is RevokeAPIKey for one of an org's keys
+func (c *Client) RevokeOrgAPIKey(ctx context.Context, orgID, id int64, reason string) error {
+	query := orgQuery(orgID)
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: "/api-keys/" + pathID(id), query: query, idempotent: true}, nil)
+	return err
+}
+
+// orgQuery names an org's resource, or your own with orgID 0
+func orgQuery(orgID int64) url.Values {
+	q := url.Values{}
+	if orgID != 0 {
+		q.Set("org_id", pathID(orgID))
+	}
+	return q
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// operationWait is how long each WaitOperation request asks the server to hold it
+const operationWait = 30 * time.Second
+
+// OperationError is returned by WaitOperation when the operation fails
+type OperationError struct {
+	Operation *Operation
+}
+
+func (e *OperationError) Error() string {
+	msg := "unknown error"
+	if e.Operation.Error != nil {
+		msg = *e.Operation.Error
+	}
+	return fmt.Sprintf("operation %s (%s) failed: %s", e.Operation.ID, e.Operation.Type, msg)
+}
+
+// ListOperations returns the most recent operations, newest first
+func (c *Client) ListOperations(ctx context.Context, limit int) ([]Operation, error) {
+	var ops []Operation
+	if _, err := c.get(ctx, "/operations", limitQuery(limit), &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func (c *Client) GetOperation(ctx context.Context, id string) (*Operation, error) {
+	var op Operation
+	if _, err := c.get(ctx, "/operations/"+id, nil, &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// WaitOperation waits for an operation to finish. A failed operation is returned
+// along with an *OperationError.
+func (c *Client) WaitOperation(ctx context.Context, id string) (*Operation, error) {
+	q := url.Values{"wait": {operationWait.String()}}
+	for {
+		var op Operation
+		if _, err := c.get(ctx, "/operations/"+id, q, &op); err != nil {
+			return nil, err
+		}
+		switch op.Status {
+		case OperationSucceeded:
+			return &op, nil
+		case OperationFailed:
+			return &op, &OperationError{Operation: &op}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
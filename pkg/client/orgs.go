@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"net/url"
+)
+
+// ListOrgs returns the orgs you belong to, with your role in each
+func (c *Client) ListOrgs(ctx context.Context) ([]Org, error) {
+	var orgs []Org
+	if _, err := c.get(ctx, "/orgs", nil, &orgs); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// CreateOrg creates an org with you as its owner
+func (c *Client) CreateOrg(ctx context.Context, name string) (*Org, error) {
+	var org Org
+	if err := c.post(ctx, "/orgs", map[string]string{"name": name}, &org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (c *Client) ListOrgMembers(ctx context.Context, orgID int64) ([]OrgMember, error) {
+	var members []OrgMember
+	if _, err := c.get(ctx, "/orgs/"+pathID(orgID)+"/members", nil, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SetOrgMember adds a user to an org with role, one of the Role* constants, or
+// changes their role. The user must have signed in at least once.
+func (c *Client) SetOrgMember(ctx context.Context, orgID int64, username, role string) (*OrgMember, error) {
+	var member OrgMember
+	if err := c.put(ctx, "/orgs/"+pathID(orgID)+"/members/"+url.PathEscape(username), map[string]string{"role": role}, &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveOrgMember takes a user out of an org. Anyone can remove themselves.
+func (c *Client) RemoveOrgMember(ctx context.Context, orgID int64, username string) error {
+	return c.del(ctx, "/orgs/"+pathID(orgID)+"/members/"+url.PathEscape(username), nil)
+}
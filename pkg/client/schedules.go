@@ -0,0 +1,50 @@
+package client
+
+import "context"
+
+func (c *Client) ListSchedules(ctx context.Context, containerID string) ([]Schedule, error) {
+	var schedules []Schedule
+	if _, err := c.get(ctx, "/containers/"+containerID+"/schedules", nil, &schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// CreateSchedule adds a cron schedule that starts or stops a container
+func (c *Client) CreateSchedule(ctx context.Context, containerID string, req ScheduleRequest) (*Schedule, error) {
+	var schedule Schedule
+	if err := c.post(ctx, "/containers/"+containerID+"/schedules", req, &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (c *Client) UpdateSchedule(ctx context.Context, containerID string, id int64, req ScheduleRequest) (*Schedule, error) {
+	var schedule Schedule
+	if err := c.put(ctx, "/containers/"+containerID+"/schedules/"+pathID(id), req, &schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (c *Client) DeleteSchedule(ctx context.Context, containerID string, id int64) error {
+	return c.del(ctx, "/containers/"+containerID+"/schedules/"+pathID(id), nil)
+}
+
+// GetSettings returns the account's settings
+func (c *Client) GetSettings(ctx context.Context) (*Settings, error) {
+	var settings Settings
+	if _, err := c.get(ctx, "/settings", nil, &settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings replaces the account's settings
+func (c *Client) UpdateSettings(ctx context.Context, settings Settings) (*Settings, error) {
+	var updated Settings
+	if err := c.put(ctx, "/settings", settings, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"eddisonso.com/edd-compute/internal/api"
+	"eddisonso.com/edd-compute/internal/auth"
+	"eddisonso.com/edd-compute/internal/db"
+)
+
+var testPepper = []byte("client-test-pepper-client-test-pepper")
+
+// newServerClient runs the real API against a fresh database and returns a client
+// signed in with an API key for a new user, along with the server's URL
+func newServerClient(t *testing.T) (*Client, string) {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), "compute.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	srv := httptest.NewServer(api.NewHandler(database, nil, api.Config{APIKeyPepper: testPepper}))
+	t.Cleanup(srv.Close)
+
+	user, err := database.GetOrCreateUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, keyID, hash, err := auth.NewAPIKeyHasher(testPepper).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &db.APIKey{
+		UserID:  user.ID,
+		KeyID:   sql.NullString{String: keyID, Valid: true},
+		KeyHash: hash,
+		Name:    "test",
+		Scopes:  []string{ScopeAll},
+	}
+	if err := database.CreateAPIKey(key, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestClient(t, srv, Config{APIKey: plaintext}), srv.URL
+}
+
+// withAPIKey returns a client for the same server using another key
+func withAPIKey(t *testing.T, baseURL, key string) *Client {
+	t.Helper()
+	c, err := New(Config{BaseURL: baseURL, APIKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testPublicKey(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, 51)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(b) + " test@example"
+}
+
+func wantAPIError(t *testing.T, err error, status int, code string) *Error {
+	t.Helper()
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want an *Error", err)
+	}
+	if apiErr.StatusCode != status || apiErr.Code != code {
+		t.Fatalf("error = %d %s, want %d %s", apiErr.StatusCode, apiErr.Code, status, code)
+	}
+	if apiErr.Message == "" || apiErr.RequestID == "" {
+		t.Errorf("error = %+v, want a message and request id", apiErr)
+	}
+	return apiErr
+}
+
+func TestServerSSHKeys(t *testing.T) {
+	c, _ := newServerClient(t)
+	ctx := context.Background()
+
+	first, err := c.AddSSHKey(ctx, "laptop", testPublicKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 || first.Name != "laptop" || first.Fingerprint == "" || first.CreatedAt.IsZero() || first.OrgID != nil {
+		t.Errorf("AddSSHKey() = %+v", first)
+	}
+	second, err := c.AddSSHKey(ctx, "desktop", testPublicKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One key per page
+	keys, next, err := c.ListSSHKeys(ctx, ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || next == "" {
+		t.Fatalf("ListSSHKeys() = %d keys, next %q; want 1 and a cursor", len(keys), next)
+	}
+	rest, next, err := c.ListSSHKeys(ctx, ListOptions{Limit: 1, Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || next != "" || rest[0].ID == keys[0].ID {
+		t.Fatalf("ListSSHKeys() second page = %+v, next %q", rest, next)
+	}
+
+	_, err = c.AddSSHKey(ctx, "bad", "ssh-ed25519 not-base64!")
+	wantAPIError(t, err, http.StatusBadRequest, CodeInvalidRequest)
+
+	if err := c.DeleteSSHKey(ctx, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	err = c.DeleteSSHKey(ctx, first.ID)
+	wantAPIError(t, err, http.StatusNotFound, CodeNotFound)
+	if !IsNotFound(err) {
+		t.Error("IsNotFound() = false for a deleted key")
+	}
+
+	keys, _, err = c.ListSSHKeys(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID != second.ID {
+		t.Errorf("ListSSHKeys() after delete = %+v, want only %d", keys, second.ID)
+	}
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	c, baseURL := newServerClient(t)
+	ctx := context.Background()
+
+	created, err := c.CreateAPIKey(ctx, CreateAPIKeyRequest{Name: "ci", Scopes: []string{ScopeSSHKeysRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == nil || created.Prefix == nil || created.Status != APIKeyActive || created.CreatedAt.IsZero() {
+		t.Fatalf("CreateAPIKey() = %+v, want the key, a prefix and active", created)
+	}
+	if len(created.Scopes) != 1 || created.Scopes[0] != ScopeSSHKeysRead {
+		t.Errorf("Scopes = %v, want [%s]", created.Scopes, ScopeSSHKeysRead)
+	}
+
+	// The new key can only do what its scopes allow
+	ci := withAPIKey(t, baseURL, *created.Key)
+	if _, _, err := ci.ListSSHKeys(ctx, ListOptions{}); err != nil {
+		t.Fatalf("ListSSHKeys() with the new key: %v", err)
+	}
+	_, err = ci.AddSSHKey(ctx, "laptop", testPublicKey(t))
+	wantAPIError(t, err, http.StatusForbidden, CodeForbidden)
+
+	keys, _, err := c.ListAPIKeys(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("ListAPIKeys() = %d keys, want 2", len(keys))
+	}
+	for _, k := range keys {
+		if k.Key != nil {
+			t.Errorf("ListAPIKeys() returned the secret of key %d", k.ID)
+		}
+	}
+
+	rotated, err := c.RotateAPIKey(ctx, created.ID, RotateAPIKeyRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Key == nil || rotated.ID == created.ID || rotated.Name != "ci" {
+		t.Fatalf("RotateAPIKey() = %+v", rotated)
+	}
+	keys, _, err = c.ListAPIKeys(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k.ID == created.ID && (k.Status != APIKeyRotated || k.ReplacedBy == nil || *k.ReplacedBy != rotated.ID) {
+			t.Errorf("rotated key = %+v, want rotated and replaced by %d", k, rotated.ID)
+		}
+	}
+
+	// The old key works through its grace period; the new one works too
+	if _, _, err := ci.ListSSHKeys(ctx, ListOptions{}); err != nil {
+		t.Errorf("ListSSHKeys() with the rotated key: %v", err)
+	}
+	if _, _, err := withAPIKey(t, baseURL, *rotated.Key).ListSSHKeys(ctx, ListOptions{}); err != nil {
+		t.Errorf("ListSSHKeys() with the replacement key: %v", err)
+	}
+
+	if err := c.RevokeAPIKey(ctx, rotated.ID, "leaked"); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = withAPIKey(t, baseURL, *rotated.Key).ListSSHKeys(ctx, ListOptions{})
+	wantAPIError(t, err, http.StatusUnauthorized, CodeUnauthorized)
+
+	err = c.RevokeAPIKey(ctx, rotated.ID, "")
+	wantAPIError(t, err, http.StatusNotFound, CodeNotFound)
+}
+
+func TestServerErrors(t *testing.T) {
+	c, baseURL := newServerClient(t)
+	ctx := context.Background()
+
+	_, err := c.GetContainer(ctx, "does-not-exist")
+	wantAPIError(t, err, http.StatusNotFound, CodeNotFound)
+
+	anonymous := withAPIKey(t, baseURL, "")
+	_, _, err = anonymous.ListSSHKeys(ctx, ListOptions{})
+	wantAPIError(t, err, http.StatusUnauthorized, CodeUnauthorized)
+
+	_, _, err = withAPIKey(t, baseURL, "eddc_not_a_real_key").ListSSHKeys(ctx, ListOptions{})
+	wantAPIError(t, err, http.StatusUnauthorized, CodeUnauthorized)
+
+	_, err = c.CreateAPIKey(ctx, CreateAPIKeyRequest{Name: ""})
+	apiErr := wantAPIError(t, err, http.StatusBadRequest, CodeInvalidRequest)
+	if apiErr.Details["field"] != "name" {
+		t.Errorf("Details = %v, want field name", apiErr.Details)
+	}
+}
+
+func TestServerUnroutedErrors(t *testing.T) {
+	_, baseURL := newServerClient(t)
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodGet, "/compute/v1/nothing-here", http.StatusNotFound, CodeNotFound},
+		{http.MethodPut, "/compute/v1/ssh-keys", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, baseURL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error struct {
+				Code      string `json:"code"`
+				RequestID string `json:"request_id"`
+			} `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s %s: decode body: %v", tt.method, tt.path, err)
+		}
+		if resp.StatusCode != tt.status || body.Error.Code != tt.code || body.Error.RequestID == "" {
+			t.Errorf("%s %s = %d %+v, want %d %s", tt.method, tt.path, resp.StatusCode, body.Error, tt.status, tt.code)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s Content-Type = %q", tt.method, tt.path, ct)
+		}
+		if tt.status == http.StatusMethodNotAllowed && resp.Header.Get("Allow") == "" {
+			t.Errorf("%s %s has no Allow header", tt.method, tt.path)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package client
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Container states
+const (
+	StatusProvisioning = "provisioning"
+	StatusStarting     = "starting"
+	StatusRunning      = "running"
+	StatusStopping     = "stopping"
+	StatusStopped      = "stopped"
+	StatusDeleting     = "deleting"
+	StatusFailed       = "failed"
+)
+
+// Operation states
+const (
+	OperationPending   = "pending"
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+)
+
+// What happens to a container when its TTL runs out
+const (
+	OnExpireDelete = "delete"
+	OnExpireStop   = "stop"
+)
+
+type Container struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	ExternalIP *string `json:"external_ip"`
+	// SSHCommand is how to log in, e.g. "ssh root@203.0.113.7", once the container has an IP
+	SSHCommand       *string    `json:"ssh_command,omitempty"`
+	MemoryMB         int        `json:"memory_mb"`
+	StorageGB        int        `json:"storage_gb"`
+	StorageUsedBytes *int64     `json:"storage_used_bytes"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	OnExpire         string     `json:"on_expire,omitempty"`
+	// IdleTimeoutMinutes overrides the account's idle timeout; 0 means never stop for idleness
+	IdleTimeoutMinutes *int64 `json:"idle_timeout_minutes,omitempty"`
+	// OrgID is the org that owns the container, or nil for your own
+	OrgID     *int64    `json:"org_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SharedContainer is a container someone else shared with you. You can start,
+// stop and SSH into it with your own SSH keys, but not change or delete it.
+type SharedContainer struct {
+	Container
+	// GrantedBy is the username of whoever shared it
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// ContainerGrant is another user's access to a container
+type ContainerGrant struct {
+	ContainerID string    `json:"container_id"`
+	UserID      int64     `json:"user_id"`
+	Username    string    `json:"username"`
+	GrantedBy   string    `json:"granted_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateContainerRequest struct {
+	Name      string  `json:"name"`
+	MemoryMB  int     `json:"memory_mb,omitempty"`
+	StorageGB int     `json:"storage_gb,omitempty"`
+	SSHKeyIDs []int64 `json:"ssh_key_ids,omitempty"`
+	// Set at most one of TTLSeconds and ExpiresAt for the container to expire
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	// OnExpire is OnExpireDelete (the default) or OnExpireStop
+	OnExpire           string `json:"on_expire,omitempty"`
+	IdleTimeoutMinutes *int64 `json:"idle_timeout_minutes,omitempty"`
+	// OrgID creates the container for an org, which needs the admin role. All of
+	// the org's SSH keys are installed, so SSHKeyIDs is optional; any it names
+	// must be org keys.
+	OrgID int64 `json:"org_id,omitempty"`
+}
+
+// ExtendRequest sets a new expiry: TTLSeconds from now, or ExpiresAt
+type ExtendRequest struct {
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Operation tracks a long-running change such as creating or stopping a container
+type Operation struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	ContainerID string `json:"container_id"`
+	Status      string `json:"status"`
+	Progress    int    `json:"progress"`
+	Message     string `json:"message"`
+	// Result is the operation's output once it succeeds; for container operations, a Container
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *string         `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	DoneAt    *time.Time      `json:"done_at,omitempty"`
+}
+
+// Done reports whether the operation has finished, successfully or not
+func (o *Operation) Done() bool {
+	return o.Status == OperationSucceeded || o.Status == OperationFailed
+}
+
+type Event struct {
+	ID          int64     `json:"id"`
+	ContainerID string    `json:"container_id"`
+	Type        string    `json:"type"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type DiskUsage struct {
+	UsedBytes     int64     `json:"used_bytes"`
+	CapacityBytes int64     `json:"capacity_bytes"`
+	UsedPercent   float64   `json:"used_percent"`
+	RecordedAt    time.Time `json:"recorded_at"`
+}
+
+type SSHKey struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	// OrgID is the org that owns the key, or nil for your own
+	OrgID     *int64    `json:"org_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// API key states. Rotated keys keep working until their grace period ends.
+const (
+	APIKeyActive  = "active"
+	APIKeyRotated = "rotated"
+	APIKeyExpired = "expired"
+	APIKeyRevoked = "revoked"
+)
+
+// API key scopes. ScopeAll grants everything.
+const (
+	ScopeAll             = "*"
+	ScopeContainersRead  = "containers:read"
+	ScopeContainersWrite = "containers:write"
+	ScopeSSHKeysRead     = "ssh-keys:read"
+	ScopeSSHKeysWrite    = "ssh-keys:write"
+	ScopeAPIKeysManage   = "api-keys:manage"
+	ScopeWebhooksManage  = "webhooks:manage"
+	ScopeSettingsRead    = "settings:read"
+	ScopeSettingsWrite   = "settings:write"
+	ScopeAuditRead       = "audit:read"
+	ScopeOrgsRead        = "orgs:read"
+	ScopeOrgsWrite       = "orgs:write"
+)
+
+type APIKey struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Key is only returned when the key is created
+	Key *string `json:"key,omitempty"`
+	// Prefix is the start of the key, e.g. eddc_abcdefghijklm, for telling keys apart
+	Prefix *string  `json:"prefix,omitempty"`
+	Scopes []string `json:"scopes"`
+	// ContainerIDs lists the only containers the key can use, if it's restricted
+	ContainerIDs []string `json:"container_ids,omitempty"`
+	// Status is one of the APIKey* states
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsed      *time.Time `json:"last_used,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason *string    `json:"revoked_reason,omitempty"`
+	// ReplacedBy is the ID of the key issued when this one was rotated
+	ReplacedBy *int64 `json:"replaced_by,omitempty"`
+	// OrgID is the org the key acts for, or nil for your own
+	OrgID *int64 `json:"org_id,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	// Scopes default to the creating key's (or session's) own
+	Scopes []string `json:"scopes,omitempty"`
+	// ContainerIDs restricts the key to these containers
+	ContainerIDs []string `json:"container_ids,omitempty"`
+	// ExpiresAt defaults to never, or the server's maximum key lifetime
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// OrgID makes a key that acts for an org; it can only reach the org's
+	// resources. Needs the admin role.
+	OrgID int64 `json:"org_id,omitempty"`
+}
+
+type RotateAPIKeyRequest struct {
+	// GracePeriodSeconds is how long the old key keeps working; the server default is 24 hours
+	GracePeriodSeconds *int64 `json:"grace_period_seconds,omitempty"`
+	// ExpiresAt defaults to the old key's lifetime, counted from now
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type Settings struct {
+	// IdleTimeoutMinutes stops containers idle this long; nil uses the server default
+	// and 0 disables idle stops
+	IdleTimeoutMinutes *int64 `json:"idle_timeout_minutes"`
+}
+
+type Schedule struct {
+	ID          int64      `json:"id"`
+	ContainerID string     `json:"container_id"`
+	Action      string     `json:"action"`
+	Cron        string     `json:"cron"`
+	Timezone    string     `json:"timezone"`
+	Enabled     bool       `json:"enabled"`
+	NextRunAt   *time.Time `json:"next_run_at"`
+	LastRunAt   *time.Time `json:"last_run_at,omitempty"`
+	LastError   *string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type ScheduleRequest struct {
+	// Action is "start" or "stop"
+	Action   string `json:"action"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"`
+	Enabled  *bool  `json:"enabled,omitempty"`
+}
+
+type Webhook struct {
+	ID  int64  `json:"id"`
+	URL string `json:"url"`
+	// Secret signs deliveries; only returned when the webhook is created
+	Secret     *string   `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhookRequest struct {
+	URL string `json:"url"`
+	// Secret is generated if empty
+	Secret     string   `json:"secret,omitempty"`
+	EventTypes []string `json:"event_types"`
+}
+
+type WebhookDelivery struct {
+	ID            int64      `json:"id"`
+	WebhookID     int64      `json:"webhook_id"`
+	EventID       int64      `json:"event_id"`
+	EventType     string     `json:"event_type"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	ResponseCode  *int64     `json:"response_code,omitempty"`
+	LastError     *string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// Audit entry results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEntry is a mutating request recorded in the audit log
+type AuditEntry struct {
+	ID int64 `json:"id"`
+	// UserID is nil if the request was rejected before the caller was identified
+	UserID     *int64 `json:"user_id,omitempty"`
+	Username   string `json:"username,omitempty"`
+	AuthMethod string `json:"auth_method,omitempty"`
+	APIKeyID   *int64 `json:"api_key_id,omitempty"`
+	SourceIP   string `json:"source_ip"`
+	// Action is the route that handled the request, e.g. "DELETE /containers/{id}"
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id,omitempty"`
+	// Summary is the request's query and body, with secrets redacted
+	Summary    json.RawMessage `json:"summary,omitempty"`
+	StatusCode int             `json:"status_code"`
+	Result     string          `json:"result"`
+	RequestID  string          `json:"request_id,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditListOptions pages through and filters the audit log
+type AuditListOptions struct {
+	Limit int
+	// Cursor is the next cursor returned by the previous page
+	Cursor     string
+	Action     string
+	TargetType string
+	TargetID   string
+	// User limits the admin view to one username
+	User string
+}
+
+func (o *AuditListOptions) values() url.Values {
+	q := limitQuery(o.Limit)
+	for name, v := range map[string]string{
+		"cursor":      o.Cursor,
+		"action":      o.Action,
+		"target_type": o.TargetType,
+		"target_id":   o.TargetID,
+		"user":        o.User,
+	} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	return q
+}
+
+// Sort orders for list calls
+const (
+	SortCreatedAt = "created_at"
+	SortName      = "name"
+)
+
+// Org roles, from most to least privileged. Members can start, stop and SSH into
+// the org's containers; admins can also create and delete them and manage members.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// Identity is an SSO identity linked to your account. Tokens for it act as you.
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Org struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Role is your role in the org
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrgMember struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListOptions pages through and filters the container, SSH key and API key lists.
+// Zero values use the server's defaults: 50 rows, newest first.
+type ListOptions struct {
+	Limit int
+	// Cursor is the next cursor returned by the previous page
+	Cursor string
+	// Sort is SortCreatedAt or SortName
+	Sort string
+	// Ascending reverses the default order (newest first, or A-Z by name)
+	Ascending     *bool
+	NamePrefix    string
+	CreatedBefore time.Time
+	CreatedAfter  time.Time
+	// OrgID lists an org's resources instead of your own
+	OrgID int64
+}
+
+type ContainerListOptions struct {
+	ListOptions
+	Status string
+	Image  string
+}
+
+func (o *ListOptions) values() url.Values {
+	q := url.Values{}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Cursor != "" {
+		q.Set("cursor", o.Cursor)
+	}
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if o.Ascending != nil {
+		if *o.Ascending {
+			q.Set("order", "asc")
+		} else {
+			q.Set("order", "desc")
+		}
+	}
+	if o.NamePrefix != "" {
+		q.Set("name_prefix", o.NamePrefix)
+	}
+	if !o.CreatedBefore.IsZero() {
+		q.Set("created_before", o.CreatedBefore.Format(time.RFC3339))
+	}
+	if !o.CreatedAfter.IsZero() {
+		q.Set("created_after", o.CreatedAfter.Format(time.RFC3339))
+	}
+	if o.OrgID != 0 {
+		q.Set("org_id", strconv.FormatInt(o.OrgID, 10))
+	}
+	return q
+}
+
+func limitQuery(limit int) url.Values {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	return q
+}
@@ -0,0 +1,43 @@
+package client
+
+import "context"
+
+func (c *Client) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var webhooks []Webhook
+	if _, err := c.get(ctx, "/webhooks", nil, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// CreateWebhook registers a URL to receive events. The signing secret is in the
+// result's Secret field and can't be retrieved again.
+func (c *Client) CreateWebhook(ctx context.Context, req WebhookRequest) (*Webhook, error) {
+	var webhook Webhook
+	if err := c.post(ctx, "/webhooks", req, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, id int64) error {
+	return c.del(ctx, "/webhooks/"+pathID(id), nil)
+}
+
+// ListWebhookDeliveries returns a webhook's recent deliveries, newest first
+func (c *Client) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	if _, err := c.get(ctx, "/webhooks/"+pathID(webhookID)+"/deliveries", limitQuery(limit), &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues a new delivery of the same payload
+func (c *Client) RedeliverWebhook(ctx context.Context, webhookID, deliveryID int64) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := c.post(ctx, "/webhooks/"+pathID(webhookID)+"/deliveries/"+pathID(deliveryID)+"/redeliver", nil, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}